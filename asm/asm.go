@@ -0,0 +1,424 @@
+package asm
+
+import (
+	"fmt"
+	"nessie/processor"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Option func(a *assembler)
+
+// Segment is a contiguous chunk of assembled bytes. A new segment gets started by every `.org` or `.segment` directive.
+type Segment struct {
+	Name    string
+	Address uint16
+	Data    []byte
+}
+
+type Program struct {
+	Segments []*Segment
+	Symbols  map[string]uint16
+}
+
+type statement struct {
+	line     int
+	label    string
+	constant string
+	keyword  string
+	operand  string
+	mode     processor.AddressingMode
+}
+
+type assembler struct {
+	opcodes    map[string]map[processor.AddressingMode]processor.Opcode
+	statements []*statement
+	symbols    map[string]int
+	defined    map[string]bool
+	scope      string
+	pc         uint16
+	pass       int
+	final      bool
+	program    *Program
+}
+
+var (
+	labelRegexp    = regexp.MustCompile(`^(@?[A-Za-z_][A-Za-z0-9_]*):`)
+	constantRegexp = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.+)$`)
+)
+
+// WithVariant selects the instruction set which gets assembled, defaulting to the Ricoh 2A03 including all unofficial
+// opcodes. Mnemonics sharing an addressing mode prefer the official opcode, otherwise the lowest opcode gets used.
+func WithVariant(variant processor.Variant) Option {
+	return func(a *assembler) {
+		a.opcodes = opcodeTable(processor.NewCPU(processor.WithVariant(variant)))
+	}
+}
+
+// Assemble translates ca65-style source into machine code. Supported are labels including cheap local `@labels`,
+// constants assigned with `=`, the directives `.org`, `.segment`, `.byte`, `.word`, `.addr` and `.res` as well as
+// expressions using `*` for the current address, `<` and `>` for the low and high byte and `a:` or `z:` prefixes
+// for forcing absolute or zero page addressing.
+func Assemble(source string, options ...Option) (*Program, error) {
+	a := &assembler{}
+	for _, option := range options {
+		option(a)
+	}
+	if a.opcodes == nil {
+		WithVariant(processor.Ricoh2A03)(a)
+	}
+
+	for i, line := range strings.Split(source, "\n") {
+		statement, err := parseStatement(i+1, line)
+		if err != nil {
+			return nil, err
+		}
+		if statement != nil {
+			a.statements = append(a.statements, statement)
+		}
+	}
+
+	// The first pass determines the size of every statement, the second pass resolves constants which depend on
+	// forward references and the final pass emits the actual bytes
+	a.symbols = make(map[string]int)
+	for a.pass = 0; a.pass < 3; a.pass++ {
+		a.final, a.scope, a.pc = a.pass == 2, "", 0
+		a.defined = make(map[string]bool)
+		a.program = &Program{Segments: []*Segment{{}}}
+
+		for _, statement := range a.statements {
+			if err := a.process(statement); err != nil {
+				return nil, fmt.Errorf("line %d: %v", statement.line, err)
+			}
+		}
+	}
+
+	program := a.program
+	program.Symbols = make(map[string]uint16, len(a.symbols))
+	for name, value := range a.symbols {
+		program.Symbols[name] = uint16(value)
+	}
+	return program, nil
+}
+
+func MustAssemble(source string, options ...Option) *Program {
+	program, err := Assemble(source, options...)
+	if err != nil {
+		panic(err)
+	}
+	return program
+}
+
+// Bytes returns the data of all segments concatenated, which is mostly useful for programs with a single segment.
+func (p *Program) Bytes() (data []byte) {
+	for _, segment := range p.Segments {
+		data = append(data, segment.Data...)
+	}
+	return
+}
+
+// Load pokes all segments into memory at their respective addresses.
+func (p *Program) Load(memory processor.Memory) {
+	for _, segment := range p.Segments {
+		for i, value := range segment.Data {
+			memory.Poke(segment.Address+uint16(i), value)
+		}
+	}
+}
+
+func parseStatement(line int, source string) (*statement, error) {
+	text := strings.TrimSpace(stripComment(source))
+	if text == "" {
+		return nil, nil
+	}
+
+	s := &statement{line: line}
+	if matches := labelRegexp.FindStringSubmatch(text); matches != nil {
+		s.label = matches[1]
+		text = strings.TrimSpace(text[len(matches[0]):])
+	}
+	if matches := constantRegexp.FindStringSubmatch(text); matches != nil {
+		s.constant, s.operand = matches[1], strings.TrimSpace(matches[2])
+		return s, nil
+	}
+
+	if text != "" {
+		parts := strings.SplitN(text, " ", 2)
+		s.keyword = strings.ToUpper(parts[0])
+		if len(parts) > 1 {
+			s.operand = strings.TrimSpace(parts[1])
+		}
+	}
+
+	return s, nil
+}
+
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0 && line[i] == quote:
+			quote = 0
+		case quote == 0 && (line[i] == '"' || line[i] == '\''):
+			quote = line[i]
+		case quote == 0 && line[i] == ';':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (a *assembler) process(s *statement) error {
+	if s.label != "" {
+		if err := a.define(s.label, int(a.pc)); err != nil {
+			return err
+		}
+	}
+	if s.constant != "" {
+		value, known, err := a.evaluate(s.operand)
+		if err != nil || !known {
+			return err
+		}
+		return a.define(s.constant, value)
+	}
+
+	switch {
+	case s.keyword == "":
+		return nil
+	case strings.HasPrefix(s.keyword, "."):
+		return a.directive(s)
+	default:
+		return a.instruction(s)
+	}
+}
+
+func (a *assembler) directive(s *statement) error {
+	switch s.keyword {
+	case ".ORG":
+		value, known, err := a.evaluate(s.operand)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return fmt.Errorf("origin must not contain forward references")
+		}
+
+		a.pc = uint16(value)
+		a.startSegment(a.currentSegment().Name)
+	case ".SEGMENT":
+		a.startSegment(strings.Trim(s.operand, `"`))
+	case ".BYTE", ".BYT", ".DB":
+		for _, argument := range splitArguments(s.operand) {
+			if strings.HasPrefix(argument, `"`) {
+				a.emit([]byte(strings.Trim(argument, `"`))...)
+				continue
+			}
+
+			value, err := a.value(argument, 0xFF)
+			if err != nil {
+				return err
+			}
+			a.emit(uint8(value))
+		}
+	case ".WORD", ".ADDR", ".DW":
+		for _, argument := range splitArguments(s.operand) {
+			value, err := a.value(argument, 0xFFFF)
+			if err != nil {
+				return err
+			}
+			a.emit(uint8(value), uint8(value>>8))
+		}
+	case ".RES":
+		arguments := splitArguments(s.operand)
+		count, known, err := a.evaluate(arguments[0])
+		if err != nil {
+			return err
+		}
+		if !known {
+			return fmt.Errorf("reserved size must not contain forward references")
+		}
+
+		fill := 0
+		if len(arguments) > 1 {
+			if fill, err = a.value(arguments[1], 0xFF); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < count; i++ {
+			a.emit(uint8(fill))
+		}
+	default:
+		return fmt.Errorf("unknown directive: %s", s.keyword)
+	}
+
+	return nil
+}
+
+func (a *assembler) instruction(s *statement) error {
+	modes, ok := a.opcodes[s.keyword]
+	if !ok {
+		return fmt.Errorf("unknown mnemonic: %s", s.keyword)
+	}
+
+	syntax, expressions := parseOperand(removeSpaces(s.operand))
+	values := make([]int, len(expressions))
+	known := true
+	for i, expression := range expressions {
+		var isKnown bool
+		var err error
+		values[i], isKnown, err = a.evaluate(strings.TrimPrefix(strings.TrimPrefix(expression, "a:"), "z:"))
+		if err != nil {
+			return err
+		}
+		known = known && isKnown
+	}
+
+	// Addressing modes get chosen during the first pass only, so that statement sizes stay stable across passes
+	if a.pass == 0 {
+		mode, err := selectMode(modes, syntax, expressions, values, known)
+		if err != nil {
+			return err
+		}
+		s.mode = mode
+	}
+
+	a.emit(uint8(modes[s.mode]))
+	switch s.mode {
+	case processor.Implicit, processor.Accumulator:
+	case processor.Relative:
+		offset, err := a.branchOffset(values[0], 2)
+		if err != nil {
+			return err
+		}
+		a.emit(offset)
+	case processor.ZeroPageRelative:
+		zeroPage, err := a.value(strings.TrimPrefix(expressions[0], "z:"), 0xFF)
+		if err != nil {
+			return err
+		}
+		offset, err := a.branchOffset(values[1], 3)
+		if err != nil {
+			return err
+		}
+		a.emit(uint8(zeroPage), offset)
+	default:
+		if processor.OperandLength(s.mode) == 1 {
+			if a.final && (values[0] < -0x80 || values[0] > 0xFF) {
+				return fmt.Errorf("operand out of range: %s", s.operand)
+			}
+			a.emit(uint8(values[0]))
+		} else {
+			if a.final && (values[0] < -0x8000 || values[0] > 0xFFFF) {
+				return fmt.Errorf("operand out of range: %s", s.operand)
+			}
+			a.emit(uint8(values[0]), uint8(values[0]>>8))
+		}
+	}
+
+	return nil
+}
+
+func (a *assembler) branchOffset(target int, length int) (uint8, error) {
+	// The opcode has already been emitted, so the current address points one byte past the start of the instruction
+	offset := target - (int(a.pc) - 1 + length)
+	if a.final && (offset < -128 || offset > 127) {
+		return 0, fmt.Errorf("branch target out of range: %d bytes", offset)
+	}
+	return uint8(offset), nil
+}
+
+// value evaluates an expression which has to be known during the final pass and fit into the given maximum
+func (a *assembler) value(expression string, maximum int) (int, error) {
+	value, known, err := a.evaluate(expression)
+	if err != nil {
+		return 0, err
+	}
+	if a.final && (!known || value < -(maximum+1)/2 || value > maximum) {
+		return 0, fmt.Errorf("value out of range: %s", expression)
+	}
+	return value, nil
+}
+
+func (a *assembler) define(name string, value int) error {
+	if strings.HasPrefix(name, "@") {
+		name = a.scope + name
+	} else {
+		a.scope = name
+	}
+
+	if a.defined[name] {
+		return fmt.Errorf("duplicate symbol: %s", name)
+	}
+	a.defined[name] = true
+	a.symbols[name] = value
+	return nil
+}
+
+func (a *assembler) lookup(name string) (value int, ok bool, err error) {
+	if strings.HasPrefix(name, "@") {
+		name = a.scope + name
+	}
+
+	value, ok = a.symbols[name]
+	if !ok && a.final {
+		return 0, false, fmt.Errorf("undefined symbol: %s", name)
+	}
+	return value, ok, nil
+}
+
+func (a *assembler) currentSegment() *Segment {
+	return a.program.Segments[len(a.program.Segments)-1]
+}
+
+func (a *assembler) startSegment(name string) {
+	segment := a.currentSegment()
+	if len(segment.Data) > 0 {
+		segment = &Segment{}
+		a.program.Segments = append(a.program.Segments, segment)
+	}
+	segment.Name, segment.Address = name, a.pc
+}
+
+func (a *assembler) emit(data ...byte) {
+	segment := a.currentSegment()
+	segment.Data = append(segment.Data, data...)
+	a.pc += uint16(len(data))
+}
+
+func opcodeTable(cpu *processor.CPU) map[string]map[processor.AddressingMode]processor.Opcode {
+	table := make(map[string]map[processor.AddressingMode]processor.Opcode)
+	for opcode := 0xFF; opcode >= 0x00; opcode-- {
+		instruction, ok := cpu.Lookup(processor.Opcode(opcode))
+		if !ok {
+			continue
+		}
+
+		modes, ok := table[instruction.Mnemonic]
+		if !ok {
+			modes = make(map[processor.AddressingMode]processor.Opcode)
+			table[instruction.Mnemonic] = modes
+		}
+
+		// Iterating backwards lets lower opcodes win, unless an official opcode has already been registered
+		mode := instruction.Variant.AddressingMode
+		if existing, ok := modes[mode]; ok {
+			if existingInstruction, _ := cpu.Lookup(existing); !existingInstruction.Unofficial && instruction.Unofficial {
+				continue
+			}
+		}
+		modes[mode] = processor.Opcode(opcode)
+	}
+	return table
+}
+
+// Mnemonics returns all mnemonics which are supported by the given variant in alphabetical order.
+func Mnemonics(variant processor.Variant) []string {
+	table := opcodeTable(processor.NewCPU(processor.WithVariant(variant)))
+	mnemonics := make([]string, 0, len(table))
+	for mnemonic := range table {
+		mnemonics = append(mnemonics, mnemonic)
+	}
+	sort.Strings(mnemonics)
+	return mnemonics
+}
@@ -0,0 +1,163 @@
+package asm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"nessie/processor"
+	"testing"
+)
+
+func testAssemble(t *testing.T, source string, expected []byte, options ...Option) *Program {
+	program, err := Assemble(source, options...)
+	if assert.NoError(t, err, "unexpected error for %q", source) {
+		assert.Equal(t, expected, program.Bytes(), "unexpected bytes for %q", source)
+	}
+	return program
+}
+
+func TestAddressingModes(t *testing.T) {
+	testAssemble(t, "NOP", []byte{0xEA})
+	testAssemble(t, "ASL A", []byte{0x0A})
+	testAssemble(t, "asl", []byte{0x0A})
+	testAssemble(t, "LDA #$42", []byte{0xA9, 0x42})
+	testAssemble(t, "LDA $42", []byte{0xA5, 0x42})
+	testAssemble(t, "LDA $42,X", []byte{0xB5, 0x42})
+	testAssemble(t, "LDX $42,Y", []byte{0xB6, 0x42})
+	testAssemble(t, "LDA $1234", []byte{0xAD, 0x34, 0x12})
+	testAssemble(t, "LDA $1234,X", []byte{0xBD, 0x34, 0x12})
+	testAssemble(t, "LDA $1234, y", []byte{0xB9, 0x34, 0x12})
+	testAssemble(t, "LDA $42,Y", []byte{0xB9, 0x42, 0x00})
+	testAssemble(t, "JMP ($1234)", []byte{0x6C, 0x34, 0x12})
+	testAssemble(t, "LDA ($42,X)", []byte{0xA1, 0x42})
+	testAssemble(t, "LDA ( $42 ), Y", []byte{0xB1, 0x42})
+	testAssemble(t, "LDA a:$42", []byte{0xAD, 0x42, 0x00})
+	testAssemble(t, "LDA (1+2)*3", []byte{0xA5, 0x09})
+	testAssemble(t, ".org $C000\nBNE *", []byte{0xD0, 0xFE})
+}
+
+func TestUnofficialMnemonics(t *testing.T) {
+	testAssemble(t, "LAX ($10),Y", []byte{0xB3, 0x10})
+	testAssemble(t, "SAX $10", []byte{0x87, 0x10})
+	testAssemble(t, "DCP $1234,X", []byte{0xDF, 0x34, 0x12})
+	testAssemble(t, "ISC $10", []byte{0xE7, 0x10})
+	testAssemble(t, "SBC #$10", []byte{0xE9, 0x10})
+	testAssemble(t, "NOP $10", []byte{0x04, 0x10})
+	testAssemble(t, "NOP", []byte{0xEA})
+	testAssemble(t, "KIL", []byte{0x02})
+}
+
+func TestCMOSMnemonics(t *testing.T) {
+	cmos := WithVariant(processor.WDC65C02)
+	testAssemble(t, "BRA *", []byte{0x80, 0xFE}, cmos)
+	testAssemble(t, "LDA ($10)", []byte{0xB2, 0x10}, cmos)
+	testAssemble(t, "JMP ($1234,X)", []byte{0x7C, 0x34, 0x12}, cmos)
+	testAssemble(t, "INC A", []byte{0x1A}, cmos)
+	testAssemble(t, "STZ $10", []byte{0x64, 0x10}, cmos)
+	testAssemble(t, ".org $8000\nBBR0 $10,target\ntarget:", []byte{0x0F, 0x10, 0x00}, cmos)
+
+	_, err := Assemble("LAX $10", cmos)
+	assert.Error(t, err, "expected unofficial mnemonics to be rejected on the 65C02")
+}
+
+func TestLabelsAndExpressions(t *testing.T) {
+	program := testAssemble(t, `
+PPUCTRL = $2000
+		.org $C000
+reset:	LDX #<(table + 1)       ; comment with "quotes"
+		LDY #>table
+@loop:	DEX
+		BNE @loop
+		JMP forward
+forward:
+		STA PPUCTRL
+		LDA zero_page           ; forward reference stays absolute
+		JSR sub
+sub:
+@loop:	RTS
+		.org $C100
+table:	.byte 1, $02, %11, 'A', "hi", -1
+		.word table, reset + 2
+		.res 2, $FF
+zero_page = $10
+size = * - table
+`, []byte{
+		0xA2, 0x01, 0xA0, 0xC1, 0xCA, 0xD0, 0xFD, 0x4C, 0x0A, 0xC0, 0x8D, 0x00, 0x20, 0xAD, 0x10, 0x00,
+		0x20, 0x13, 0xC0, 0x60,
+		0x01, 0x02, 0x03, 0x41, 0x68, 0x69, 0xFF, 0x00, 0xC1, 0x02, 0xC0, 0xFF, 0xFF,
+	})
+
+	assert.Len(t, program.Segments, 2)
+	assert.Equal(t, uint16(0xC100), program.Segments[1].Address)
+	assert.Equal(t, uint16(0xC000), program.Symbols["reset"])
+	assert.Equal(t, uint16(0xC004), program.Symbols["reset@loop"])
+	assert.Equal(t, uint16(0xC013), program.Symbols["sub@loop"])
+	assert.Equal(t, uint16(13), program.Symbols["size"])
+}
+
+func TestSegments(t *testing.T) {
+	program := testAssemble(t, `
+.segment "CODE"
+.org $8000
+NOP
+.segment "VECTORS"
+.org $FFFA
+.word $8000, $8000, $8000
+`, []byte{0xEA, 0x00, 0x80, 0x00, 0x80, 0x00, 0x80})
+
+	if assert.Len(t, program.Segments, 2) {
+		assert.Equal(t, "CODE", program.Segments[0].Name)
+		assert.Equal(t, "VECTORS", program.Segments[1].Name)
+		assert.Equal(t, uint16(0xFFFA), program.Segments[1].Address)
+	}
+
+	memory := processor.NewBasicMemory()
+	program.Load(memory)
+	assert.Equal(t, uint8(0xEA), memory.Peek(0x8000))
+	assert.Equal(t, uint16(0x8000), memory.Peek16(0xFFFC))
+}
+
+func TestErrors(t *testing.T) {
+	testError := func(source string) {
+		_, err := Assemble(source)
+		assert.Error(t, err, "expected error for %q", source)
+	}
+
+	testError("FOO")
+	testError("LDA")
+	testError("STX $10,X")
+	testError("LDA undefined")
+	testError("label:\nlabel:")
+	testError(".org $C000\nBNE far\n.res 200\nfar:")
+	testError("LDA #$100")
+	testError(".byte 256")
+	testError(".foo")
+	testError("JMP ($1234),Y")
+	testError(".org later\nlater:")
+}
+
+func TestRunAssembledProgram(t *testing.T) {
+	program := MustAssemble(`
+		.org $C000
+		LDX #5
+		LDA #0
+@loop:	CLC
+		ADC #3
+		DEX
+		BNE @loop
+		STA $0200
+		KIL
+`)
+
+	cpu := processor.NewCPU()
+	program.Load(cpu.Memory)
+	cpu.Registers.PC = 0xC000
+	_, _, err := cpu.RunUntil(func(cpu *processor.CPU) bool { return false })
+	assert.Equal(t, processor.ErrCPUJammed, err, "expected program to end with KIL")
+
+	assert.Equal(t, uint8(15), cpu.Memory.Peek(0x0200), "unexpected result of assembled program")
+}
+
+func TestMnemonics(t *testing.T) {
+	assert.Contains(t, Mnemonics(processor.Ricoh2A03), "LAX")
+	assert.NotContains(t, Mnemonics(processor.WDC65C02), "LAX")
+	assert.Contains(t, Mnemonics(processor.WDC65C02), "BBS7")
+}
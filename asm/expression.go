@@ -0,0 +1,209 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// evaluator parses and evaluates an expression in a single step. Values of symbols which are not defined yet are
+// treated as unknown during the first pass, which makes the whole expression unknown instead of failing.
+type evaluator struct {
+	source    string
+	pos       int
+	assembler *assembler
+	known     bool
+}
+
+// binaryOperators lists all binary operators from lowest to highest precedence
+var binaryOperators = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/"},
+}
+
+func (a *assembler) evaluate(source string) (value int, known bool, err error) {
+	e := &evaluator{source: source, assembler: a, known: true}
+	e.skipSpace()
+	if e.pos >= len(e.source) {
+		return 0, false, fmt.Errorf("missing expression")
+	}
+
+	value, err = e.parseBinary(0)
+	if err != nil {
+		return 0, false, err
+	}
+
+	e.skipSpace()
+	if e.pos < len(e.source) {
+		return 0, false, fmt.Errorf("unexpected %q in expression %q", e.source[e.pos:], source)
+	}
+
+	return value, e.known, nil
+}
+
+func (e *evaluator) skipSpace() {
+	for e.pos < len(e.source) && unicode.IsSpace(rune(e.source[e.pos])) {
+		e.pos++
+	}
+}
+
+func (e *evaluator) accept(operators ...string) (string, bool) {
+	e.skipSpace()
+	for _, operator := range operators {
+		if strings.HasPrefix(e.source[e.pos:], operator) {
+			e.pos += len(operator)
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+func (e *evaluator) parseBinary(level int) (int, error) {
+	if level >= len(binaryOperators) {
+		return e.parseUnary()
+	}
+
+	left, err := e.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		operator, ok := e.accept(binaryOperators[level]...)
+		if !ok {
+			return left, nil
+		}
+
+		right, err := e.parseBinary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+
+		switch operator {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/":
+			if right == 0 {
+				if e.known {
+					return 0, fmt.Errorf("division by zero")
+				}
+				right = 1
+			}
+			left /= right
+		}
+	}
+}
+
+func (e *evaluator) parseUnary() (int, error) {
+	operator, ok := e.accept("-", "~", "<", ">")
+	if !ok {
+		return e.parsePrimary()
+	}
+
+	value, err := e.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+
+	switch operator {
+	case "-":
+		return -value, nil
+	case "~":
+		return ^value, nil
+	case "<":
+		return value & 0xFF, nil
+	default:
+		return (value >> 8) & 0xFF, nil
+	}
+}
+
+func (e *evaluator) parsePrimary() (int, error) {
+	e.skipSpace()
+	if e.pos >= len(e.source) {
+		return 0, fmt.Errorf("unexpected end of expression %q", e.source)
+	}
+
+	char := e.source[e.pos]
+	switch {
+	case char == '(':
+		e.pos++
+		value, err := e.parseBinary(0)
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := e.accept(")"); !ok {
+			return 0, fmt.Errorf("missing closing parenthesis in expression %q", e.source)
+		}
+		return value, nil
+
+	case char == '*':
+		e.pos++
+		return int(e.assembler.pc), nil
+
+	case char == '\'':
+		if e.pos+2 >= len(e.source) || e.source[e.pos+2] != '\'' {
+			return 0, fmt.Errorf("invalid character literal in expression %q", e.source)
+		}
+		value := int(e.source[e.pos+1])
+		e.pos += 3
+		return value, nil
+
+	case char == '$' || char == '%' || unicode.IsDigit(rune(char)):
+		start, base := e.pos, 10
+		if char == '$' {
+			base, e.pos = 16, e.pos+1
+		} else if char == '%' {
+			base, e.pos = 2, e.pos+1
+		}
+
+		digitsStart := e.pos
+		for e.pos < len(e.source) && isSymbolChar(e.source[e.pos]) {
+			e.pos++
+		}
+
+		value, err := strconv.ParseInt(e.source[digitsStart:e.pos], base, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", e.source[start:e.pos])
+		}
+		return int(value), nil
+
+	case char == '@' || char == '_' || unicode.IsLetter(rune(char)):
+		start := e.pos
+		e.pos++
+		for e.pos < len(e.source) && isSymbolChar(e.source[e.pos]) {
+			e.pos++
+		}
+
+		value, ok, err := e.assembler.lookup(e.source[start:e.pos])
+		if !ok {
+			e.known = false
+		}
+		return value, err
+
+	default:
+		return 0, fmt.Errorf("unexpected %q in expression %q", e.source[e.pos:], e.source)
+	}
+}
+
+func isSymbolChar(char byte) bool {
+	return char == '_' || unicode.IsLetter(rune(char)) || unicode.IsDigit(rune(char))
+}
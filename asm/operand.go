@@ -0,0 +1,174 @@
+package asm
+
+import (
+	"fmt"
+	"nessie/processor"
+	"strings"
+	"unicode"
+)
+
+type syntax int
+
+const (
+	syntaxNone syntax = iota
+	syntaxAccumulator
+	syntaxImmediate
+	syntaxDirect
+	syntaxIndexedX
+	syntaxIndexedY
+	syntaxIndirect
+	syntaxIndirectX
+	syntaxIndirectY
+	syntaxPair
+)
+
+// parseOperand determines the operand syntax of an instruction and extracts the contained expressions
+func parseOperand(operand string) (syntax, []string) {
+	upper := strings.ToUpper(operand)
+
+	switch {
+	case operand == "":
+		return syntaxNone, nil
+	case upper == "A":
+		return syntaxAccumulator, nil
+	case strings.HasPrefix(operand, "#"):
+		return syntaxImmediate, []string{operand[1:]}
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(upper, ",X)"):
+		return syntaxIndirectX, []string{operand[1 : len(operand)-3]}
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(upper, "),Y"):
+		return syntaxIndirectY, []string{operand[1 : len(operand)-3]}
+	case strings.HasPrefix(operand, "(") && closingParenthesis(operand) == len(operand)-1:
+		return syntaxIndirect, []string{operand[1 : len(operand)-1]}
+	case strings.HasSuffix(upper, ",X"):
+		return syntaxIndexedX, []string{operand[:len(operand)-2]}
+	case strings.HasSuffix(upper, ",Y"):
+		return syntaxIndexedY, []string{operand[:len(operand)-2]}
+	}
+
+	if arguments := splitArguments(operand); len(arguments) == 2 {
+		return syntaxPair, arguments
+	}
+	return syntaxDirect, []string{operand}
+}
+
+func selectMode(modes map[processor.AddressingMode]processor.Opcode, syntax syntax, expressions []string,
+	values []int, known bool) (processor.AddressingMode, error) {
+	pick := func(candidates ...processor.AddressingMode) (processor.AddressingMode, error) {
+		for _, candidate := range candidates {
+			if _, ok := modes[candidate]; ok {
+				return candidate, nil
+			}
+		}
+		return 0, fmt.Errorf("invalid addressing mode")
+	}
+
+	// Forward references always use absolute addressing, just like ca65 does, unless zero page is enforced
+	zeroPage := false
+	if len(expressions) > 0 {
+		switch {
+		case strings.HasPrefix(expressions[0], "z:"):
+			zeroPage = true
+		case strings.HasPrefix(expressions[0], "a:"):
+			zeroPage = false
+		default:
+			zeroPage = known && values[0] >= 0 && values[0] <= 0xFF
+		}
+	}
+
+	switch syntax {
+	case syntaxNone:
+		return pick(processor.Implicit, processor.Accumulator)
+	case syntaxAccumulator:
+		return pick(processor.Accumulator)
+	case syntaxImmediate:
+		return pick(processor.Immediate)
+	case syntaxDirect:
+		if _, ok := modes[processor.Relative]; ok {
+			return processor.Relative, nil
+		}
+		if zeroPage {
+			return pick(processor.ZeroPage, processor.Absolute)
+		}
+		return pick(processor.Absolute, processor.ZeroPage)
+	case syntaxIndexedX:
+		if zeroPage {
+			return pick(processor.ZeroPageX, processor.AbsoluteX)
+		}
+		return pick(processor.AbsoluteX, processor.ZeroPageX)
+	case syntaxIndexedY:
+		if zeroPage {
+			return pick(processor.ZeroPageY, processor.AbsoluteY)
+		}
+		return pick(processor.AbsoluteY, processor.ZeroPageY)
+	case syntaxIndirect:
+		return pick(processor.Indirect, processor.ZeroPageIndirect)
+	case syntaxIndirectX:
+		return pick(processor.IndirectX, processor.AbsoluteIndirectX)
+	case syntaxIndirectY:
+		return pick(processor.IndirectY)
+	default:
+		return pick(processor.ZeroPageRelative)
+	}
+}
+
+// closingParenthesis returns the index of the parenthesis closing the one at the start of the value
+func closingParenthesis(value string) int {
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitArguments splits a comma-separated list, ignoring commas within parentheses and quotes
+func splitArguments(value string) (arguments []string) {
+	depth, quote, start := 0, byte(0), 0
+	for i := 0; i < len(value); i++ {
+		switch char := value[i]; {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+		case char == ',' && depth == 0:
+			arguments = append(arguments, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(arguments, strings.TrimSpace(value[start:]))
+}
+
+// removeSpaces strips all whitespace outside of quotes, as it is insignificant within operands
+func removeSpaces(value string) string {
+	var builder strings.Builder
+	quote := byte(0)
+	for i := 0; i < len(value); i++ {
+		char := value[i]
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case unicode.IsSpace(rune(char)):
+			continue
+		}
+		builder.WriteByte(char)
+	}
+	return builder.String()
+}
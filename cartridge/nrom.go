@@ -53,6 +53,34 @@ func (nrom *NROM) Peek(address uint16) (value uint8) {
 	return
 }
 
+func (nrom *NROM) DebugPeek(address uint16) (value uint8) {
+	value = nrom.Peek(address)
+	return
+}
+
+func (nrom *NROM) Cacheable(address uint16) bool {
+	return true
+}
+
+func (nrom *NROM) PRGOffset(address uint16) (offset int, ok bool) {
+	if address < 0x8000 || nrom.BankCountPRG == 0 {
+		return 0, false
+	}
+
+	bank := 0
+	if address >= 0xC000 {
+		bank = int(nrom.BankCountPRG) - 1
+	}
+	return bank*prgBankLength + int(address&0x3FFF), true
+}
+
+func (nrom *NROM) CHROffset(address uint16) (offset int, ok bool) {
+	if address > 0x1FFF || nrom.BankCountCHR == 0 {
+		return 0, false
+	}
+	return int(address), true
+}
+
 func (nrom *NROM) Poke(address uint16, value uint8) (oldValue uint8) {
 	switch {
 	// PPU Banks
@@ -20,6 +20,12 @@ const f7MapperHigh = 0xF << 4
 
 type ROM interface {
 	processor.MemoryMapper
+
+	// PRGOffset translates a CPU address into an offset within PRG ROM, which is the concatenation of all PRG banks in
+	// the order they are stored in the ROM file. Addresses which do not currently map to PRG ROM return false.
+	PRGOffset(address uint16) (offset int, ok bool)
+	// CHROffset translates a PPU address into an offset within CHR ROM, just like PRGOffset does for PRG ROM.
+	CHROffset(address uint16) (offset int, ok bool)
 }
 
 type ROMFile struct {
@@ -0,0 +1,152 @@
+package cdl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"nessie/cartridge"
+	"os"
+)
+
+// Flags of PRG ROM bytes as defined by FCEUX. Bits 2 and 3 contain the 8 KiB window at which the byte was mapped while
+// being accessed, which can be extracted using PRGWindow.
+const (
+	PRGCode         = 1 << 0
+	PRGData         = 1 << 1
+	PRGIndirectCode = 1 << 4
+	PRGIndirectData = 1 << 5
+	PRGPCMData      = 1 << 6
+)
+
+// Flags of CHR ROM bytes as defined by FCEUX
+const (
+	CHRRendered = 1 << 0
+	CHRRead     = 1 << 1
+)
+
+// Log is a code/data log in FCEUX format, containing one byte of flags for every PRG ROM byte followed by one byte for
+// every CHR ROM byte. In addition, the log keeps track of which code bytes have been fetched as opcodes. FCEUX does not
+// distinguish between opcodes and operands, so this information is lost when saving the log.
+type Log struct {
+	PRG []byte
+	CHR []byte
+
+	opcodes []bool
+}
+
+// New returns an empty log matching the PRG and CHR ROM size of the given ROM file.
+func New(rom *cartridge.ROMFile) *Log {
+	var prgSize, chrSize int
+	for _, bank := range rom.BanksPRG {
+		prgSize += len(bank)
+	}
+	for _, bank := range rom.BanksCHR {
+		chrSize += len(bank)
+	}
+
+	return &Log{
+		PRG:     make([]byte, prgSize),
+		CHR:     make([]byte, chrSize),
+		opcodes: make([]bool, prgSize),
+	}
+}
+
+// Load reads a log for the given ROM file from disk.
+func Load(path string, rom *cartridge.ROMFile) (*Log, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open code/data log: %v", err)
+	}
+	defer file.Close()
+
+	log := New(rom)
+	if err := log.Read(file); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// Read replaces the contents of the log with a log in FCEUX format, which must match the size of the log.
+func (l *Log) Read(reader io.Reader) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if len(data) != len(l.PRG)+len(l.CHR) {
+		return fmt.Errorf("code/data log size does not match rom: %d != %d bytes", len(data), len(l.PRG)+len(l.CHR))
+	}
+
+	copy(l.PRG, data)
+	copy(l.CHR, data[len(l.PRG):])
+	for i := range l.opcodes {
+		l.opcodes[i] = false
+	}
+	return nil
+}
+
+func (l *Log) Write(writer io.Writer) error {
+	if _, err := writer.Write(l.PRG); err != nil {
+		return err
+	}
+	_, err := writer.Write(l.CHR)
+	return err
+}
+
+func (l *Log) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create code/data log: %v", err)
+	}
+	if err := l.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Merge combines the flags of another log for the same ROM into this log, e.g. to accumulate multiple sessions.
+func (l *Log) Merge(other *Log) error {
+	if len(other.PRG) != len(l.PRG) || len(other.CHR) != len(l.CHR) {
+		return fmt.Errorf("code/data logs belong to different roms")
+	}
+
+	for i, flags := range other.PRG {
+		l.PRG[i] |= flags
+		l.opcodes[i] = l.opcodes[i] || other.opcodes[i]
+	}
+	for i, flags := range other.CHR {
+		l.CHR[i] |= flags
+	}
+	return nil
+}
+
+// IsOpcode returns true if the PRG ROM byte at the given offset has been fetched as an opcode since the log was created
+// or read.
+func (l *Log) IsOpcode(offset int) bool {
+	return offset >= 0 && offset < len(l.opcodes) && l.opcodes[offset]
+}
+
+// PRGWindow returns the CPU address of the 8 KiB window at which the PRG ROM byte at the given offset was mapped, which
+// is either $8000, $A000, $C000 or $E000. Bytes which have never been accessed return false.
+func (l *Log) PRGWindow(offset int) (address uint16, ok bool) {
+	flags := l.PRG[offset]
+	if flags&(PRGCode|PRGData) == 0 {
+		return 0, false
+	}
+	return 0x8000 | uint16(flags&0x0C)<<11, true
+}
+
+// Coverage returns the fraction of PRG ROM bytes which have been logged as code or data.
+func (l *Log) Coverage() float64 {
+	if len(l.PRG) == 0 {
+		return 0
+	}
+
+	var accessed int
+	for _, flags := range l.PRG {
+		if flags&(PRGCode|PRGData) != 0 {
+			accessed++
+		}
+	}
+	return float64(accessed) / float64(len(l.PRG))
+}
@@ -0,0 +1,83 @@
+package cdl
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"nessie/cartridge"
+	"testing"
+)
+
+func newTestROMFile(t *testing.T, prg []byte) *cartridge.ROMFile {
+	header := []byte{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	data := append(header, prg...)
+	data = append(data, make([]byte, 0x4000+0x2000-len(prg))...)
+
+	rom, err := cartridge.NewROMFile(data)
+	if !assert.NoError(t, err, "could not load test rom") {
+		t.FailNow()
+	}
+	return rom
+}
+
+func TestReadWrite(t *testing.T) {
+	log := New(newTestROMFile(t, nil))
+	assert.Len(t, log.PRG, 0x4000, "unexpected prg size")
+	assert.Len(t, log.CHR, 0x2000, "unexpected chr size")
+
+	log.PRG[0x0010] = PRGCode | 0x0C
+	log.CHR[0x0020] = CHRRendered
+
+	var output bytes.Buffer
+	assert.NoError(t, log.Write(&output))
+	assert.Equal(t, 0x6000, output.Len(), "unexpected file size")
+
+	loaded := New(newTestROMFile(t, nil))
+	assert.NoError(t, loaded.Read(&output))
+	assert.Equal(t, log.PRG, loaded.PRG, "unexpected prg flags")
+	assert.Equal(t, log.CHR, loaded.CHR, "unexpected chr flags")
+
+	assert.Error(t, loaded.Read(bytes.NewReader(make([]byte, 0x4000))), "expected error for wrong size")
+}
+
+func TestMerge(t *testing.T) {
+	rom := newTestROMFile(t, nil)
+	first, second := New(rom), New(rom)
+	first.PRG[0] = PRGCode
+	first.opcodes[0] = true
+	second.PRG[0] = PRGIndirectCode
+	second.PRG[1] = PRGData
+	second.CHR[2] = CHRRead
+
+	assert.NoError(t, first.Merge(second))
+	assert.Equal(t, uint8(PRGCode|PRGIndirectCode), first.PRG[0], "expected flags to be combined")
+	assert.Equal(t, uint8(PRGData), first.PRG[1], "expected flags to be merged")
+	assert.Equal(t, uint8(CHRRead), first.CHR[2], "expected chr flags to be merged")
+	assert.True(t, first.IsOpcode(0), "expected opcodes to be kept")
+
+	other := &Log{PRG: make([]byte, 10)}
+	assert.Error(t, first.Merge(other), "expected error for logs of different roms")
+}
+
+func TestPRGWindow(t *testing.T) {
+	log := New(newTestROMFile(t, nil))
+	log.PRG[0] = PRGCode | 0x04
+	log.PRG[1] = PRGData | 0x0C
+	log.PRG[2] = 0x0C
+
+	window, ok := log.PRGWindow(0)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(0xA000), window, "unexpected window")
+	window, ok = log.PRGWindow(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(0xE000), window, "unexpected window")
+	_, ok = log.PRGWindow(2)
+	assert.False(t, ok, "expected unaccessed byte to have no window")
+}
+
+func TestCoverage(t *testing.T) {
+	log := New(newTestROMFile(t, nil))
+	for i := 0; i < 0x1000; i++ {
+		log.PRG[i] = PRGData
+	}
+	assert.Equal(t, 0.25, log.Coverage(), "unexpected coverage")
+}
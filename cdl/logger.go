@@ -0,0 +1,100 @@
+package cdl
+
+import (
+	"nessie/cartridge"
+	"nessie/processor"
+)
+
+// Logger records all accesses of a CPU to the ROM of a cartridge into a code/data log.
+type Logger struct {
+	Log *Log
+
+	cpu          *processor.CPU
+	rom          cartridge.ROM
+	mode         processor.AddressingMode
+	indirectJump bool
+	hookIDs      []processor.HookID
+}
+
+// Attach starts logging all accesses of the CPU to the given ROM. Opcode and operand fetches are logged as code and
+// regular reads as data, while dummy reads are ignored as they do not reflect the intention of the program. The target
+// of an indirect jump is additionally flagged as indirect code, and data read using indirect addressing as indirect
+// data.
+func Attach(cpu *processor.CPU, rom cartridge.ROM, log *Log) *Logger {
+	l := &Logger{Log: log, cpu: cpu, rom: rom}
+	l.hookIDs = append(l.hookIDs,
+		cpu.OnBeforeInstruction(l.beforeInstruction),
+		cpu.OnAfterInstruction(l.afterInstruction),
+		cpu.OnMemoryAccess(l.memoryAccess),
+		cpu.OnInterrupt(l.interrupt),
+	)
+	return l
+}
+
+func (l *Logger) Detach() {
+	for _, id := range l.hookIDs {
+		l.cpu.RemoveHook(id)
+	}
+	l.hookIDs = nil
+}
+
+// CHRAccess logs an access of the PPU to the given address, which has either been performed for rendering or by the
+// CPU reading PPUDATA. Pattern tables can not be reached by the CPU directly, so the PPU has to report its accesses.
+func (l *Logger) CHRAccess(address uint16, rendered bool) {
+	offset, ok := l.rom.CHROffset(address)
+	if !ok || offset >= len(l.Log.CHR) {
+		return
+	}
+
+	if rendered {
+		l.Log.CHR[offset] |= CHRRendered
+	} else {
+		l.Log.CHR[offset] |= CHRRead
+	}
+}
+
+func (l *Logger) beforeInstruction(pc uint16, opcode processor.Opcode, instruction *processor.Instruction) {
+	l.mode = instruction.Variant.AddressingMode
+
+	// The opcode fetch following an indirect jump is the target of the jump
+	l.indirectJump = instruction.Mnemonic == "JMP" &&
+		(l.mode == processor.Indirect || l.mode == processor.AbsoluteIndirectX)
+}
+
+// afterInstruction forgets the addressing mode, so that reads of interrupt vectors are not mistaken as indirect data
+func (l *Logger) afterInstruction(registers processor.Registers, cycles processor.Cycles) {
+	l.mode = processor.Implicit
+}
+
+func (l *Logger) interrupt(vector uint16) {
+	l.indirectJump = false
+}
+
+func (l *Logger) memoryAccess(access processor.MemoryAccess) {
+	offset, ok := l.rom.PRGOffset(access.Address)
+	if !ok || offset >= len(l.Log.PRG) {
+		if access.Type == processor.AccessOpcode {
+			l.indirectJump = false
+		}
+		return
+	}
+
+	window := uint8(access.Address>>11) & 0x0C
+	switch access.Type {
+	case processor.AccessOpcode:
+		l.Log.PRG[offset] |= PRGCode | window
+		l.Log.opcodes[offset] = true
+		if l.indirectJump {
+			l.Log.PRG[offset] |= PRGIndirectCode
+			l.indirectJump = false
+		}
+	case processor.AccessOperand:
+		l.Log.PRG[offset] |= PRGCode | window
+	case processor.AccessRead:
+		l.Log.PRG[offset] |= PRGData | window
+		switch l.mode {
+		case processor.IndirectX, processor.IndirectY, processor.ZeroPageIndirect:
+			l.Log.PRG[offset] |= PRGIndirectData
+		}
+	}
+}
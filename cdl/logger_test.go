@@ -0,0 +1,81 @@
+package cdl
+
+import (
+	"github.com/stretchr/testify/assert"
+	"nessie/asm"
+	"nessie/cartridge"
+	"nessie/processor"
+	"testing"
+)
+
+const testProgram = `
+.org $C000
+reset:
+  LDA table
+  LDA #<table
+  STA $00
+  LDA #>table
+  STA $01
+  LDA #<indirect
+  STA $02
+  LDA #>indirect
+  STA $03
+  LDY #1
+  LDA ($00),Y
+  JMP ($0002)
+table:
+  .byte $11, $22
+indirect:
+  NOP
+  KIL
+`
+
+func TestLogger(t *testing.T) {
+	program := asm.MustAssemble(testProgram)
+	file := newTestROMFile(t, program.Bytes())
+	rom := cartridge.NewNROM(file)
+
+	cpu := processor.NewCPU()
+	assert.NoError(t, cpu.Memory.AddMappings(rom, processor.MappingCPU))
+	cpu.Registers.PC = 0xC000
+
+	log := New(file)
+	logger := Attach(cpu, rom, log)
+	cpu.RunUntil(func(cpu *processor.CPU) bool { return false })
+	logger.Detach()
+
+	table := int(program.Symbols["table"] - 0xC000)
+	indirect := int(program.Symbols["indirect"] - 0xC000)
+
+	// LDA absolute is logged as code with the window bits of $C000
+	assert.Equal(t, uint8(PRGCode|0x08), log.PRG[0], "unexpected flags of opcode")
+	assert.Equal(t, uint8(PRGCode|0x08), log.PRG[1], "unexpected flags of operand")
+	assert.True(t, log.IsOpcode(0), "expected opcode")
+	assert.False(t, log.IsOpcode(1), "expected operand")
+
+	// Immediate values are operands as well and must not be mistaken for data
+	assert.Equal(t, uint8(PRGCode|0x08), log.PRG[3], "unexpected flags of immediate opcode")
+	assert.Equal(t, uint8(PRGCode|0x08), log.PRG[4], "unexpected flags of immediate operand")
+	assert.False(t, log.IsOpcode(4), "expected immediate operand")
+
+	assert.Equal(t, uint8(PRGData|0x08), log.PRG[table], "unexpected flags of directly read data")
+	assert.Equal(t, uint8(PRGData|PRGIndirectData|0x08), log.PRG[table+1], "unexpected flags of indirectly read data")
+	assert.Equal(t, uint8(PRGCode|PRGIndirectCode|0x08), log.PRG[indirect], "unexpected flags of indirect jump target")
+	assert.Equal(t, uint8(PRGCode|0x08), log.PRG[indirect+1], "unexpected flags of code after jump target")
+
+	// The byte after KIL is only read as a dummy read and must not be logged
+	assert.Equal(t, uint8(0), log.PRG[indirect+2], "expected dummy read to be ignored")
+}
+
+func TestLoggerCHR(t *testing.T) {
+	file := newTestROMFile(t, nil)
+	rom := cartridge.NewNROM(file)
+	log := New(file)
+	logger := Attach(processor.NewCPU(), rom, log)
+
+	logger.CHRAccess(0x0010, true)
+	logger.CHRAccess(0x0011, false)
+	logger.CHRAccess(0x2000, false)
+	assert.Equal(t, uint8(CHRRendered), log.CHR[0x10], "expected rendered flag")
+	assert.Equal(t, uint8(CHRRead), log.CHR[0x11], "expected read flag")
+}
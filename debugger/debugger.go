@@ -0,0 +1,374 @@
+package debugger
+
+import (
+	"fmt"
+	"nessie/processor"
+	"strings"
+)
+
+type Kind int
+type BreakpointID int
+
+const (
+	BreakExecute Kind = iota
+	BreakRead
+	BreakWrite
+	BreakAccess
+	BreakOpcode
+	BreakInterrupt
+)
+
+// AnyInterrupt can be passed as vector to break on every serviced interrupt, regardless of its vector
+const AnyInterrupt uint16 = 0x0000
+
+type Breakpoint struct {
+	ID        BreakpointID
+	Kind      Kind
+	From      uint16
+	To        uint16
+	Opcode    processor.Opcode
+	Vector    uint16
+	Condition *Expression
+	Enabled   bool
+	Hits      int
+
+	// Accesses lists the access types a watchpoint fires on. By default these are only the reads and writes of data,
+	// opcode and operand fetches as well as dummy reads have to be added explicitly.
+	Accesses []processor.AccessType
+}
+
+// Hit describes which breakpoint caused execution to stop. Access is only set for watchpoints and Vector only for
+// interrupt breakpoints, PC always points at the next instruction which is going to be executed.
+type Hit struct {
+	Breakpoint *Breakpoint
+	PC         uint16
+	Cycles     processor.Cycles
+	Access     *processor.MemoryAccess
+	Vector     uint16
+}
+
+// Debugger wraps a CPU and executes it until one of the registered break conditions fires. Execution and opcode
+// breakpoints are checked before an instruction gets executed, whereas watchpoints and interrupt breakpoints are
+// reported once the instruction or interrupt sequence which triggered them has completed.
+type Debugger struct {
+	CPU *processor.CPU
+
+	breakpoints []*Breakpoint
+	nextID      BreakpointID
+	hookIDs     []processor.HookID
+	pending     *Hit
+}
+
+type environment struct {
+	cpu    *processor.CPU
+	access *processor.MemoryAccess
+	vector uint16
+}
+
+func New(cpu *processor.CPU) *Debugger {
+	d := &Debugger{CPU: cpu, nextID: 1}
+	d.hookIDs = append(d.hookIDs,
+		cpu.OnMemoryAccess(d.onMemoryAccess),
+		cpu.OnInterrupt(d.onInterrupt),
+	)
+	return d
+}
+
+// Close detaches the debugger from its CPU by removing all registered hooks.
+func (d *Debugger) Close() {
+	for _, id := range d.hookIDs {
+		d.CPU.RemoveHook(id)
+	}
+	d.hookIDs = nil
+}
+
+func (d *Debugger) AddBreakpoint(address uint16, condition string) (*Breakpoint, error) {
+	return d.add(&Breakpoint{Kind: BreakExecute, From: address, To: address}, condition)
+}
+
+// AddWatchpoint stops execution whenever the CPU accesses an address within the given range. The condition of a
+// watchpoint can additionally refer to `address` and `value` of the triggering access.
+func (d *Debugger) AddWatchpoint(kind Kind, from uint16, to uint16, condition string) (*Breakpoint, error) {
+	if kind != BreakRead && kind != BreakWrite && kind != BreakAccess {
+		return nil, fmt.Errorf("invalid watchpoint kind: %s", KindName(kind))
+	}
+	if from > to {
+		return nil, fmt.Errorf("invalid watchpoint range: $%04X-$%04X", from, to)
+	}
+	var accesses []processor.AccessType
+	if kind != BreakWrite {
+		accesses = append(accesses, processor.AccessRead)
+	}
+	if kind != BreakRead {
+		accesses = append(accesses, processor.AccessWrite)
+	}
+	return d.add(&Breakpoint{Kind: kind, From: from, To: to, Accesses: accesses}, condition)
+}
+
+func (d *Debugger) AddOpcodeBreakpoint(opcode processor.Opcode, condition string) (*Breakpoint, error) {
+	return d.add(&Breakpoint{Kind: BreakOpcode, Opcode: opcode}, condition)
+}
+
+func (d *Debugger) AddInterruptBreakpoint(vector uint16, condition string) (*Breakpoint, error) {
+	return d.add(&Breakpoint{Kind: BreakInterrupt, Vector: vector}, condition)
+}
+
+func (d *Debugger) Remove(id BreakpointID) bool {
+	for i, breakpoint := range d.breakpoints {
+		if breakpoint.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return d.breakpoints
+}
+
+// Step executes a single instruction without checking execution breakpoints at the current program counter, which
+// allows resuming from a previous hit. A non-nil hit is returned if a watchpoint or interrupt breakpoint fired.
+func (d *Debugger) Step() (*Hit, error) {
+	d.pending = nil
+	err := d.CPU.Execute()
+
+	hit := d.pending
+	d.pending = nil
+	if hit != nil {
+		hit.PC = d.CPU.Registers.PC
+	}
+
+	return hit, err
+}
+
+// Run executes instructions until a break condition fires or the CPU returns an error. Just like Step, breakpoints at
+// the current program counter are ignored for the first instruction so that Run can be called again after a hit.
+func (d *Debugger) Run() (*Hit, error) {
+	for first := true; ; first = false {
+		if !first {
+			if hit := d.checkInstruction(); hit != nil {
+				return hit, nil
+			}
+		}
+
+		hit, err := d.Step()
+		if hit != nil || err != nil {
+			return hit, err
+		}
+	}
+}
+
+func (d *Debugger) add(breakpoint *Breakpoint, condition string) (*Breakpoint, error) {
+	if strings.TrimSpace(condition) != "" {
+		expression, err := Compile(condition)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %q: %v", condition, err)
+		}
+		breakpoint.Condition = expression
+	}
+
+	breakpoint.ID = d.nextID
+	breakpoint.Enabled = true
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, breakpoint)
+
+	return breakpoint, nil
+}
+
+func (d *Debugger) checkInstruction() *Hit {
+	pc := d.CPU.Registers.PC
+	opcode := processor.Opcode(d.CPU.Memory.DebugPeek(pc))
+
+	for _, breakpoint := range d.breakpoints {
+		if !breakpoint.Enabled {
+			continue
+		}
+
+		switch {
+		case breakpoint.Kind == BreakExecute && pc >= breakpoint.From && pc <= breakpoint.To:
+		case breakpoint.Kind == BreakOpcode && opcode == breakpoint.Opcode:
+		default:
+			continue
+		}
+
+		if d.matches(breakpoint, environment{cpu: d.CPU}) {
+			return &Hit{Breakpoint: breakpoint, PC: pc, Cycles: d.CPU.TotalCycles}
+		}
+	}
+
+	return nil
+}
+
+func (d *Debugger) onMemoryAccess(access processor.MemoryAccess) {
+	if d.pending != nil {
+		return
+	}
+
+	for _, breakpoint := range d.breakpoints {
+		if !breakpoint.Enabled || !breakpoint.watches(access.Type) {
+			continue
+		}
+		if access.Address < breakpoint.From || access.Address > breakpoint.To {
+			continue
+		}
+
+		if d.matches(breakpoint, environment{cpu: d.CPU, access: &access}) {
+			d.pending = &Hit{Breakpoint: breakpoint, Cycles: access.Cycle, Access: &access}
+			return
+		}
+	}
+}
+
+func (d *Debugger) onInterrupt(vector uint16) {
+	if d.pending != nil {
+		return
+	}
+
+	for _, breakpoint := range d.breakpoints {
+		if !breakpoint.Enabled || breakpoint.Kind != BreakInterrupt {
+			continue
+		}
+		if breakpoint.Vector != AnyInterrupt && breakpoint.Vector != vector {
+			continue
+		}
+
+		if d.matches(breakpoint, environment{cpu: d.CPU, vector: vector}) {
+			d.pending = &Hit{Breakpoint: breakpoint, Cycles: d.CPU.TotalCycles, Vector: vector}
+			return
+		}
+	}
+}
+
+func (b *Breakpoint) watches(accessType processor.AccessType) bool {
+	if b.Kind != BreakRead && b.Kind != BreakWrite && b.Kind != BreakAccess {
+		return false
+	}
+	for _, watched := range b.Accesses {
+		if watched == accessType {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) matches(breakpoint *Breakpoint, env environment) bool {
+	if breakpoint.Condition != nil && !breakpoint.Condition.True(env) {
+		return false
+	}
+
+	breakpoint.Hits++
+	return true
+}
+
+func (e environment) Variable(name string) (value int64, ok bool) {
+	registers := e.cpu.Registers
+
+	switch name {
+	case "a":
+		return int64(registers.A), true
+	case "x":
+		return int64(registers.X), true
+	case "y":
+		return int64(registers.Y), true
+	case "s", "sp":
+		return int64(registers.S), true
+	case "p":
+		return int64(registers.P), true
+	case "pc":
+		return int64(registers.PC), true
+	case "cycles":
+		return int64(e.cpu.TotalCycles), true
+	case "c":
+		return e.flag(processor.FlagCarry), true
+	case "z":
+		return e.flag(processor.FlagZero), true
+	case "i":
+		return e.flag(processor.FlagInterruptDisable), true
+	case "d":
+		return e.flag(processor.FlagDecimal), true
+	case "v":
+		return e.flag(processor.FlagOverflow), true
+	case "n":
+		return e.flag(processor.FlagNegative), true
+	case "vector":
+		return int64(e.vector), true
+	case "address":
+		if e.access != nil {
+			return int64(e.access.Address), true
+		}
+	case "value":
+		if e.access != nil {
+			return int64(e.access.Value), true
+		}
+	}
+
+	return 0, false
+}
+
+func (e environment) ReadMemory(address uint16) uint8 {
+	return e.cpu.Memory.DebugPeek(address)
+}
+
+func (e environment) flag(flag processor.Status) int64 {
+	if e.cpu.Registers.P&flag != 0 {
+		return 1
+	}
+	return 0
+}
+
+func (b *Breakpoint) String() string {
+	var target string
+	switch b.Kind {
+	case BreakExecute:
+		target = fmt.Sprintf("$%04X", b.From)
+	case BreakRead, BreakWrite, BreakAccess:
+		target = fmt.Sprintf("$%04X-$%04X", b.From, b.To)
+	case BreakOpcode:
+		target = fmt.Sprintf("opcode $%02X", uint8(b.Opcode))
+	case BreakInterrupt:
+		target = "any interrupt"
+		if b.Vector != AnyInterrupt {
+			target = fmt.Sprintf("vector $%04X", b.Vector)
+		}
+	}
+
+	result := fmt.Sprintf("#%d %s %s", b.ID, KindName(b.Kind), target)
+	if b.Condition != nil {
+		result += " if " + b.Condition.String()
+	}
+
+	return result
+}
+
+func (h *Hit) String() string {
+	result := fmt.Sprintf("%s hit at $%04X, cycle %d", h.Breakpoint, h.PC, h.Cycles)
+	if h.Access != nil {
+		result += fmt.Sprintf(" (%s $%04X = $%02X)",
+			processor.AccessTypeName(h.Access.Type), h.Access.Address, h.Access.Value)
+	}
+	if h.Breakpoint.Kind == BreakInterrupt {
+		result += fmt.Sprintf(" (vector $%04X)", h.Vector)
+	}
+
+	return result
+}
+
+func KindName(kind Kind) string {
+	switch kind {
+	case BreakExecute:
+		return "Execute"
+	case BreakRead:
+		return "Read"
+	case BreakWrite:
+		return "Write"
+	case BreakAccess:
+		return "Access"
+	case BreakOpcode:
+		return "Opcode"
+	case BreakInterrupt:
+		return "Interrupt"
+	default:
+		return "<unknown>"
+	}
+}
@@ -0,0 +1,180 @@
+package debugger
+
+import (
+	"github.com/stretchr/testify/assert"
+	"nessie/asm"
+	"nessie/processor"
+	"testing"
+)
+
+const testLocation uint16 = 0xC000
+
+func newTestDebugger(source string) *Debugger {
+	cpu := processor.NewCPU()
+	cpu.Registers.PC = testLocation
+	cpu.Registers.P = processor.FlagUnused
+	asm.MustAssemble(".org $C000\n" + source).Load(cpu.Memory)
+	cpu.Memory.Poke16(processor.NMIVector, 0x1000)
+	cpu.Memory.Poke(0x1000, 0xEA)
+
+	return New(cpu)
+}
+
+func TestBreakpoint(t *testing.T) {
+	d := newTestDebugger("LDX #$00\nloop: INX\nJMP loop")
+	breakpoint, err := d.AddBreakpoint(0xC002, "x == 5")
+	assert.NoError(t, err)
+
+	hit, err := d.Run()
+	assert.NoError(t, err)
+	if assert.NotNil(t, hit, "expected breakpoint to fire") {
+		assert.Equal(t, breakpoint, hit.Breakpoint, "unexpected breakpoint")
+		assert.Equal(t, uint16(0xC002), hit.PC, "unexpected program counter")
+		assert.Equal(t, uint8(5), d.CPU.Registers.X, "expected condition to be respected")
+		assert.Equal(t, 1, breakpoint.Hits, "unexpected hit count")
+	}
+
+	// Resuming must not immediately stop at the same breakpoint again
+	breakpoint.Condition = MustCompile("x == 8")
+	hit, err = d.Run()
+	assert.NoError(t, err)
+	if assert.NotNil(t, hit, "expected breakpoint to fire") {
+		assert.Equal(t, uint8(8), d.CPU.Registers.X, "expected run to resume")
+	}
+}
+
+func TestWatchpoint(t *testing.T) {
+	d := newTestDebugger("loop: INC $0300\nJMP loop")
+	breakpoint, err := d.AddWatchpoint(BreakWrite, 0x0300, 0x03FF, "value == 3")
+	assert.NoError(t, err)
+
+	hit, err := d.Run()
+	assert.NoError(t, err)
+	if assert.NotNil(t, hit, "expected watchpoint to fire") {
+		assert.Equal(t, breakpoint, hit.Breakpoint, "unexpected breakpoint")
+		assert.Equal(t, uint16(0xC003), hit.PC, "expected instruction to complete")
+		if assert.NotNil(t, hit.Access, "expected access to be reported") {
+			assert.Equal(t, processor.AccessWrite, hit.Access.Type, "unexpected access type")
+			assert.Equal(t, uint16(0x0300), hit.Access.Address, "unexpected access address")
+			assert.Equal(t, uint8(3), hit.Access.Value, "unexpected access value")
+		}
+	}
+
+	_, err = d.AddWatchpoint(BreakOpcode, 0x0000, 0x0001, "")
+	assert.Error(t, err, "expected invalid kind to be rejected")
+	_, err = d.AddWatchpoint(BreakRead, 0x0002, 0x0001, "")
+	assert.Error(t, err, "expected invalid range to be rejected")
+}
+
+func TestReadWatchpoint(t *testing.T) {
+	d := newTestDebugger("LDA $0300\nSTA $0300")
+	_, err := d.AddWatchpoint(BreakRead, 0x0300, 0x0300, "")
+	assert.NoError(t, err)
+
+	hit, err := d.Step()
+	assert.NoError(t, err)
+	if assert.NotNil(t, hit, "expected read watchpoint to fire") {
+		assert.Equal(t, processor.AccessRead, hit.Access.Type, "unexpected access type")
+	}
+
+	hit, err = d.Step()
+	assert.NoError(t, err)
+	assert.Nil(t, hit, "expected write to be ignored")
+}
+
+func TestWatchpointAccesses(t *testing.T) {
+	// Fetches and dummy reads are ignored by default, INX reads $C001 during its second cycle
+	d := newTestDebugger("INX\nINX\nLDA $C001")
+	breakpoint, err := d.AddWatchpoint(BreakRead, 0xC001, 0xC001, "")
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		hit, err := d.Step()
+		assert.NoError(t, err)
+		assert.Nil(t, hit, "expected fetches and dummy reads to be ignored")
+	}
+	hit, err := d.Step()
+	assert.NoError(t, err)
+	if assert.NotNil(t, hit, "expected read watchpoint to fire") {
+		assert.Equal(t, processor.AccessRead, hit.Access.Type, "unexpected access type")
+	}
+
+	// Selected access types fire for every access of that type
+	for _, accessType := range []processor.AccessType{processor.AccessDummyRead, processor.AccessOpcode} {
+		d = newTestDebugger("INX\nINX\nLDA $C001")
+		breakpoint, err = d.AddWatchpoint(BreakRead, 0xC001, 0xC001, "")
+		assert.NoError(t, err)
+		breakpoint.Accesses = []processor.AccessType{accessType}
+
+		hit, err = d.Run()
+		assert.NoError(t, err)
+		if assert.NotNil(t, hit, "expected %s watchpoint to fire", processor.AccessTypeName(accessType)) {
+			assert.Equal(t, accessType, hit.Access.Type, "unexpected access type")
+		}
+	}
+}
+
+func TestOpcodeBreakpoint(t *testing.T) {
+	d := newTestDebugger("NOP\nNOP\nKIL")
+	_, err := d.AddOpcodeBreakpoint(0x02, "")
+	assert.NoError(t, err)
+
+	hit, err := d.Run()
+	assert.NoError(t, err)
+	if assert.NotNil(t, hit, "expected opcode breakpoint to fire") {
+		assert.Equal(t, uint16(0xC002), hit.PC, "expected to stop before the opcode")
+	}
+
+	hit, err = d.Run()
+	assert.Equal(t, processor.ErrCPUJammed, err, "expected cpu to jam after resuming")
+	assert.Nil(t, hit)
+}
+
+func TestInterruptBreakpoint(t *testing.T) {
+	d := newTestDebugger("NOP\nNOP\nNOP")
+	_, err := d.AddInterruptBreakpoint(processor.IRQVector, "")
+	assert.NoError(t, err)
+	breakpoint, err := d.AddInterruptBreakpoint(AnyInterrupt, "vector == $FFFA")
+	assert.NoError(t, err)
+
+	hit, err := d.Step()
+	assert.NoError(t, err)
+	assert.Nil(t, hit)
+
+	d.CPU.TriggerNMI()
+	hit, err = d.Step()
+	assert.NoError(t, err)
+	if assert.NotNil(t, hit, "expected interrupt breakpoint to fire") {
+		assert.Equal(t, breakpoint, hit.Breakpoint, "unexpected breakpoint")
+		assert.Equal(t, uint16(processor.NMIVector), hit.Vector, "unexpected vector")
+		assert.Equal(t, uint16(0x1000), hit.PC, "expected to stop inside the handler")
+	}
+}
+
+func TestRemoveBreakpoint(t *testing.T) {
+	d := newTestDebugger("NOP\nNOP\nKIL")
+	breakpoint, err := d.AddBreakpoint(0xC001, "")
+	assert.NoError(t, err)
+	_, err = d.AddBreakpoint(0xC000, "foo")
+	assert.Error(t, err, "expected invalid condition to be rejected")
+
+	assert.True(t, d.Remove(breakpoint.ID), "expected breakpoint to be removed")
+	assert.False(t, d.Remove(breakpoint.ID), "expected breakpoint to be gone")
+	assert.Empty(t, d.Breakpoints())
+
+	hit, err := d.Run()
+	assert.Equal(t, processor.ErrCPUJammed, err, "expected run to continue until the cpu jams")
+	assert.Nil(t, hit)
+}
+
+func TestHitString(t *testing.T) {
+	d := newTestDebugger("NOP\nNOP")
+	_, err := d.AddBreakpoint(0xC001, "a == 0")
+	assert.NoError(t, err)
+
+	hit, err := d.Run()
+	assert.NoError(t, err)
+	if assert.NotNil(t, hit) {
+		assert.Equal(t, "#1 Execute $C001 if a == 0 hit at $C001, cycle 2", hit.String())
+	}
+}
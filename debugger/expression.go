@@ -0,0 +1,371 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Environment provides the values which can be referenced by an expression.
+type Environment interface {
+	Variable(name string) (value int64, ok bool)
+	ReadMemory(address uint16) uint8
+}
+
+// Expression is a compiled break condition such as `A == $10 && [$0300] > 4 && cycles > 100000`. Numbers can be
+// written as decimal, `$FF` or `0xFF` hexadecimal and `%1010` binary literals, `[address]` reads a byte from memory
+// and all comparisons and logical operators evaluate to either 0 or 1.
+type Expression struct {
+	source string
+	root   node
+}
+
+type node interface {
+	eval(env Environment) int64
+}
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenNumber
+	tokenIdentifier
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenType
+	text  string
+	value int64
+	pos   int
+}
+
+type numberNode int64
+type variableNode string
+type memoryNode struct{ address node }
+type unaryNode struct {
+	operator string
+	operand  node
+}
+type binaryNode struct {
+	operator    string
+	left, right node
+}
+
+// binaryPrecedence lists all binary operators from lowest to highest precedence
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/"},
+}
+
+// identifiers lists all names which can be referenced by an expression, matched case-insensitively
+var identifiers = map[string]bool{
+	"a": true, "x": true, "y": true, "s": true, "sp": true, "p": true, "pc": true, "cycles": true,
+	"c": true, "z": true, "i": true, "d": true, "v": true, "n": true,
+	"address": true, "value": true, "vector": true,
+}
+
+var operators = []string{
+	"||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+	"|", "^", "&", "<", ">", "+", "-", "*", "/", "!", "~", "(", ")", "[", "]",
+}
+
+func Compile(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", next.text, next.pos)
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+func MustCompile(source string) *Expression {
+	expression, err := Compile(source)
+	if err != nil {
+		panic(err)
+	}
+	return expression
+}
+
+func (e *Expression) Eval(env Environment) int64 {
+	return e.root.eval(env)
+}
+
+func (e *Expression) True(env Environment) bool {
+	return e.Eval(env) != 0
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+func tokenize(source string) (tokens []token, err error) {
+	for pos := 0; pos < len(source); {
+		char := rune(source[pos])
+
+		switch {
+		case unicode.IsSpace(char):
+			pos++
+
+		case char == '$' || char == '%' || unicode.IsDigit(char):
+			start := pos
+			base, digits := 10, ""
+			switch {
+			case char == '$':
+				base, pos = 16, pos+1
+			case char == '%':
+				base, pos = 2, pos+1
+			case strings.HasPrefix(strings.ToLower(source[pos:]), "0x"):
+				base, pos = 16, pos+2
+			}
+			for pos < len(source) && isAlphanumeric(rune(source[pos])) {
+				digits += string(source[pos])
+				pos++
+			}
+
+			value, parseErr := strconv.ParseInt(digits, base, 64)
+			if parseErr != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", source[start:pos], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:pos], value: value, pos: start})
+
+		case unicode.IsLetter(char) || char == '_':
+			start := pos
+			for pos < len(source) && isAlphanumeric(rune(source[pos])) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: source[start:pos], pos: start})
+
+		default:
+			matched := false
+			for _, operator := range operators {
+				if strings.HasPrefix(source[pos:], operator) {
+					tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: pos})
+					pos += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", char, pos)
+			}
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, text: "end of expression", pos: len(source)})
+	return
+}
+
+func isAlphanumeric(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsDigit(char) || char == '_'
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(operators ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return "", false
+	}
+
+	for _, operator := range operators {
+		if t.text == operator {
+			p.pos++
+			return operator, true
+		}
+	}
+
+	return "", false
+}
+
+func (p *parser) expect(operator string) error {
+	if _, ok := p.accept(operator); !ok {
+		t := p.peek()
+		return fmt.Errorf("expected %q but got %q at position %d", operator, t.text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level >= len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator, ok := p.accept(binaryPrecedence[level]...)
+		if !ok {
+			return left, nil
+		}
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if operator, ok := p.accept("!", "-", "~"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{operator: operator, operand: operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch {
+	case t.kind == tokenNumber:
+		return numberNode(t.value), nil
+
+	case t.kind == tokenIdentifier:
+		name := strings.ToLower(t.text)
+		if !identifiers[name] {
+			return nil, fmt.Errorf("unknown identifier %q at position %d", t.text, t.pos)
+		}
+		return variableNode(name), nil
+
+	case t.kind == tokenOperator && t.text == "(":
+		inner, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+
+	case t.kind == tokenOperator && t.text == "[":
+		address, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return &memoryNode{address: address}, p.expect("]")
+
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+}
+
+func (n numberNode) eval(env Environment) int64 {
+	return int64(n)
+}
+
+func (n variableNode) eval(env Environment) int64 {
+	value, _ := env.Variable(string(n))
+	return value
+}
+
+func (n *memoryNode) eval(env Environment) int64 {
+	return int64(env.ReadMemory(uint16(n.address.eval(env))))
+}
+
+func (n *unaryNode) eval(env Environment) int64 {
+	value := n.operand.eval(env)
+
+	switch n.operator {
+	case "!":
+		return boolValue(value == 0)
+	case "-":
+		return -value
+	default:
+		return ^value
+	}
+}
+
+func (n *binaryNode) eval(env Environment) int64 {
+	left := n.left.eval(env)
+
+	// Logical operators short-circuit, so that memory is only read when required
+	switch n.operator {
+	case "||":
+		return boolValue(left != 0 || n.right.eval(env) != 0)
+	case "&&":
+		return boolValue(left != 0 && n.right.eval(env) != 0)
+	}
+
+	right := n.right.eval(env)
+	switch n.operator {
+	case "|":
+		return left | right
+	case "^":
+		return left ^ right
+	case "&":
+		return left & right
+	case "==":
+		return boolValue(left == right)
+	case "!=":
+		return boolValue(left != right)
+	case "<":
+		return boolValue(left < right)
+	case "<=":
+		return boolValue(left <= right)
+	case ">":
+		return boolValue(left > right)
+	case ">=":
+		return boolValue(left >= right)
+	case "<<":
+		return left << uint64(right)
+	case ">>":
+		return left >> uint64(right)
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	default:
+		if right == 0 {
+			return 0
+		}
+		return left / right
+	}
+}
+
+func boolValue(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
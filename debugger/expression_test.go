@@ -0,0 +1,66 @@
+package debugger
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testEnvironment struct {
+	variables map[string]int64
+	memory    map[uint16]uint8
+}
+
+func (e testEnvironment) Variable(name string) (int64, bool) {
+	value, ok := e.variables[name]
+	return value, ok
+}
+
+func (e testEnvironment) ReadMemory(address uint16) uint8 {
+	return e.memory[address]
+}
+
+func TestExpressionEval(t *testing.T) {
+	env := testEnvironment{
+		variables: map[string]int64{"a": 0x10, "x": 3, "cycles": 150000, "c": 1},
+		memory:    map[uint16]uint8{0x0300: 5, 0x0013: 0x80},
+	}
+
+	testEval := func(source string, expected int64) {
+		expression, err := Compile(source)
+		if assert.NoError(t, err, "unexpected error for %q", source) {
+			assert.Equal(t, expected, expression.Eval(env), "unexpected result for %q", source)
+		}
+	}
+
+	testEval("$10", 0x10)
+	testEval("0x1F", 0x1F)
+	testEval("%1010", 10)
+	testEval("42", 42)
+	testEval("A == $10 && [$0300] > 4 && cycles > 100000", 1)
+	testEval("a == $11 || C", 1)
+	testEval("1 + 2 * 3", 7)
+	testEval("(1 + 2) * 3", 9)
+	testEval("1 << 4 | 1", 17)
+	testEval("[a + x]", 0x80)
+	testEval("-x + 4", 1)
+	testEval("~0 & $FF", 0xFF)
+	testEval("!c", 0)
+	testEval("10 / 0", 0)
+	testEval("7 >= 7 && 6 < 7 && 8 != 7", 1)
+}
+
+func TestExpressionErrors(t *testing.T) {
+	testError := func(source string) {
+		_, err := Compile(source)
+		assert.Error(t, err, "expected error for %q", source)
+	}
+
+	testError("")
+	testError("A ==")
+	testError("(A == 1")
+	testError("[$0300")
+	testError("$XYZ")
+	testError("A # 1")
+	testError("foo == 1")
+	testError("1 2")
+}
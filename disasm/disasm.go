@@ -0,0 +1,267 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"nessie/processor"
+	"strings"
+)
+
+// Reader provides the bytes which get disassembled without side effects. Both processor.MappedMemory and Bank satisfy
+// this interface.
+type Reader interface {
+	DebugPeek(address uint16) (value uint8)
+}
+
+// Bank is a chunk of PRG ROM, e.g. a single switchable bank, which gets disassembled as if it was mapped at Base.
+// Offset is the position of the bank within PRG ROM and used for looking up PRG ROM symbols.
+type Bank struct {
+	Data   []byte
+	Base   uint16
+	Offset int
+}
+
+type Line struct {
+	Address     uint16
+	Bytes       []byte
+	Instruction processor.Instruction
+	Label       string
+	Text        string
+	Target      uint16
+	HasTarget   bool
+	Comment     string
+}
+
+type Disassembler struct {
+	Symbols *Symbols
+	// PRGOffset optionally translates CPU addresses into PRG ROM offsets, which allows using PRG ROM symbols
+	PRGOffset func(address uint16) (offset int, ok bool)
+
+	cpu *processor.CPU
+}
+
+// New returns a disassembler decoding instructions for the variant of the given CPU. Symbols can be nil.
+func New(cpu *processor.CPU, symbols *Symbols) *Disassembler {
+	return &Disassembler{Symbols: symbols, cpu: cpu}
+}
+
+// Range linearly disassembles all instructions starting within from and to, both inclusive. Labels are generated for
+// all branch and jump targets inside the range which are not covered by any symbol.
+func (d *Disassembler) Range(reader Reader, from uint16, to uint16) []Line {
+	return d.disassemble(reader, from, to, d.PRGOffset)
+}
+
+// Bank disassembles a whole bank, resolving PRG ROM symbols based on the offset of the bank.
+func (d *Disassembler) Bank(bank Bank) []Line {
+	if len(bank.Data) == 0 {
+		return nil
+	}
+
+	prgOffset := func(address uint16) (int, bool) {
+		return bank.Offset + int(address-bank.Base), true
+	}
+	return d.disassemble(bank, bank.Base, bank.Base+uint16(len(bank.Data)-1), prgOffset)
+}
+
+func (b Bank) DebugPeek(address uint16) uint8 {
+	if offset := int(address - b.Base); offset < len(b.Data) {
+		return b.Data[offset]
+	}
+	return 0
+}
+
+func (d *Disassembler) disassemble(reader Reader, from uint16, to uint16, prgOffset func(uint16) (int, bool)) []Line {
+	var lines []Line
+	for address := uint32(from); address <= uint32(to); {
+		line := d.decode(reader, uint16(address), to)
+		lines = append(lines, line)
+		address += uint32(len(line.Bytes))
+	}
+
+	// Resolve names only once all lines are known, so that local labels can be generated for every target in range
+	labels := make(map[uint16]string)
+	for _, line := range lines {
+		if line.HasTarget && line.Target >= from && line.Target <= to {
+			labels[line.Target] = fmt.Sprintf("L_%04X", line.Target)
+		}
+	}
+
+	name := func(address uint16) (string, bool) {
+		if prgOffset != nil && address >= from && address <= to {
+			if offset, ok := prgOffset(address); ok {
+				if name, ok := d.Symbols.LookupPRG(offset); ok {
+					return name, true
+				}
+			}
+		}
+		if name, ok := d.Symbols.Lookup(address); ok {
+			return name, true
+		}
+		if name, _, ok := IORegister(address); ok && name != "" {
+			return name, true
+		}
+		name, ok := labels[address]
+		return name, ok
+	}
+
+	for i := range lines {
+		line := &lines[i]
+		if label, ok := name(line.Address); ok {
+			line.Label = label
+		}
+		if line.Instruction.Handler != nil {
+			line.Text = Format(line.Instruction, line.Bytes, line.Address, name)
+			line.Comment = comment(line)
+		}
+	}
+
+	return lines
+}
+
+func (d *Disassembler) decode(reader Reader, address uint16, to uint16) (line Line) {
+	opcode := reader.DebugPeek(address)
+	line.Address = address
+	line.Bytes = []byte{opcode}
+
+	instruction, ok := d.cpu.Lookup(processor.Opcode(opcode))
+	length := processor.OperandLength(instruction.Variant.AddressingMode)
+	if !ok || uint32(address)+uint32(length) > uint32(to) {
+		line.Text = fmt.Sprintf(".byte $%02X", opcode)
+		return
+	}
+
+	for i := 1; i <= length; i++ {
+		line.Bytes = append(line.Bytes, reader.DebugPeek(address+uint16(i)))
+	}
+	line.Instruction = instruction
+	line.Target, line.HasTarget = Target(instruction, line.Bytes, address)
+
+	return
+}
+
+// Target returns the destination of branches and absolute jumps or subroutine calls.
+func Target(instruction processor.Instruction, bytes []byte, pc uint16) (target uint16, ok bool) {
+	switch instruction.Variant.AddressingMode {
+	case processor.Relative:
+		return pc + 2 + uint16(int8(bytes[1])), true
+	case processor.ZeroPageRelative:
+		return pc + 3 + uint16(int8(bytes[2])), true
+	case processor.Absolute:
+		if instruction.Mnemonic == "JMP" || instruction.Mnemonic == "JSR" {
+			return uint16(bytes[1]) | uint16(bytes[2])<<8, true
+		}
+	}
+
+	return 0, false
+}
+
+// Format renders an instruction in ca65 syntax. Memory operands and branch targets are replaced by the result of the
+// name function if it returns true, which can be nil to always use plain hexadecimal addresses.
+func Format(instruction processor.Instruction, bytes []byte, pc uint16, name func(address uint16) (string, bool)) string {
+	var arg8 uint8
+	var arg16 uint16
+	if len(bytes) > 1 {
+		arg8 = bytes[1]
+		arg16 = uint16(arg8)
+	}
+	if len(bytes) > 2 {
+		arg16 |= uint16(bytes[2]) << 8
+	}
+
+	address := func(value uint16, zeroPage bool) string {
+		if name != nil {
+			if label, ok := name(value); ok {
+				return label
+			}
+		}
+		if zeroPage {
+			return fmt.Sprintf("$%02X", value)
+		}
+		return fmt.Sprintf("$%04X", value)
+	}
+
+	var operand string
+	switch instruction.Variant.AddressingMode {
+	case processor.Accumulator:
+		operand = "A"
+	case processor.Immediate:
+		operand = fmt.Sprintf("#$%02X", arg8)
+	case processor.ZeroPage:
+		operand = address(uint16(arg8), true)
+	case processor.ZeroPageX:
+		operand = address(uint16(arg8), true) + ",X"
+	case processor.ZeroPageY:
+		operand = address(uint16(arg8), true) + ",Y"
+	case processor.Absolute:
+		operand = address(arg16, false)
+	case processor.AbsoluteX:
+		operand = address(arg16, false) + ",X"
+	case processor.AbsoluteY:
+		operand = address(arg16, false) + ",Y"
+	case processor.Relative:
+		operand = address(pc+2+uint16(int8(arg8)), false)
+	case processor.Indirect:
+		operand = "(" + address(arg16, false) + ")"
+	case processor.IndirectX:
+		operand = "(" + address(uint16(arg8), true) + ",X)"
+	case processor.IndirectY:
+		operand = "(" + address(uint16(arg8), true) + "),Y"
+	case processor.ZeroPageIndirect:
+		operand = "(" + address(uint16(arg8), true) + ")"
+	case processor.AbsoluteIndirectX:
+		operand = "(" + address(arg16, false) + ",X)"
+	case processor.ZeroPageRelative:
+		var offset uint8
+		if len(bytes) > 2 {
+			offset = bytes[2]
+		}
+		operand = address(uint16(arg8), true) + "," + address(pc+3+uint16(int8(offset)), false)
+	}
+
+	if operand == "" {
+		return instruction.Mnemonic
+	}
+	return instruction.Mnemonic + " " + operand
+}
+
+// comment annotates accesses to mirrored PPU registers, which can not be named directly
+func comment(line *Line) string {
+	var address uint16
+	switch line.Instruction.Variant.AddressingMode {
+	case processor.Absolute, processor.AbsoluteX, processor.AbsoluteY:
+		address = uint16(line.Bytes[1]) | uint16(line.Bytes[2])<<8
+	default:
+		return ""
+	}
+
+	_, comment, _ := IORegister(address)
+	return comment
+}
+
+func (l Line) String() string {
+	bytes := make([]string, len(l.Bytes))
+	for i, value := range l.Bytes {
+		bytes[i] = fmt.Sprintf("%02X", value)
+	}
+
+	result := fmt.Sprintf("%04X  %-8s  %s", l.Address, strings.Join(bytes, " "), l.Text)
+	if l.Comment != "" {
+		result = fmt.Sprintf("%-40s ; %s", result, l.Comment)
+	}
+	return result
+}
+
+// Write prints all lines as a listing, placing labels on their own line right before the labeled instruction.
+func Write(writer io.Writer, lines []Line) error {
+	for _, line := range lines {
+		if line.Label != "" {
+			if _, err := fmt.Fprintf(writer, "%s:\n", line.Label); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(writer, "  %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package disasm
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"nessie/processor"
+	"testing"
+)
+
+// testProgram waits for vblank, initializes the PPU and calls a subroutine
+var testProgram = []byte{
+	0x2C, 0x02, 0x20, // C000: BIT $2002
+	0x10, 0xFB, //       C003: BPL $C000
+	0xA9, 0x80, //       C005: LDA #$80
+	0x8D, 0x00, 0x20, // C007: STA $2000
+	0x8D, 0x0E, 0x20, // C00A: STA $200E
+	0x20, 0x13, 0xC0, // C00D: JSR $C013
+	0x4C, 0x00, 0x90, // C010: JMP $9000
+	0x85, 0x10, //       C013: STA $10
+	0x60, //             C015: RTS
+	0x20, //             C016: truncated JSR
+}
+
+func TestRange(t *testing.T) {
+	cpu := processor.NewCPU()
+	for i, value := range testProgram {
+		cpu.Memory.Poke(0xC000+uint16(i), value)
+	}
+
+	symbols := NewSymbols()
+	symbols.Add(0x0010, "frame_counter")
+	symbols.Add(0x9000, "main_loop")
+
+	var output bytes.Buffer
+	lines := New(cpu, symbols).Range(cpu.Memory, 0xC000, 0xC016)
+	assert.NoError(t, Write(&output, lines))
+	assert.Equal(t, ""+
+		"L_C000:\n"+
+		"  C000  2C 02 20  BIT PPUSTATUS\n"+
+		"  C003  10 FB     BPL L_C000\n"+
+		"  C005  A9 80     LDA #$80\n"+
+		"  C007  8D 00 20  STA PPUCTRL\n"+
+		"  C00A  8D 0E 20  STA $200E                ; mirror of PPUADDR\n"+
+		"  C00D  20 13 C0  JSR L_C013\n"+
+		"  C010  4C 00 90  JMP main_loop\n"+
+		"L_C013:\n"+
+		"  C013  85 10     STA frame_counter\n"+
+		"  C015  60        RTS\n"+
+		"  C016  20        .byte $20\n",
+		output.String())
+
+	assert.True(t, lines[1].HasTarget, "expected branch target")
+	assert.Equal(t, uint16(0xC000), lines[1].Target, "unexpected branch target")
+}
+
+func TestBank(t *testing.T) {
+	symbols := NewSymbols()
+	symbols.AddPRG(0x4013, "update")
+	symbols.AddPRG(0x0013, "wrong_bank")
+
+	lines := New(processor.NewCPU(), symbols).Bank(Bank{Data: testProgram, Base: 0xC000, Offset: 0x4000})
+	assert.Equal(t, "JSR update", lines[5].Text, "expected PRG ROM symbol of bank to be used")
+	assert.Equal(t, "update", lines[7].Label, "expected PRG ROM symbol as label")
+	assert.Empty(t, New(processor.NewCPU(), nil).Bank(Bank{}))
+}
+
+func TestVariantDisassembly(t *testing.T) {
+	bank := Bank{Data: []byte{0x80, 0x02, 0xDA, 0x0F, 0x10, 0xFA}, Base: 0x8000}
+
+	lines := New(processor.NewCPU(processor.WithVariant(processor.WDC65C02)), nil).Bank(bank)
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, "BRA L_8004", lines[0].Text)
+		assert.Equal(t, "PHX", lines[1].Text)
+		assert.Equal(t, "BBR0 $10,L_8000", lines[2].Text)
+	}
+
+	lines = New(processor.NewCPU(), nil).Bank(bank)
+	assert.Equal(t, "NOP #$02", lines[0].Text, "expected unofficial opcodes on the 2A03")
+}
+
+func TestIORegister(t *testing.T) {
+	name, comment, ok := IORegister(0x4014)
+	assert.Equal(t, "OAMDMA", name)
+	assert.Empty(t, comment)
+	assert.True(t, ok)
+
+	name, comment, ok = IORegister(0x3FFF)
+	assert.Empty(t, name)
+	assert.Equal(t, "mirror of PPUDATA", comment)
+	assert.True(t, ok)
+
+	_, _, ok = IORegister(0x0200)
+	assert.False(t, ok)
+}
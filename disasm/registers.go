@@ -0,0 +1,51 @@
+package disasm
+
+import "fmt"
+
+// ioRegisters contains the names of all memory-mapped PPU, APU and controller registers of the NES
+var ioRegisters = map[uint16]string{
+	0x2000: "PPUCTRL",
+	0x2001: "PPUMASK",
+	0x2002: "PPUSTATUS",
+	0x2003: "OAMADDR",
+	0x2004: "OAMDATA",
+	0x2005: "PPUSCROLL",
+	0x2006: "PPUADDR",
+	0x2007: "PPUDATA",
+	0x4000: "SQ1_VOL",
+	0x4001: "SQ1_SWEEP",
+	0x4002: "SQ1_LO",
+	0x4003: "SQ1_HI",
+	0x4004: "SQ2_VOL",
+	0x4005: "SQ2_SWEEP",
+	0x4006: "SQ2_LO",
+	0x4007: "SQ2_HI",
+	0x4008: "TRI_LINEAR",
+	0x400A: "TRI_LO",
+	0x400B: "TRI_HI",
+	0x400C: "NOISE_VOL",
+	0x400E: "NOISE_LO",
+	0x400F: "NOISE_HI",
+	0x4010: "DMC_FREQ",
+	0x4011: "DMC_RAW",
+	0x4012: "DMC_START",
+	0x4013: "DMC_LEN",
+	0x4014: "OAMDMA",
+	0x4015: "SND_CHN",
+	0x4016: "JOY1",
+	0x4017: "JOY2",
+}
+
+// IORegister returns the name of the NES register at the given address. PPU registers are mirrored every eight bytes
+// up to $3FFF, so a comment naming the canonical register gets returned for mirrors instead.
+func IORegister(address uint16) (name string, comment string, ok bool) {
+	if name, ok = ioRegisters[address]; ok {
+		return name, "", true
+	}
+
+	if address >= 0x2008 && address < 0x4000 {
+		return "", fmt.Sprintf("mirror of %s", ioRegisters[0x2000|address&0x0007]), true
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,458 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"nessie/cartridge"
+	"nessie/processor"
+	"sort"
+	"strings"
+)
+
+const bankSize = 0x4000
+const bytesPerDataLine = 16
+
+// Mappers supported by the static analysis, whose banks are laid out as described for Analysis
+const (
+	mapperNROM  = 0x00
+	mapperUxROM = 0x02
+)
+
+// Flags of FCEUX code/data logs, which contain one byte per PRG ROM byte
+const (
+	cdlCode = 1 << 0
+	cdlData = 1 << 1
+)
+
+type byteKind uint8
+
+const (
+	kindUnknown byteKind = iota
+	kindOpcode
+	kindOperand
+)
+
+// Analysis is the result of statically tracing all code reachable from the interrupt vectors of a NROM or UxROM ROM.
+// The last PRG bank is fixed at $C000, while all other banks get switched in at $8000. A single bank gets placed at
+// $8000 or $C000 depending on its reset vector.
+type Analysis struct {
+	ROM     *cartridge.ROMFile
+	Symbols *Symbols
+
+	cpu          *processor.CPU
+	cdl          []byte
+	prg          []byte
+	kinds        []byteKind
+	instructions map[int]processor.Instruction
+	labels       map[int]string
+	names        map[string]bool
+	singleBase   uint16
+}
+
+// Analyze traces the code of a ROM starting at its reset, NMI and IRQ vectors. Indirect jumps can not be followed
+// statically, so an optional FCEUX code/data log can be passed to provide additional entry points. Bytes which are
+// only logged as data are never traced as code. Symbols can be nil.
+func Analyze(cpu *processor.CPU, rom *cartridge.ROMFile, symbols *Symbols, cdl []byte) (*Analysis, error) {
+	if len(rom.BanksPRG) == 0 {
+		return nil, fmt.Errorf("rom does not contain any prg banks")
+	}
+	if rom.MapperID != mapperNROM && rom.MapperID != mapperUxROM {
+		return nil, fmt.Errorf("unsupported mapper type for static analysis: 0x%02X", rom.MapperID)
+	}
+
+	a := &Analysis{
+		ROM:          rom,
+		Symbols:      symbols,
+		cpu:          cpu,
+		instructions: make(map[int]processor.Instruction),
+		labels:       make(map[int]string),
+		names:        make(map[string]bool),
+		singleBase:   0xC000,
+	}
+	for _, bank := range rom.BanksPRG {
+		a.prg = append(a.prg, bank...)
+	}
+	a.kinds = make([]byteKind, len(a.prg))
+
+	if cdl != nil {
+		if len(cdl) < len(a.prg) {
+			return nil, fmt.Errorf("code/data log is smaller than prg rom: %d < %d bytes", len(cdl), len(a.prg))
+		}
+		a.cdl = cdl[:len(a.prg)]
+	}
+
+	// Vectors are always located at the end of the last bank, regardless of where it gets mapped
+	vectors := []struct {
+		name    string
+		address uint16
+	}{{"RESET", processor.ResetVector}, {"NMI", processor.NMIVector}, {"IRQ", processor.IRQVector}}
+	last := a.lastBank()
+	if len(rom.BanksPRG) == 1 && a.vector(processor.ResetVector) < 0xC000 {
+		a.singleBase = 0x8000
+	}
+
+	for _, vector := range vectors {
+		if offset, ok := a.locate(last, a.vector(vector.address)); ok {
+			a.label(offset, vector.name)
+			a.trace(offset)
+		}
+	}
+	for offset := range a.cdl {
+		if a.cdl[offset]&cdlCode != 0 && a.kinds[offset] == kindUnknown {
+			a.trace(offset)
+		}
+	}
+
+	a.collectLabels()
+	return a, nil
+}
+
+// IsCode returns true if the given PRG ROM offset is part of a traced instruction.
+func (a *Analysis) IsCode(offset int) bool {
+	return offset >= 0 && offset < len(a.kinds) && a.kinds[offset] != kindUnknown
+}
+
+// Address returns the CPU address at which the given PRG ROM offset is assumed to be mapped.
+func (a *Analysis) Address(offset int) uint16 {
+	bank := offset / bankSize
+	return a.base(bank) + uint16(offset%bankSize)
+}
+
+func (a *Analysis) lastBank() int {
+	return len(a.ROM.BanksPRG) - 1
+}
+
+func (a *Analysis) base(bank int) uint16 {
+	switch {
+	case len(a.ROM.BanksPRG) == 1:
+		return a.singleBase
+	case bank == a.lastBank():
+		return 0xC000
+	default:
+		return 0x8000
+	}
+}
+
+// switchable returns true if the bank shares its address range with other banks
+func (a *Analysis) switchable(bank int) bool {
+	return len(a.ROM.BanksPRG) > 2 && bank != a.lastBank()
+}
+
+func (a *Analysis) vector(address uint16) uint16 {
+	offset := a.lastBank()*bankSize + int(address&(bankSize-1))
+	return uint16(a.prg[offset]) | uint16(a.prg[offset+1])<<8
+}
+
+// locate translates a CPU address as seen by code within the given bank into a PRG ROM offset. Addresses within the
+// switchable range can only be resolved from within the same bank. Single banks are mirrored across both halves.
+func (a *Analysis) locate(bank int, address uint16) (int, bool) {
+	switch {
+	case address < 0x8000:
+		return 0, false
+	case len(a.ROM.BanksPRG) == 1:
+		return int(address & (bankSize - 1)), true
+	case address >= 0xC000:
+		return a.lastBank()*bankSize + int(address-0xC000), true
+	case len(a.ROM.BanksPRG) == 2:
+		return int(address - 0x8000), true
+	case bank != a.lastBank():
+		return bank*bankSize + int(address-0x8000), true
+	default:
+		return 0, false
+	}
+}
+
+// exact resolves an address like locate, but only if the PRG ROM offset is assumed to be mapped at that address
+func (a *Analysis) exact(bank int, address uint16) (int, bool) {
+	offset, ok := a.locate(bank, address)
+	if !ok || a.Address(offset) != address {
+		return 0, false
+	}
+	return offset, true
+}
+
+func (a *Analysis) trace(entry int) {
+	queue := []int{entry}
+	for len(queue) > 0 {
+		offset := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		for offset < len(a.prg) && a.kinds[offset] == kindUnknown {
+			if a.cdl != nil && a.cdl[offset]&(cdlCode|cdlData) == cdlData {
+				break
+			}
+
+			instruction, ok := a.cpu.Lookup(processor.Opcode(a.prg[offset]))
+			length := 1 + processor.OperandLength(instruction.Variant.AddressingMode)
+			if !ok || offset%bankSize+length > bankSize || !a.unknown(offset+1, length-1) {
+				break
+			}
+
+			a.kinds[offset] = kindOpcode
+			for i := 1; i < length; i++ {
+				a.kinds[offset+i] = kindOperand
+			}
+			a.instructions[offset] = instruction
+
+			bank, address := offset/bankSize, a.Address(offset)
+			if target, ok := Target(instruction, a.prg[offset:offset+length], address); ok {
+				if targetOffset, ok := a.locate(bank, target); ok {
+					queue = append(queue, targetOffset)
+				}
+			}
+
+			switch instruction.Mnemonic {
+			case "BRK", "RTS", "RTI", "JMP", "BRA", "KIL", "STP":
+				offset = len(a.prg)
+			default:
+				offset += length
+			}
+		}
+	}
+}
+
+func (a *Analysis) unknown(offset int, length int) bool {
+	for i := offset; i < offset+length; i++ {
+		if a.kinds[i] != kindUnknown {
+			return false
+		}
+	}
+	return true
+}
+
+// collectLabels names every location within PRG ROM which is referenced by a traced instruction
+func (a *Analysis) collectLabels() {
+	// Offsets get sorted so that name conflicts between symbols are always resolved the same way
+	offsets := make([]int, 0, len(a.instructions))
+	for offset := range a.instructions {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	for _, offset := range offsets {
+		bank := offset / bankSize
+		if address, ok := a.operandAddress(offset, a.instructions[offset]); ok {
+			if target, ok := a.exact(bank, address); ok {
+				a.label(a.lineStart(target), "")
+			}
+		}
+	}
+}
+
+// label assigns a name to a PRG ROM offset, preferring symbols over the given name over a generated name
+func (a *Analysis) label(offset int, name string) {
+	if _, ok := a.labels[offset]; ok {
+		return
+	}
+
+	address := a.Address(offset)
+	candidates := []string{name}
+	if symbol, ok := a.Symbols.LookupPRG(offset); ok {
+		candidates = append([]string{symbol}, candidates...)
+	}
+	if symbol, ok := a.Symbols.Lookup(address); ok && !a.switchable(offset/bankSize) {
+		candidates = append(candidates, symbol)
+	}
+	if a.switchable(offset / bankSize) {
+		candidates = append(candidates, fmt.Sprintf("L_%02X_%04X", offset/bankSize, address))
+	} else {
+		candidates = append(candidates, fmt.Sprintf("L_%04X", address))
+	}
+
+	for _, candidate := range candidates {
+		if candidate != "" && !a.names[candidate] {
+			a.names[candidate] = true
+			a.labels[offset] = candidate
+			return
+		}
+	}
+}
+
+// lineStart returns the start of the instruction containing the given offset, or the offset itself for data
+func (a *Analysis) lineStart(offset int) int {
+	for offset > 0 && a.kinds[offset] == kindOperand {
+		offset--
+	}
+	return offset
+}
+
+func (a *Analysis) operandAddress(offset int, instruction processor.Instruction) (uint16, bool) {
+	bytes := a.prg[offset : offset+1+processor.OperandLength(instruction.Variant.AddressingMode)]
+	switch instruction.Variant.AddressingMode {
+	case processor.Absolute, processor.AbsoluteX, processor.AbsoluteY, processor.Indirect, processor.AbsoluteIndirectX:
+		return uint16(bytes[1]) | uint16(bytes[2])<<8, true
+	default:
+		return Target(instruction, bytes, a.Address(offset))
+	}
+}
+
+// WriteSource writes ca65 source for all PRG banks, which reassembles into the original PRG ROM when linked using the
+// configuration written by WriteConfig. Every bank is placed in its own segment named PRGxx. Unofficial opcodes are
+// emitted as raw bytes, as they can not be reassembled unambiguously.
+func (a *Analysis) WriteSource(writer io.Writer) error {
+	equates := make(map[string]uint16)
+	var body strings.Builder
+	for bank := range a.ROM.BanksPRG {
+		fmt.Fprintf(&body, "\n.segment \"PRG%02X\"\n.org $%04X\n", bank, a.base(bank))
+		a.writeBank(&body, bank, equates)
+	}
+
+	names := make([]string, 0, len(equates))
+	for name := range equates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var header strings.Builder
+	fmt.Fprintf(&header, "; %s\n", a.ROM)
+	if len(names) > 0 {
+		header.WriteString("\n")
+	}
+	for _, name := range names {
+		fmt.Fprintf(&header, "%s = $%04X\n", name, equates[name])
+	}
+
+	_, err := io.WriteString(writer, header.String()+body.String())
+	return err
+}
+
+func (a *Analysis) writeBank(body *strings.Builder, bank int, equates map[string]uint16) {
+	name := func(address uint16) (string, bool) {
+		if offset, ok := a.exact(bank, address); ok {
+			start := a.lineStart(offset)
+			if label, ok := a.labels[start]; ok {
+				if start != offset {
+					return fmt.Sprintf("%s+%d", label, offset-start), true
+				}
+				return label, true
+			}
+		}
+		if address >= 0x8000 {
+			return "", false
+		}
+
+		symbol, ok := a.Symbols.Lookup(address)
+		if !ok {
+			symbol, _, ok = IORegister(address)
+		}
+		if ok && symbol != "" && !a.names[symbol] {
+			equates[symbol] = address
+			return symbol, true
+		}
+		return "", false
+	}
+
+	// The vectors are emitted as words if they have not been traced as code
+	vectors := -1
+	if bank == a.lastBank() && a.unknown(len(a.prg)-6, 6) {
+		vectors = len(a.prg) - 6
+	}
+
+	end := (bank + 1) * bankSize
+	for offset := bank * bankSize; offset < end; {
+		if label, ok := a.labels[offset]; ok {
+			fmt.Fprintf(body, "%s:\n", label)
+		}
+
+		var ok bool
+		switch {
+		case offset == vectors:
+			words := make([]string, 3)
+			for i := range words {
+				address := uint16(a.prg[offset+i*2]) | uint16(a.prg[offset+i*2+1])<<8
+				if words[i], ok = name(address); !ok {
+					words[i] = fmt.Sprintf("$%04X", address)
+				}
+			}
+			fmt.Fprintf(body, "  .word %s\n", strings.Join(words, ", "))
+			offset += 6
+
+		case a.kinds[offset] == kindOpcode:
+			instruction := a.instructions[offset]
+			length := 1 + processor.OperandLength(instruction.Variant.AddressingMode)
+			bytes := a.prg[offset : offset+length]
+			text := Format(instruction, bytes, a.Address(offset), a.absoluteName(instruction, bytes, name))
+
+			line := Line{Instruction: instruction, Bytes: bytes}
+			if instruction.Unofficial {
+				fmt.Fprintf(body, "  %-24s ; %s\n", byteDirective(bytes), text)
+			} else if remark := comment(&line); remark != "" {
+				fmt.Fprintf(body, "  %-24s ; %s\n", text, remark)
+			} else {
+				fmt.Fprintf(body, "  %s\n", text)
+			}
+			offset += length
+
+		case a.fill(offset, vectors) >= bytesPerDataLine:
+			length := a.fill(offset, vectors)
+			fmt.Fprintf(body, "  .res %d, $%02X\n", length, a.prg[offset])
+			offset += length
+
+		default:
+			length := 1
+			for offset+length < end && length < bytesPerDataLine && a.kinds[offset+length] == kindUnknown &&
+				offset+length != vectors {
+				if _, ok := a.labels[offset+length]; ok {
+					break
+				}
+				length++
+			}
+			fmt.Fprintf(body, "  %s\n", byteDirective(a.prg[offset:offset+length]))
+			offset += length
+		}
+	}
+}
+
+// fill returns the length of the run of identical unlabeled data bytes starting at the given offset
+func (a *Analysis) fill(offset int, vectors int) int {
+	end := (offset/bankSize + 1) * bankSize
+	length := 1
+	for ; offset+length < end && offset+length != vectors; length++ {
+		if _, ok := a.labels[offset+length]; ok || a.kinds[offset+length] != kindUnknown ||
+			a.prg[offset+length] != a.prg[offset] {
+			break
+		}
+	}
+	return length
+}
+
+// absoluteName forces absolute addressing for operands within the zero page, as assemblers would otherwise pick the
+// shorter zero page encoding and break reassembling the original bytes
+func (a *Analysis) absoluteName(instruction processor.Instruction, bytes []byte, name func(uint16) (string, bool)) func(uint16) (string, bool) {
+	switch instruction.Variant.AddressingMode {
+	case processor.Absolute, processor.AbsoluteX, processor.AbsoluteY:
+		if bytes[2] != 0x00 {
+			return name
+		}
+	default:
+		return name
+	}
+
+	return func(address uint16) (string, bool) {
+		label, ok := name(address)
+		if !ok {
+			label = fmt.Sprintf("$%04X", address)
+		}
+		return "a:" + label, true
+	}
+}
+
+func byteDirective(data []byte) string {
+	values := make([]string, len(data))
+	for i, value := range data {
+		values[i] = fmt.Sprintf("$%02X", value)
+	}
+	return ".byte " + strings.Join(values, ",")
+}
+
+// WriteConfig writes an ld65 linker configuration which places all PRG banks consecutively into the output file.
+func (a *Analysis) WriteConfig(writer io.Writer) error {
+	var memory, segments strings.Builder
+	for bank := range a.ROM.BanksPRG {
+		fmt.Fprintf(&memory, "    PRG%02X: start = $%04X, size = $%04X, file = %%O, fill = yes;\n", bank, a.base(bank), bankSize)
+		fmt.Fprintf(&segments, "    PRG%02X: load = PRG%02X, type = ro;\n", bank, bank)
+	}
+
+	_, err := fmt.Fprintf(writer, "MEMORY {\n%s}\n\nSEGMENTS {\n%s}\n", memory.String(), segments.String())
+	return err
+}
@@ -0,0 +1,235 @@
+package disasm
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"nessie/asm"
+	"nessie/cartridge"
+	"nessie/processor"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const staticProgram = `
+.org $C000
+reset:
+  SEI
+  LDX #$FF
+  TXS
+  LDA a:$0010
+  JSR update
+  LDA table,X
+  STA $2000
+  STA $2008
+  .byte $1A          ; unofficial NOP
+  LDA table+1
+  JMP ($0300)
+update:
+  BIT $2002
+  BPL update
+  RTS
+table:
+  .byte $01, $02, $03
+indirect:
+  LDA #$02
+  RTS
+nmi:
+  RTI
+
+.org $FFFA
+  .word nmi, reset, nmi
+`
+
+func newStaticROM(t *testing.T, source string, banks int) *cartridge.ROMFile {
+	program, err := asm.Assemble(source)
+	if !assert.NoError(t, err, "could not assemble test program") {
+		t.FailNow()
+	}
+
+	header := []byte{'N', 'E', 'S', 0x1A, uint8(banks), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	prg := make([]byte, banks*bankSize)
+	for _, segment := range program.Segments {
+		offset := (banks-1)*bankSize + int(segment.Address-0xC000)
+		copy(prg[offset:], segment.Data)
+	}
+
+	rom, err := cartridge.NewROMFile(append(header, prg...))
+	if !assert.NoError(t, err, "could not load test rom") {
+		t.FailNow()
+	}
+	return rom
+}
+
+func reassemble(t *testing.T, analysis *Analysis) (string, []byte) {
+	var source bytes.Buffer
+	assert.NoError(t, analysis.WriteSource(&source))
+
+	program, err := asm.Assemble(source.String())
+	if !assert.NoError(t, err, "could not reassemble source:\n%s", source.String()) {
+		t.FailNow()
+	}
+	return source.String(), program.Bytes()
+}
+
+func TestAnalyze(t *testing.T) {
+	rom := newStaticROM(t, staticProgram, 1)
+	analysis, err := Analyze(processor.NewCPU(), rom, nil, nil)
+	assert.NoError(t, err)
+
+	program := asm.MustAssemble(staticProgram)
+	assert.True(t, analysis.IsCode(int(program.Symbols["update"]-0xC000)), "expected subroutine to be code")
+	assert.True(t, analysis.IsCode(int(program.Symbols["nmi"]-0xC000)), "expected nmi handler to be code")
+	assert.False(t, analysis.IsCode(int(program.Symbols["table"]-0xC000)), "expected table to be data")
+	assert.False(t, analysis.IsCode(int(program.Symbols["indirect"]-0xC000)), "expected indirect target to be unknown")
+
+	source, data := reassemble(t, analysis)
+	assert.Equal(t, rom.BanksPRG[0], data, "reassembled prg differs from original")
+	assert.Contains(t, source, "PPUCTRL = $2000\n")
+	assert.Contains(t, source, "RESET:\n  SEI\n")
+	assert.Contains(t, source, "  LDA a:$0010\n")
+	assert.Contains(t, source, "  JSR L_C01A\n")
+	assert.Contains(t, source, "  LDA L_C020,X\n")
+	assert.Contains(t, source, "  LDA L_C021\n")
+	assert.Contains(t, source, "  STA $2008                ; mirror of PPUCTRL\n")
+	assert.Contains(t, source, "  .byte $1A                ; NOP\n")
+	assert.Contains(t, source, "L_C01A:\n  BIT PPUSTATUS\n  BPL L_C01A\n")
+	assert.Contains(t, source, "  .res 16339, $00\n")
+	assert.Contains(t, source, "  .word NMI, RESET, NMI\n")
+}
+
+func TestAnalyzeCDL(t *testing.T) {
+	rom := newStaticROM(t, staticProgram, 1)
+	program := asm.MustAssemble(staticProgram)
+	indirect := int(program.Symbols["indirect"] - 0xC000)
+
+	cdl := make([]byte, bankSize)
+	cdl[indirect], cdl[indirect+1], cdl[indirect+2] = cdlCode, cdlCode, cdlCode
+	cdl[0] = cdlData
+
+	analysis, err := Analyze(processor.NewCPU(), rom, nil, cdl)
+	assert.NoError(t, err)
+	assert.True(t, analysis.IsCode(indirect), "expected logged code to be traced")
+	assert.False(t, analysis.IsCode(0), "expected logged data not to be traced")
+
+	source, data := reassemble(t, analysis)
+	assert.Equal(t, rom.BanksPRG[0], data, "reassembled prg differs from original")
+	assert.Contains(t, source, "  LDA #$02\n  RTS\n")
+
+	_, err = Analyze(processor.NewCPU(), rom, nil, cdl[:10])
+	assert.Error(t, err, "expected error for truncated code/data log")
+}
+
+func TestAnalyzeBanks(t *testing.T) {
+	rom := newStaticROM(t, staticProgram, 3)
+	rom.MapperID = mapperUxROM
+	rom.BanksPRG[0][0] = 0x60
+	rom.BanksPRG[1][0] = 0x60
+
+	symbols := NewSymbols()
+	symbols.Add(0x0300, "pointer")
+	symbols.AddPRG(2*bankSize+0x1A, "wait_vblank")
+
+	analysis, err := Analyze(processor.NewCPU(), rom, symbols, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0x8000), analysis.Address(bankSize), "expected switchable bank at $8000")
+	assert.Equal(t, uint16(0xC000), analysis.Address(2*bankSize), "expected fixed bank at $C000")
+
+	var source bytes.Buffer
+	assert.NoError(t, analysis.WriteSource(&source))
+	program, err := asm.Assemble(source.String())
+	assert.NoError(t, err)
+	if assert.Len(t, program.Segments, 3, "expected one segment per bank") {
+		for bank, segment := range program.Segments {
+			assert.Equal(t, rom.BanksPRG[bank], segment.Data, "reassembled bank %d differs from original", bank)
+		}
+	}
+	assert.Contains(t, source.String(), "pointer = $0300\n")
+	assert.Contains(t, source.String(), "  JMP (pointer)\n")
+	assert.Contains(t, source.String(), "  JSR wait_vblank\n")
+	assert.Contains(t, source.String(), ".segment \"PRG01\"\n.org $8000\n")
+
+	var config bytes.Buffer
+	assert.NoError(t, analysis.WriteConfig(&config))
+	assert.Equal(t, ""+
+		"MEMORY {\n"+
+		"    PRG00: start = $8000, size = $4000, file = %O, fill = yes;\n"+
+		"    PRG01: start = $8000, size = $4000, file = %O, fill = yes;\n"+
+		"    PRG02: start = $C000, size = $4000, file = %O, fill = yes;\n"+
+		"}\n\n"+
+		"SEGMENTS {\n"+
+		"    PRG00: load = PRG00, type = ro;\n"+
+		"    PRG01: load = PRG01, type = ro;\n"+
+		"    PRG02: load = PRG02, type = ro;\n"+
+		"}\n",
+		config.String())
+
+	// Other mappers place their banks differently, e.g. MMC1 can fix the first bank at $8000 instead
+	rom.MapperID = 0x01
+	_, err = Analyze(processor.NewCPU(), rom, symbols, nil)
+	assert.Error(t, err, "expected unknown bank layout to be rejected")
+}
+
+func TestAnalyzeNestest(t *testing.T) {
+	data, err := ioutil.ReadFile("../system/roms/nestest.nes")
+	assert.NoError(t, err, "could not read nestest rom")
+	rom, err := cartridge.NewROMFile(data)
+	assert.NoError(t, err, "could not load nestest rom")
+
+	analysis, err := Analyze(processor.NewCPU(), rom, nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, analysis.IsCode(0x0004), "expected reset handler to be code")
+
+	_, prg := reassemble(t, analysis)
+	assert.Equal(t, rom.BanksPRG[0], prg, "reassembled prg differs from original")
+}
+
+// TestCA65 links the written source and configuration using the cc65 toolchain, which is only done if it is installed
+func TestCA65(t *testing.T) {
+	for _, tool := range []string{"ca65", "ld65"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found in PATH", tool)
+		}
+	}
+
+	directory, err := ioutil.TempDir("", "nessie-ca65")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(directory)
+
+	data, err := ioutil.ReadFile("../system/roms/nestest.nes")
+	assert.NoError(t, err, "could not read nestest rom")
+	nestest, err := cartridge.NewROMFile(data)
+	assert.NoError(t, err, "could not load nestest rom")
+	banks := newStaticROM(t, staticProgram, 3)
+	banks.MapperID = mapperUxROM
+
+	for name, rom := range map[string]*cartridge.ROMFile{"nestest": nestest, "banks": banks} {
+		analysis, err := Analyze(processor.NewCPU(), rom, nil, nil)
+		assert.NoError(t, err)
+
+		prefix := filepath.Join(directory, name)
+		var source, config bytes.Buffer
+		assert.NoError(t, analysis.WriteSource(&source))
+		assert.NoError(t, analysis.WriteConfig(&config))
+		assert.NoError(t, ioutil.WriteFile(prefix+".s", source.Bytes(), 0644))
+		assert.NoError(t, ioutil.WriteFile(prefix+".cfg", config.Bytes(), 0644))
+
+		for _, command := range []*exec.Cmd{
+			exec.Command("ca65", "-o", prefix+".o", prefix+".s"),
+			exec.Command("ld65", "-C", prefix+".cfg", "-o", prefix+".bin", prefix+".o"),
+		} {
+			output, err := command.CombinedOutput()
+			if !assert.NoError(t, err, "%s failed for %s:\n%s", command.Args[0], name, output) {
+				t.FailNow()
+			}
+		}
+
+		prg, err := ioutil.ReadFile(prefix + ".bin")
+		assert.NoError(t, err)
+		assert.Equal(t, bytes.Join(rom.BanksPRG, nil), prg, "linked %s prg differs from original", name)
+	}
+}
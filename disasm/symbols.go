@@ -0,0 +1,194 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Symbols maps CPU addresses and PRG ROM offsets to names. PRG ROM offsets are used by debuggers like Mesen, which
+// label banked code independently of where it is currently mapped.
+type Symbols struct {
+	addresses map[uint16]string
+	prg       map[int]string
+}
+
+func NewSymbols() *Symbols {
+	return &Symbols{
+		addresses: make(map[uint16]string),
+		prg:       make(map[int]string),
+	}
+}
+
+// LoadSymbols reads a symbol file, picking the format based on its extension: `.dbg` for ca65 debug information,
+// `.nl` for FCEUX name lists and `.mlb` for Mesen label files.
+func LoadSymbols(path string) (*Symbols, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open symbol file: %v", err)
+	}
+	defer file.Close()
+
+	symbols := NewSymbols()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dbg":
+		err = symbols.ReadDBG(file)
+	case ".nl":
+		err = symbols.ReadNL(file)
+	case ".mlb":
+		err = symbols.ReadMLB(file)
+	default:
+		err = fmt.Errorf("unsupported symbol file: %s", filepath.Base(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return symbols, nil
+}
+
+func (s *Symbols) Add(address uint16, name string) {
+	s.addresses[address] = name
+}
+
+func (s *Symbols) AddPRG(offset int, name string) {
+	s.prg[offset] = name
+}
+
+func (s *Symbols) Lookup(address uint16) (name string, ok bool) {
+	if s == nil {
+		return "", false
+	}
+	name, ok = s.addresses[address]
+	return
+}
+
+func (s *Symbols) LookupPRG(offset int) (name string, ok bool) {
+	if s == nil {
+		return "", false
+	}
+	name, ok = s.prg[offset]
+	return
+}
+
+func (s *Symbols) Len() int {
+	return len(s.addresses) + len(s.prg)
+}
+
+// ReadNL reads an FCEUX name list, which consists of lines like `$C000#Reset#Comment`. Arrays declared with a size
+// suffix such as `$0300/10#buffer#` only name their first address.
+func (s *Symbols) ReadNL(reader io.Reader) error {
+	return readLines(reader, func(line string) error {
+		if !strings.HasPrefix(line, "$") {
+			return nil
+		}
+
+		parts := strings.Split(line[1:], "#")
+		if len(parts) < 2 || parts[1] == "" {
+			return nil
+		}
+
+		address, err := parseAddress(strings.SplitN(parts[0], "/", 2)[0])
+		if err != nil {
+			return err
+		}
+		s.Add(address, parts[1])
+		return nil
+	})
+}
+
+// ReadMLB reads a Mesen label file, which consists of lines like `P:0123:Label:Comment`. Both the single letter memory
+// types of Mesen and the long memory type names of Mesen 2 are supported. Save and work RAM labels get placed at their
+// usual CPU addresses starting at $6000.
+func (s *Symbols) ReadMLB(reader io.Reader) error {
+	return readLines(reader, func(line string) error {
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) < 3 || parts[2] == "" {
+			return nil
+		}
+
+		value, err := strconv.ParseUint(strings.SplitN(parts[1], "-", 2)[0], 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid label address: %s", parts[1])
+		}
+
+		switch parts[0] {
+		case "P", "NesPrgRom":
+			s.AddPRG(int(value), parts[2])
+		case "R", "G", "NesInternalRam", "NesMemory":
+			s.Add(uint16(value), parts[2])
+		case "S", "W", "NesSaveRam", "NesWorkRam":
+			s.Add(uint16(0x6000+value), parts[2])
+		}
+		return nil
+	})
+}
+
+// ReadDBG reads the symbols of a ca65/ld65 debug information file. Only labels and equates with a known value are
+// imported, which excludes unresolved imports.
+func (s *Symbols) ReadDBG(reader io.Reader) error {
+	return readLines(reader, func(line string) error {
+		if !strings.HasPrefix(line, "sym\t") {
+			return nil
+		}
+
+		attributes := parseAttributes(line[4:])
+		if attributes["type"] != "lab" && attributes["type"] != "equ" {
+			return nil
+		}
+
+		name, value := strings.Trim(attributes["name"], `"`), attributes["val"]
+		if name == "" || value == "" {
+			return nil
+		}
+
+		// Equates can hold arbitrary constants, which can not be used as an address
+		address, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			return fmt.Errorf("invalid symbol value: %s", value)
+		}
+		if address <= 0xFFFF {
+			s.Add(uint16(address), name)
+		}
+		return nil
+	})
+}
+
+func readLines(reader io.Reader, handler func(line string) error) error {
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if err := handler(line); err != nil {
+			return fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func parseAttributes(value string) map[string]string {
+	attributes := make(map[string]string)
+	for _, attribute := range strings.Split(value, ",") {
+		parts := strings.SplitN(attribute, "=", 2)
+		if len(parts) == 2 {
+			attributes[parts[0]] = parts[1]
+		}
+	}
+	return attributes
+}
+
+func parseAddress(value string) (uint16, error) {
+	address, err := strconv.ParseUint(value, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address: %s", value)
+	}
+	return uint16(address), nil
+}
@@ -0,0 +1,73 @@
+package disasm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func assertSymbol(t *testing.T, symbols *Symbols, address uint16, expected string) {
+	name, ok := symbols.Lookup(address)
+	assert.True(t, ok, "expected symbol at $%04X", address)
+	assert.Equal(t, expected, name, "unexpected symbol at $%04X", address)
+}
+
+func TestReadNL(t *testing.T) {
+	symbols := NewSymbols()
+	assert.NoError(t, symbols.ReadNL(strings.NewReader(
+		"$C000#Reset#Entry point\n"+
+			"$0300/10#buffer#\n"+
+			"$C010##Comment only\n"+
+			"garbage\n",
+	)))
+
+	assertSymbol(t, symbols, 0xC000, "Reset")
+	assertSymbol(t, symbols, 0x0300, "buffer")
+	assert.Equal(t, 2, symbols.Len(), "expected comments to be skipped")
+
+	assert.Error(t, NewSymbols().ReadNL(strings.NewReader("$XYZ#name#\n")))
+}
+
+func TestReadMLB(t *testing.T) {
+	symbols := NewSymbols()
+	assert.NoError(t, symbols.ReadMLB(strings.NewReader(
+		"P:0010:nmi_handler:Handles vblank\n"+
+			"R:0000-000F:temp\n"+
+			"G:2000:ppu_control\n"+
+			"W:0100:save_slot\n"+
+			"NesPrgRom:4000:bank1_start\n"+
+			"P:0020::Comment only\n",
+	)))
+
+	name, ok := symbols.LookupPRG(0x0010)
+	assert.True(t, ok)
+	assert.Equal(t, "nmi_handler", name)
+	name, _ = symbols.LookupPRG(0x4000)
+	assert.Equal(t, "bank1_start", name)
+	assertSymbol(t, symbols, 0x0000, "temp")
+	assertSymbol(t, symbols, 0x2000, "ppu_control")
+	assertSymbol(t, symbols, 0x6100, "save_slot")
+	assert.Equal(t, 5, symbols.Len())
+}
+
+func TestReadDBG(t *testing.T) {
+	symbols := NewSymbols()
+	assert.NoError(t, symbols.ReadDBG(strings.NewReader(
+		"version\tmajor=2,minor=0\n"+
+			"sym\tid=0,name=\"reset\",addrsize=absolute,size=1,scope=0,def=12,ref=40,val=0xC000,seg=0,type=lab\n"+
+			"sym\tid=1,name=\"PLAYER_X\",addrsize=zeropage,scope=0,def=3,val=0x10,type=equ\n"+
+			"sym\tid=2,name=\"BIG\",addrsize=absolute,scope=0,def=4,val=0x123456,type=equ\n"+
+			"sym\tid=3,name=\"extern\",addrsize=absolute,scope=0,def=5,type=imp,exp=7\n",
+	)))
+
+	assertSymbol(t, symbols, 0xC000, "reset")
+	assertSymbol(t, symbols, 0x0010, "PLAYER_X")
+	assert.Equal(t, 2, symbols.Len(), "expected large equates and imports to be skipped")
+}
+
+func TestLoadSymbols(t *testing.T) {
+	_, err := LoadSymbols("missing.nl")
+	assert.Error(t, err)
+	_, err = LoadSymbols("symbols_test.go")
+	assert.Error(t, err, "expected unsupported extension to be rejected")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"nessie/cartridge"
+	"nessie/disasm"
+	"nessie/processor"
+	"os"
+)
+
+// runDisassemble statically disassembles the PRG ROM of a NROM or UxROM file into ca65 source and a matching ld65
+// linker configuration, which get written next to each other using the given output prefix.
+func runDisassemble(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	output := flags.String("o", "prg", "prefix of the written .s and .cfg files")
+	symbolPath := flags.String("symbols", "", "symbol file for naming labels: .dbg, .nl or .mlb")
+	cdlPath := flags.String("cdl", "", "fceux code/data log for resolving indirect jumps")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: nessie disasm [options] <rom>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return fail("disasm", fmt.Errorf("unable to open rom: %v", err))
+	}
+	rom, err := cartridge.NewROMFile(data)
+	if err != nil {
+		return fail("disasm", err)
+	}
+
+	var symbols *disasm.Symbols
+	if *symbolPath != "" {
+		if symbols, err = disasm.LoadSymbols(*symbolPath); err != nil {
+			return fail("disasm", err)
+		}
+	}
+	var cdl []byte
+	if *cdlPath != "" {
+		if cdl, err = ioutil.ReadFile(*cdlPath); err != nil {
+			return fail("disasm", fmt.Errorf("unable to open code/data log: %v", err))
+		}
+	}
+
+	analysis, err := disasm.Analyze(processor.NewCPU(), rom, symbols, cdl)
+	if err != nil {
+		return fail("disasm", err)
+	}
+	if err := writeFile(*output+".s", analysis.WriteSource); err != nil {
+		return fail("disasm", err)
+	}
+	if err := writeFile(*output+".cfg", analysis.WriteConfig); err != nil {
+		return fail("disasm", err)
+	}
+
+	return 0
+}
+
+func writeFile(path string, write func(writer io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
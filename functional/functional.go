@@ -0,0 +1,102 @@
+package functional
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nessie/processor"
+)
+
+// Options configure how a test image gets loaded and how its outcome is determined. The defaults match the binary
+// of Klaus Dormann's 6502 functional test, except for the success address which depends on the assembled version.
+type Options struct {
+	Variant processor.Variant
+	// Origin is the address at which the image gets loaded
+	Origin uint16
+	// Start is the initial program counter
+	Start uint16
+	// Success is the address of the trap which gets reached once all tests passed
+	Success uint16
+	// ErrorAddress optionally points to a byte which has to be zero for the test to pass, as used by the decimal test
+	ErrorAddress uint16
+	CheckError   bool
+	// FeedbackPort is the address of an output port which drives the interrupt lines when written, as used by the
+	// interrupt test. The port is only emulated if at least one of the interrupt bits is non-zero.
+	FeedbackPort uint16
+	FeedbackIRQ  uint8
+	FeedbackNMI  uint8
+	// MaxInstructions aborts tests which do not reach any trap, zero disables the limit
+	MaxInstructions uint64
+}
+
+type Result struct {
+	Success      bool
+	Trap         uint16
+	Registers    processor.Registers
+	Cycles       processor.Cycles
+	Instructions uint64
+}
+
+// LoadFile reads a raw binary image from disk and runs it.
+func LoadFile(path string, options Options) (Result, error) {
+	image, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Result{}, fmt.Errorf("unable to open test image: %v", err)
+	}
+	return Run(image, options)
+}
+
+// Run loads the image into a flat 64 KiB memory and executes it until the program counter traps, which means that an
+// instruction branched or jumped to itself. Jamming the CPU, e.g. by executing STP, is treated as a trap as well.
+func Run(image []byte, options Options) (Result, error) {
+	if int(options.Origin)+len(image) > processor.DefaultMemorySize {
+		return Result{}, fmt.Errorf("test image does not fit into memory: %d bytes at $%04X", len(image), options.Origin)
+	}
+
+	memory := processor.NewBasicMemory()
+	for i, value := range image {
+		memory.Poke(options.Origin+uint16(i), value)
+	}
+
+	cpu := processor.NewCPU(processor.WithVariant(options.Variant))
+	cpu.Memory = processor.NewMappedMemory(memory)
+	cpu.Registers.PC = options.Start
+
+	if options.FeedbackIRQ|options.FeedbackNMI != 0 {
+		cpu.OnMemoryAccess(func(access processor.MemoryAccess) {
+			if access.Type == processor.AccessWrite && access.Address == options.FeedbackPort {
+				cpu.SetIRQ(access.Value&options.FeedbackIRQ != 0)
+				cpu.SetNMI(access.Value&options.FeedbackNMI != 0)
+			}
+		})
+	}
+
+	var result Result
+	pc, trapped := cpu.Registers.PC, false
+	_, _, err := cpu.RunUntil(func(cpu *processor.CPU) bool {
+		result.Instructions++
+		trapped = cpu.Registers.PC == pc
+		pc = cpu.Registers.PC
+		return trapped || (options.MaxInstructions > 0 && result.Instructions >= options.MaxInstructions)
+	})
+	result.Trap, result.Registers, result.Cycles = pc, cpu.Registers, cpu.TotalCycles
+	if err != nil && err != processor.ErrCPUJammed {
+		return result, err
+	}
+	if err == nil && !trapped {
+		return result, fmt.Errorf("no trap reached after %d instructions", result.Instructions)
+	}
+
+	result.Success = result.Trap == options.Success
+	if options.CheckError && memory.DebugPeek(options.ErrorAddress) != 0 {
+		result.Success = false
+	}
+	return result, nil
+}
+
+func (r Result) String() string {
+	status := "failed"
+	if r.Success {
+		status = "passed"
+	}
+	return fmt.Sprintf("%s: trapped at $%04X after %d instructions and %d cycles", status, r.Trap, r.Instructions, r.Cycles)
+}
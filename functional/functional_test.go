@@ -0,0 +1,198 @@
+package functional
+
+import (
+	"github.com/stretchr/testify/assert"
+	"nessie/asm"
+	"nessie/processor"
+	"os"
+	"testing"
+)
+
+// image assembles the source and places it in a flat 64 KiB image
+func image(t *testing.T, source string, variant processor.Variant) ([]byte, map[string]uint16) {
+	program, err := asm.Assemble(source, asm.WithVariant(variant))
+	if !assert.NoError(t, err, "could not assemble test program") {
+		t.FailNow()
+	}
+
+	data := make([]byte, processor.DefaultMemorySize)
+	for _, segment := range program.Segments {
+		copy(data[segment.Address:], segment.Data)
+	}
+	return data, program.Symbols
+}
+
+const trapProgram = `
+.org $0400
+start:
+  LDA #$10
+  CMP #$10
+  BNE failed
+  LDX #$05
+@loop:
+  DEX
+  BNE @loop
+  CPX #$00
+  BNE failed
+success:
+  JMP success
+failed:
+  BNE failed
+`
+
+func TestRun(t *testing.T) {
+	data, symbols := image(t, trapProgram, processor.NMOS6502)
+	options := Options{Variant: processor.NMOS6502, Start: 0x0400, Success: symbols["success"]}
+
+	result, err := Run(data, options)
+	assert.NoError(t, err)
+	assert.True(t, result.Success, "expected test to pass: %v", result)
+	assert.Equal(t, symbols["success"], result.Trap, "unexpected trap address")
+	assert.Equal(t, uint64(4+2*5+2+1), result.Instructions, "unexpected instruction count")
+
+	// Failing a comparison ends up in the trap at the failure address
+	data[symbols["start"]+1] = 0x11
+	result, err = Run(data, options)
+	assert.NoError(t, err)
+	assert.False(t, result.Success, "expected test to fail")
+	assert.Equal(t, symbols["failed"], result.Trap, "unexpected trap address")
+	assert.Equal(t, "failed: trapped at $0412 after 4 instructions and 10 cycles", result.String())
+}
+
+func TestRunErrors(t *testing.T) {
+	_, err := Run(make([]byte, 0x100), Options{Origin: 0xFFF0})
+	assert.Error(t, err, "expected error for image exceeding memory")
+
+	// An endless loop without trap runs into the instruction limit
+	data, _ := image(t, ".org $0400\nloop:\n  INX\n  JMP loop\n", processor.NMOS6502)
+	_, err = Run(data, Options{Start: 0x0400, MaxInstructions: 1000})
+	assert.Error(t, err, "expected error for missing trap")
+
+	_, err = LoadFile("missing.bin", Options{})
+	assert.Error(t, err, "expected error for missing file")
+}
+
+func TestRunJammed(t *testing.T) {
+	data, symbols := image(t, ".org $0400\n  NOP\ndone:\n  STP\n", processor.WDC65C02)
+	result, err := Run(data, Options{Variant: processor.WDC65C02, Start: 0x0400, Success: symbols["done"]})
+	assert.NoError(t, err)
+	assert.True(t, result.Success, "expected stopped cpu to count as trap: %v", result)
+}
+
+// decimalProgram mimics the decimal test, which stores a non-zero error byte and stops at the same trap either way
+const decimalProgram = `
+ERROR = $0B
+.org $0200
+start:
+  SED
+  CLC
+  LDA #$19
+  ADC #$28
+  CLD
+  CMP #$47
+  BEQ @done
+  INC ERROR
+@done:
+  JMP @done
+`
+
+func TestRunDecimal(t *testing.T) {
+	data, symbols := image(t, decimalProgram, processor.NMOS6502)
+	options := Options{Start: 0x0200, Success: symbols["start@done"], ErrorAddress: 0x0B, CheckError: true}
+
+	options.Variant = processor.NMOS6502
+	result, err := Run(data, options)
+	assert.NoError(t, err)
+	assert.True(t, result.Success, "expected decimal test to pass on the nmos 6502: %v", result)
+
+	// The 2A03 lacks decimal mode, so the same trap is reached with the error byte set
+	options.Variant = processor.Ricoh2A03
+	result, err = Run(data, options)
+	assert.NoError(t, err)
+	assert.False(t, result.Success, "expected decimal test to fail on the 2a03")
+	assert.Equal(t, symbols["start@done"], result.Trap, "unexpected trap address")
+}
+
+// interruptProgram raises both interrupts through the feedback port and expects each handler to run exactly once
+const interruptProgram = `
+PORT = $BFFC
+.org $0400
+start:
+  LDX #$FF
+  TXS
+  LDA #$00
+  STA PORT
+  STA $00
+  STA $01
+  CLI
+  LDA #$01
+  STA PORT
+  NOP
+  LDA #$02
+  STA PORT
+  NOP
+  LDA $00
+  CMP #$01
+  BNE failed
+  LDA $01
+  CMP #$01
+  BNE failed
+success:
+  JMP success
+failed:
+  JMP failed
+nmi:
+  INC $01
+  LDA #$00
+  STA PORT
+  RTI
+irq:
+  INC $00
+  LDA #$00
+  STA PORT
+  RTI
+
+.org $FFFA
+  .word nmi, start, irq
+`
+
+func TestRunInterrupts(t *testing.T) {
+	data, symbols := image(t, interruptProgram, processor.NMOS6502)
+	options := Options{Variant: processor.NMOS6502, Start: 0x0400, Success: symbols["success"], FeedbackPort: 0xBFFC}
+
+	result, err := Run(data, options)
+	assert.NoError(t, err)
+	assert.Equal(t, symbols["failed"], result.Trap, "expected interrupts not to fire without feedback port")
+
+	options.FeedbackIRQ, options.FeedbackNMI = 0x01, 0x02
+	result, err = Run(data, options)
+	assert.NoError(t, err)
+	assert.True(t, result.Success, "expected interrupt test to pass: %v", result)
+}
+
+// TestFunctionalSuite runs Klaus Dormann's binaries if the directory containing them is given by the environment
+// variable NESSIE_FUNCTIONAL_DIR. The addresses match the listings of the binaries shipped in the bin_files directory.
+func TestFunctionalSuite(t *testing.T) {
+	directory := os.Getenv("NESSIE_FUNCTIONAL_DIR")
+	if directory == "" {
+		t.Skip("NESSIE_FUNCTIONAL_DIR not set")
+	}
+
+	tests := []struct {
+		file    string
+		options Options
+	}{
+		{"6502_functional_test.bin", Options{Variant: processor.NMOS6502, Start: 0x0400, Success: 0x3469}},
+		// The decimal test stops at the same trap either way, with its ERROR byte at $0B being zero if it passed
+		{"6502_decimal_test.bin", Options{Variant: processor.NMOS6502, Start: 0x0200, Success: 0x024B,
+			ErrorAddress: 0x000B, CheckError: true}},
+		// The interrupt test drives IRQ with bit 0 and NMI with bit 1 of its feedback port
+		{"6502_interrupt_test.bin", Options{Variant: processor.NMOS6502, Start: 0x0400, Success: 0x06F5,
+			FeedbackPort: 0xBFFC, FeedbackIRQ: 0x01, FeedbackNMI: 0x02}},
+	}
+	for _, test := range tests {
+		result, err := LoadFile(directory+"/"+test.file, test.options)
+		assert.NoError(t, err)
+		assert.True(t, result.Success, "%s: %v", test.file, result)
+	}
+}
@@ -1,7 +1,21 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "tracediff":
+			os.Exit(runTraceDiff(os.Args[2:]))
+		case "disasm":
+			os.Exit(runDisassemble(os.Args[2:]))
+		case "singlestep":
+			os.Exit(runSingleStep(os.Args[2:]))
+		}
+	}
+
 	fmt.Println("Nessie")
 }
@@ -1,10 +1,8 @@
 package processor
 
-import "fmt"
-
 type AddressingMode int
-type AddressingHandler func() (address uint16, extraCycles Cycles)
-type AddressingHandlerTable map[AddressingMode]AddressingHandler
+type AddressingHandler func(write bool) (address uint16, extraCycles Cycles)
+type AddressingHandlerTable [addressingModeCount]AddressingHandler
 
 const (
 	Implicit    AddressingMode = iota
@@ -20,115 +18,161 @@ const (
 	Indirect    AddressingMode = iota
 	IndirectX   AddressingMode = iota
 	IndirectY   AddressingMode = iota
+
+	// Additional addressing modes of the WDC 65C02
+	ZeroPageIndirect  AddressingMode = iota
+	AbsoluteIndirectX AddressingMode = iota
+	ZeroPageRelative  AddressingMode = iota
+
+	addressingModeCount = iota
 )
 
-func (c *CPU) amImplicit() (address uint16, extraCycles Cycles) {
-	panic(fmt.Errorf("unable to lookup implicit addressing mode"))
-}
+// Implicit and accumulator addressing have no operand, so they only point at the byte after the opcode, which gets
+// read as a dummy value during the second cycle of every single-byte instruction.
 
-func (c *CPU) amAccumulator() (address uint16, extraCycles Cycles) {
-	panic(fmt.Errorf("unable to lookup accumulator addressing mode"))
+func (c *CPU) amImplicit(write bool) (address uint16, extraCycles Cycles) {
+	address = c.Registers.PC
+	return
 }
 
-func (c *CPU) amImmediate() (address uint16, extraCycles Cycles) {
+func (c *CPU) amAccumulator(write bool) (address uint16, extraCycles Cycles) {
 	address = c.Registers.PC
-	c.Registers.PC++
 	return
 }
 
-func (c *CPU) amZeroPage() (address uint16, extraCycles Cycles) {
-	address = uint16(c.Memory.Peek(c.Registers.PC))
+func (c *CPU) amImmediate(write bool) (address uint16, extraCycles Cycles) {
+	address = c.Registers.PC
 	c.Registers.PC++
 	return
 }
 
-func (c *CPU) amZeroPageX() (address uint16, extraCycles Cycles) {
-	address = uint16(c.Memory.Peek(c.Registers.PC)+c.Registers.X) & 0xFF
-	c.Registers.PC++
+func (c *CPU) amZeroPage(write bool) (address uint16, extraCycles Cycles) {
+	address = uint16(c.fetch())
 	return
 }
 
-func (c *CPU) amZeroPageY() (address uint16, extraCycles Cycles) {
-	address = uint16(c.Memory.Peek(c.Registers.PC)+c.Registers.Y) & 0xFF
-	c.Registers.PC++
+func (c *CPU) amZeroPageX(write bool) (address uint16, extraCycles Cycles) {
+	baseAddress := c.fetch()
+	c.dummyRead(uint16(baseAddress))
+	address = uint16(baseAddress + c.Registers.X)
 	return
 }
 
-func (c *CPU) amRelative() (address uint16, extraCycles Cycles) {
-	value := c.Memory.Peek(c.Registers.PC)
-	c.Registers.PC++
+func (c *CPU) amZeroPageY(write bool) (address uint16, extraCycles Cycles) {
+	baseAddress := c.fetch()
+	c.dummyRead(uint16(baseAddress))
+	address = uint16(baseAddress + c.Registers.Y)
+	return
+}
 
-	offset := c.toSigned(value)
+func (c *CPU) amRelative(write bool) (address uint16, extraCycles Cycles) {
+	offset := c.toSigned(c.fetch())
 	address = uint16(int16(c.Registers.PC) + int16(offset))
 	return
 }
 
-func (c *CPU) amAbsolute() (address uint16, extraCycles Cycles) {
-	address = c.Memory.Peek16(c.Registers.PC)
-	c.Registers.PC += 2
+func (c *CPU) amAbsolute(write bool) (address uint16, extraCycles Cycles) {
+	address = c.fetch16()
 	return
 }
 
-func (c *CPU) amAbsoluteX() (address uint16, extraCycles Cycles) {
-	addressPtr := c.Memory.Peek16(c.Registers.PC)
-	c.Registers.PC += 2
+func (c *CPU) amAbsoluteX(write bool) (address uint16, extraCycles Cycles) {
+	baseAddress := c.fetch16()
+	address, extraCycles = c.indexAddress(baseAddress, c.Registers.X, write)
+	return
+}
 
-	address = addressPtr + uint16(c.Registers.X)
-	if !SamePage(addressPtr, address) {
-		extraCycles = 1
+func (c *CPU) amAbsoluteY(write bool) (address uint16, extraCycles Cycles) {
+	baseAddress := c.fetch16()
+	address, extraCycles = c.indexAddress(baseAddress, c.Registers.Y, write)
+	return
+}
+
+func (c *CPU) amIndirect(write bool) (address uint16, extraCycles Cycles) {
+	addressPtr := c.fetch16()
+
+	// The NMOS 6502 does not carry into the high byte of the pointer, which got fixed with an extra cycle by the 65C02
+	wrappedPtr := (addressPtr & 0xFF00) | ((addressPtr + 1) & 0x00FF)
+	if c.variant == WDC65C02 {
+		c.dummyRead(c.Registers.PC - 1)
+		wrappedPtr = addressPtr + 1
 	}
 
+	addressLow := uint16(c.read(addressPtr))
+	addressHigh := uint16(c.read(wrappedPtr))
+	address = addressLow | addressHigh<<8
+
 	return
 }
 
-func (c *CPU) amAbsoluteY() (address uint16, extraCycles Cycles) {
-	addressPtr := c.Memory.Peek16(c.Registers.PC)
-	c.Registers.PC += 2
+func (c *CPU) amIndirectX(write bool) (address uint16, extraCycles Cycles) {
+	basePtr := c.fetch()
+	c.dummyRead(uint16(basePtr))
+	addressPtr := basePtr + c.Registers.X
 
-	address = addressPtr + uint16(c.Registers.Y)
-	if !SamePage(addressPtr, address) {
-		extraCycles = 1
-	}
+	addressLow := uint16(c.read(uint16(addressPtr)))
+	addressHigh := uint16(c.read(uint16(addressPtr + 1)))
+	address = addressLow | (addressHigh << 8)
 
 	return
 }
 
-func (c *CPU) amIndirect() (address uint16, extraCycles Cycles) {
-	addressPtr := c.Memory.Peek16(c.Registers.PC)
-	c.Registers.PC += 2
+func (c *CPU) amIndirectY(write bool) (address uint16, extraCycles Cycles) {
+	addressPtr := c.fetch()
 
-	wrappedPtr := (addressPtr & 0xFF00) | ((addressPtr + 1) & 0x00FF)
-	addressLow := uint16(c.Memory.Peek(addressPtr))
-	addressHigh := uint16(c.Memory.Peek(wrappedPtr))
-	address = addressLow | addressHigh<<8
+	baseLow := uint16(c.read(uint16(addressPtr)))
+	baseHigh := uint16(c.read(uint16(addressPtr + 1)))
+	baseAddress := baseLow | (baseHigh << 8)
+	address, extraCycles = c.indexAddress(baseAddress, c.Registers.Y, write)
 
 	return
 }
 
-func (c *CPU) amIndirectX() (address uint16, extraCycles Cycles) {
-	addressPtr := uint16(c.Memory.Peek(c.Registers.PC) + c.Registers.X)
-	c.Registers.PC++
+func (c *CPU) amZeroPageIndirect(write bool) (address uint16, extraCycles Cycles) {
+	addressPtr := c.fetch()
 
-	addressLow := uint16(c.Memory.Peek(addressPtr))
-	addressHigh := uint16(c.Memory.Peek((addressPtr + 1) & 0xFF))
+	addressLow := uint16(c.read(uint16(addressPtr)))
+	addressHigh := uint16(c.read(uint16(addressPtr + 1)))
 	address = addressLow | (addressHigh << 8)
 
 	return
 }
 
-func (c *CPU) amIndirectY() (address uint16, extraCycles Cycles) {
-	addressPtr := uint16(c.Memory.Peek(c.Registers.PC))
-	c.Registers.PC++
+func (c *CPU) amAbsoluteIndirectX(write bool) (address uint16, extraCycles Cycles) {
+	basePtr := c.fetch16()
+	c.dummyRead(c.Registers.PC - 1)
+	addressPtr := basePtr + uint16(c.Registers.X)
 
-	baseLow := uint16(c.Memory.Peek(addressPtr))
-	baseHigh := uint16(c.Memory.Peek((addressPtr + 1) & 0xFF))
-	baseAddress := baseLow | (baseHigh << 8)
-	address = baseAddress + uint16(c.Registers.Y)
+	addressLow := uint16(c.read(addressPtr))
+	addressHigh := uint16(c.read(addressPtr + 1))
+	address = addressLow | (addressHigh << 8)
+
+	return
+}
 
+func (c *CPU) amZeroPageRelative(write bool) (address uint16, extraCycles Cycles) {
+	// Only resolves the zero page address, the branch offset is fetched separately by BBR and BBS
+	address = uint16(c.fetch())
+	return
+}
+
+// indexAddress adds an index to a base address. The CPU first reads from the address with an uncorrected high byte,
+// which is a dummy read if the page was crossed and always happens for write and read-modify-write instructions.
+func (c *CPU) indexAddress(baseAddress uint16, index uint8, write bool) (address uint16, extraCycles Cycles) {
+	address = baseAddress + uint16(index)
 	if !SamePage(baseAddress, address) {
 		extraCycles = 1
 	}
 
+	// The 65C02 does not read from the invalid address and re-reads the last instruction byte instead
+	if extraCycles > 0 || write {
+		if c.variant == WDC65C02 {
+			c.dummyRead(c.Registers.PC - 1)
+		} else {
+			c.dummyRead((baseAddress & 0xFF00) | (address & 0x00FF))
+		}
+	}
+
 	return
 }
 
@@ -147,14 +191,35 @@ func (c *CPU) registerAddressingHandlers() {
 		Indirect:    c.amIndirect,
 		IndirectX:   c.amIndirectX,
 		IndirectY:   c.amIndirectY,
+
+		ZeroPageIndirect:  c.amZeroPageIndirect,
+		AbsoluteIndirectX: c.amAbsoluteIndirectX,
+		ZeroPageRelative:  c.amZeroPageRelative,
 	}
 }
 
 func (c *CPU) lookupAddress(mode AddressingMode) (address uint16, extraCycles Cycles) {
-	address, extraCycles = c.addressingHandlers[mode]()
+	address, extraCycles = c.addressingHandlers[mode](false)
+	return
+}
+
+func (c *CPU) lookupWriteAddress(mode AddressingMode) (address uint16) {
+	address, _ = c.addressingHandlers[mode](true)
 	return
 }
 
+// OperandLength returns the amount of bytes following the opcode of an instruction using the given addressing mode.
+func OperandLength(mode AddressingMode) int {
+	switch mode {
+	case Implicit, Accumulator:
+		return 0
+	case Absolute, AbsoluteX, AbsoluteY, Indirect, AbsoluteIndirectX, ZeroPageRelative:
+		return 2
+	default:
+		return 1
+	}
+}
+
 func AddressingModeName(mode AddressingMode) string {
 	switch mode {
 	case Implicit:
@@ -183,6 +248,12 @@ func AddressingModeName(mode AddressingMode) string {
 		return "IndirectX"
 	case IndirectY:
 		return "IndirectY"
+	case ZeroPageIndirect:
+		return "ZeroPageIndirect"
+	case AbsoluteIndirectX:
+		return "AbsoluteIndirectX"
+	case ZeroPageRelative:
+		return "ZeroPageRelative"
 	default:
 		return "<unknown>"
 	}
@@ -0,0 +1,115 @@
+package processor
+
+// Every cycle of the CPU performs exactly one bus access, so all instructions are built from the following primitives.
+// Each of them advances the cycle counter and notifies the cycle handler before the actual access takes place, which
+// allows other components to be stepped in lockstep with the CPU. Memory access hooks get notified afterwards. When
+// being run by Tick, the instruction additionally pauses ahead of every cycle.
+
+func (c *CPU) cycle() {
+	if c.ticking || c.OnCycle != nil {
+		c.notifyCycle()
+		return
+	}
+	c.TotalCycles++
+}
+
+// notifyCycle additionally pauses ahead of the cycle when being ticked and calls the cycle handler
+func (c *CPU) notifyCycle() {
+	if c.ticking {
+		c.ticker.pause()
+	}
+	c.TotalCycles++
+	if c.OnCycle != nil {
+		c.OnCycle(c.TotalCycles)
+	}
+}
+
+func (c *CPU) read(address uint16) (value uint8) {
+	value = c.readAccess(address, AccessRead)
+	return
+}
+
+// readAccess takes the value from the decode cache if the address belongs to the bytes cached for the current
+// instruction and its page did not change since
+func (c *CPU) readAccess(address uint16, accessType AccessType) (value uint8) {
+	c.cycle()
+	if decoded := c.decoded; decoded != nil && address-decoded.address < decoded.size &&
+		c.Memory.generations[address>>8] == decoded.generation {
+		value = decoded.bytes[address-decoded.address]
+		c.Memory.bus = value
+	} else {
+		value = c.Memory.Peek(address)
+	}
+	if len(c.hooks.memoryAccess) > 0 {
+		c.notifyMemoryAccess(accessType, address, value)
+	}
+	return
+}
+
+// dummyRead performs a read whose value gets discarded. Such reads still have side effects on memory-mapped registers,
+// but are reported separately to memory access hooks.
+func (c *CPU) dummyRead(address uint16) {
+	c.readAccess(address, AccessDummyRead)
+}
+
+func (c *CPU) read16(address uint16) (value uint16) {
+	value = uint16(c.read(address))
+	value |= uint16(c.read(address+1)) << 8
+	return
+}
+
+func (c *CPU) write(address uint16, value uint8) {
+	c.cycle()
+	c.Memory.Poke(address, value)
+	if len(c.hooks.memoryAccess) > 0 {
+		c.notifyMemoryAccess(AccessWrite, address, value)
+	}
+}
+
+func (c *CPU) fetch() (value uint8) {
+	value = c.fetchAccess(AccessOperand)
+	return
+}
+
+func (c *CPU) fetchOpcode() (opcode Opcode) {
+	opcode = Opcode(c.fetchAccess(AccessOpcode))
+	return
+}
+
+func (c *CPU) fetchAccess(accessType AccessType) (value uint8) {
+	value = c.readAccess(c.Registers.PC, accessType)
+	c.Registers.PC++
+	return
+}
+
+func (c *CPU) fetch16() (value uint16) {
+	value = uint16(c.fetch())
+	value |= uint16(c.fetch()) << 8
+	return
+}
+
+func (c *CPU) push(value uint8) {
+	c.write(0x0100|uint16(c.Registers.S), value)
+	c.Registers.S--
+}
+
+func (c *CPU) push16(value uint16) {
+	c.push(uint8(value >> 8))
+	c.push(uint8(value))
+}
+
+func (c *CPU) pull() (value uint8) {
+	c.Registers.S++
+	value = c.read(0x0100 | uint16(c.Registers.S))
+	return
+}
+
+func (c *CPU) pull16() (value uint16) {
+	value = uint16(c.pull())
+	value |= uint16(c.pull()) << 8
+	return
+}
+
+func (c *CPU) readStack() {
+	c.dummyRead(0x0100 | uint16(c.Registers.S))
+}
@@ -0,0 +1,200 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type busAccess struct {
+	Cycle   Cycles
+	Write   bool
+	Address uint16
+	Value   uint8
+}
+
+type busRecorder struct {
+	cpu      *CPU
+	memory   *BasicMemory
+	accesses []busAccess
+}
+
+func newBusRecorder(cpu *CPU) *busRecorder {
+	recorder := &busRecorder{cpu: cpu, memory: NewBasicMemory()}
+	if err := cpu.Memory.AddMappings(recorder, MappingCPU); err != nil {
+		panic(err)
+	}
+	return recorder
+}
+
+func (r *busRecorder) Reset() {
+	r.accesses = nil
+}
+
+func (r *busRecorder) Peek(address uint16) (value uint8) {
+	value = r.memory.Peek(address)
+	r.accesses = append(r.accesses, busAccess{r.cpu.TotalCycles, false, address, value})
+	return
+}
+
+func (r *busRecorder) DebugPeek(address uint16) (value uint8) {
+	value = r.memory.DebugPeek(address)
+	return
+}
+
+func (r *busRecorder) Cacheable(address uint16) bool {
+	return true
+}
+
+func (r *busRecorder) Poke(address uint16, value uint8) (oldValue uint8) {
+	r.accesses = append(r.accesses, busAccess{r.cpu.TotalCycles, true, address, value})
+	return r.memory.Poke(address, value)
+}
+
+func (r *busRecorder) Mappings(mappingType MappingType) (peek, poke []Mapping) {
+	peek = []Mapping{{From: 0x0000, To: 0xFFFF}}
+	poke = []Mapping{{From: 0x0000, To: 0xFFFF}}
+	return
+}
+
+func testBus(t *testing.T, program []uint8, prepare func(cpu *CPU, memory *BasicMemory), expected []busAccess) {
+	newCPU := func() (*CPU, *busRecorder) {
+		cpu := NewCPU()
+		recorder := newBusRecorder(cpu)
+		cpu.Registers.PC = MemoryTestLocation
+		for i, value := range program {
+			recorder.memory.Poke(MemoryTestLocation+uint16(i), value)
+		}
+		if prepare != nil {
+			prepare(cpu, recorder.memory)
+		}
+		return cpu, recorder
+	}
+
+	// Ticking performs the same accesses, one per tick
+	cpu, recorder := newCPU()
+	for i := range expected {
+		completed, err := cpu.Tick()
+		assert.NoError(t, err)
+		assert.Equal(t, i == len(expected)-1, completed, "expected instruction to complete with the last access")
+		assert.Len(t, recorder.accesses, i+1, "expected single bus access per tick")
+	}
+	assert.Equal(t, expected, recorder.accesses, "unexpected bus accesses when ticking")
+
+	cpu, recorder = newCPU()
+
+	var cycles []Cycles
+	cpu.OnCycle = func(cycle Cycles) {
+		cycles = append(cycles, cycle)
+	}
+	cpu.Execute()
+
+	assert.Equal(t, expected, recorder.accesses, "unexpected bus accesses")
+	assert.Len(t, cycles, len(expected), "expected one cycle per bus access")
+}
+
+func TestBusImplicit(t *testing.T) {
+	testBus(t, []uint8{0xE8}, nil, []busAccess{
+		{1, false, 0xC000, 0xE8},
+		{2, false, 0xC001, 0x00},
+	})
+}
+
+func TestBusAbsoluteXPageCross(t *testing.T) {
+	testBus(t, []uint8{0xBD, 0xF0, 0x12}, func(cpu *CPU, memory *BasicMemory) {
+		cpu.Registers.X = 0x20
+		memory.Poke(0x1310, 0x42)
+	}, []busAccess{
+		{1, false, 0xC000, 0xBD},
+		{2, false, 0xC001, 0xF0},
+		{3, false, 0xC002, 0x12},
+		{4, false, 0x1210, 0x00},
+		{5, false, 0x1310, 0x42},
+	})
+}
+
+func TestBusAbsoluteXSamePage(t *testing.T) {
+	testBus(t, []uint8{0xBD, 0x00, 0x12}, func(cpu *CPU, memory *BasicMemory) {
+		cpu.Registers.X = 0x20
+	}, []busAccess{
+		{1, false, 0xC000, 0xBD},
+		{2, false, 0xC001, 0x00},
+		{3, false, 0xC002, 0x12},
+		{4, false, 0x1220, 0x00},
+	})
+}
+
+func TestBusStoreAbsoluteX(t *testing.T) {
+	testBus(t, []uint8{0x9D, 0x00, 0x12}, func(cpu *CPU, memory *BasicMemory) {
+		cpu.Registers.A = 0x42
+		cpu.Registers.X = 0x20
+	}, []busAccess{
+		{1, false, 0xC000, 0x9D},
+		{2, false, 0xC001, 0x00},
+		{3, false, 0xC002, 0x12},
+		{4, false, 0x1220, 0x00},
+		{5, true, 0x1220, 0x42},
+	})
+}
+
+func TestBusReadModifyWrite(t *testing.T) {
+	testBus(t, []uint8{0xEE, 0x00, 0x12}, func(cpu *CPU, memory *BasicMemory) {
+		memory.Poke(0x1200, 0x41)
+	}, []busAccess{
+		{1, false, 0xC000, 0xEE},
+		{2, false, 0xC001, 0x00},
+		{3, false, 0xC002, 0x12},
+		{4, false, 0x1200, 0x41},
+		{5, true, 0x1200, 0x41},
+		{6, true, 0x1200, 0x42},
+	})
+}
+
+func TestBusIndirectYPageCross(t *testing.T) {
+	testBus(t, []uint8{0xB1, 0x10}, func(cpu *CPU, memory *BasicMemory) {
+		cpu.Registers.Y = 0x01
+		memory.Poke16(0x0010, 0x12FF)
+	}, []busAccess{
+		{1, false, 0xC000, 0xB1},
+		{2, false, 0xC001, 0x10},
+		{3, false, 0x0010, 0xFF},
+		{4, false, 0x0011, 0x12},
+		{5, false, 0x1200, 0x00},
+		{6, false, 0x1300, 0x00},
+	})
+}
+
+func TestBusBranchPageCross(t *testing.T) {
+	testBus(t, []uint8{0xD0, 0x80}, nil, []busAccess{
+		{1, false, 0xC000, 0xD0},
+		{2, false, 0xC001, 0x80},
+		{3, false, 0xC002, 0x00},
+		{4, false, 0xC082, 0x00},
+	})
+}
+
+func TestBusJSR(t *testing.T) {
+	testBus(t, []uint8{0x20, 0x34, 0x12}, nil, []busAccess{
+		{1, false, 0xC000, 0x20},
+		{2, false, 0xC001, 0x34},
+		{3, false, 0x01FD, 0x00},
+		{4, true, 0x01FD, 0xC0},
+		{5, true, 0x01FC, 0x02},
+		{6, false, 0xC002, 0x12},
+	})
+}
+
+func TestBusInterrupt(t *testing.T) {
+	testBus(t, []uint8{0xEA}, func(cpu *CPU, memory *BasicMemory) {
+		cpu.Registers.P = FlagUnused
+		cpu.SetIRQ(true)
+		memory.Poke16(IRQVector, 0x1234)
+	}, []busAccess{
+		{1, false, 0xC000, 0xEA},
+		{2, false, 0xC000, 0xEA},
+		{3, true, 0x01FD, 0xC0},
+		{4, true, 0x01FC, 0x00},
+		{5, true, 0x01FB, 0x20},
+		{6, false, 0xFFFE, 0x34},
+		{7, false, 0xFFFF, 0x12},
+	})
+}
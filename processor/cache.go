@@ -0,0 +1,116 @@
+package processor
+
+// The decode cache keeps straight-line runs of instructions, so that opcodes and operands can be taken from the cache
+// instead of going through the memory mappings. Every bus cycle is still performed and reported to hooks as usual, so
+// results stay identical to uncached execution. Blocks never cross a page boundary and get invalidated whenever the
+// generation of their page changes, which happens on writes and bank switches.
+
+// decodedInstruction additionally caches the byte following the instruction if it is on the same page, as it gets read
+// by single-byte instructions and taken branches
+type decodedInstruction struct {
+	instruction *Instruction
+	handler     OpcodeHandler
+	mode        AddressingMode
+	address     uint16
+	size        uint16
+	generation  uint32
+	bytes       [4]uint8
+}
+
+type block struct {
+	generation   uint32
+	instructions []decodedInstruction
+}
+
+type decodeCache struct {
+	blocks  [DefaultMemorySize]*block
+	current *block
+	next    int
+}
+
+// WithDecodeCache enables decoding and caching basic blocks ahead of their execution. Only base memory and mappers
+// implementing CacheableMapper get cached, code in all other devices is executed uncached.
+func WithDecodeCache() Option {
+	return func(cpu *CPU) {
+		cpu.cache = &decodeCache{}
+	}
+}
+
+// decodeNext returns the cached instruction at the given address, which is usually the next one of the current block
+func (c *CPU) decodeNext(pc uint16) *decodedInstruction {
+	cache := c.cache
+	generation := c.Memory.generations[pc>>8]
+	if b := cache.current; b != nil && cache.next < len(b.instructions) && b.generation == generation {
+		if decoded := &b.instructions[cache.next]; decoded.address == pc {
+			cache.next++
+			return decoded
+		}
+	}
+
+	b := cache.blocks[pc]
+	if b == nil || b.generation != generation {
+		b = c.decodeBlock(b, pc, generation)
+		cache.blocks[pc] = b
+	}
+	if len(b.instructions) == 0 {
+		cache.current = nil
+		return nil
+	}
+
+	cache.current, cache.next = b, 1
+	return &b.instructions[0]
+}
+
+// decodeBlock decodes instructions until the end of the page, an instruction changing the control flow or an invalid
+// opcode is reached, reusing the given block if possible
+func (c *CPU) decodeBlock(b *block, pc uint16, generation uint32) *block {
+	if b == nil {
+		b = &block{}
+	}
+	b.generation, b.instructions = generation, b.instructions[:0]
+
+	for address := int(pc); c.Memory.cacheable(uint16(address)); {
+		instruction, ok := c.instructions.lookup(Opcode(c.Memory.DebugPeek(uint16(address))))
+		if !ok {
+			break
+		}
+		length := 1 + OperandLength(instruction.Variant.AddressingMode)
+		if address&0xFF+length > 0x100 || !c.cacheableRange(address, length) {
+			break
+		}
+
+		size := length + 1
+		if address&0xFF+size > 0x100 || !c.Memory.cacheable(uint16(address+length)) {
+			size = length
+		}
+
+		decoded := decodedInstruction{
+			instruction: instruction,
+			handler:     instruction.Handler,
+			mode:        instruction.Variant.AddressingMode,
+			address:     uint16(address),
+			size:        uint16(size),
+			generation:  generation,
+		}
+		for i := 0; i < size; i++ {
+			decoded.bytes[i] = c.Memory.DebugPeek(uint16(address + i))
+		}
+		b.instructions = append(b.instructions, decoded)
+
+		address += length
+		if instruction.endsBlock || address&0xFF == 0 {
+			break
+		}
+	}
+
+	return b
+}
+
+func (c *CPU) cacheableRange(address, length int) bool {
+	for i := 1; i < length; i++ {
+		if !c.Memory.cacheable(uint16(address + i)) {
+			return false
+		}
+	}
+	return true
+}
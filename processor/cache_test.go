@@ -0,0 +1,202 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// bankMapper switches between two banks of code at $8000 when writing to $9000
+type bankMapper struct {
+	banks      [2][]uint8
+	bank       int
+	invalidate func(from, to uint16)
+}
+
+func (m *bankMapper) Reset() {}
+
+func (m *bankMapper) Peek(address uint16) uint8 {
+	return m.banks[m.bank][address&0xFF]
+}
+
+func (m *bankMapper) DebugPeek(address uint16) uint8 {
+	return m.Peek(address)
+}
+
+func (m *bankMapper) Cacheable(address uint16) bool {
+	return true
+}
+
+func (m *bankMapper) Poke(address uint16, value uint8) uint8 {
+	m.bank = int(value & 0x01)
+	if m.invalidate != nil {
+		m.invalidate(0x8000, 0x80FF)
+	}
+	return 0
+}
+
+func (m *bankMapper) SetInvalidate(mappingType MappingType, invalidate func(from, to uint16)) {
+	m.invalidate = invalidate
+}
+
+func (m *bankMapper) Mappings(mappingType MappingType) (peek, poke []Mapping) {
+	return []Mapping{{From: 0x8000, To: 0x80FF}}, []Mapping{{From: 0x9000, To: 0x9000}}
+}
+
+// readCounter counts reads like a status register being acknowledged by them, so its contents must not be cached
+type readCounter struct {
+	data  []uint8
+	reads int
+}
+
+func (r *readCounter) Reset() {}
+
+func (r *readCounter) Peek(address uint16) uint8 {
+	r.reads++
+	return r.data[address&0xFF]
+}
+
+func (r *readCounter) DebugPeek(address uint16) uint8 {
+	return r.data[address&0xFF]
+}
+
+func (r *readCounter) Poke(address uint16, value uint8) uint8 {
+	return 0
+}
+
+func (r *readCounter) Mappings(mappingType MappingType) (peek, poke []Mapping) {
+	return []Mapping{{From: 0x8000, To: 0x80FF}}, nil
+}
+
+func TestDecodeCacheIdentical(t *testing.T) {
+	run := func(options ...Option) ([]MemoryAccess, *CPU) {
+		cpu := NewCPU(options...)
+		cpu.Registers.PC = MemoryTestLocation
+		for i, value := range benchmarkProgram {
+			cpu.Memory.Poke(MemoryTestLocation+uint16(i), value)
+		}
+		cpu.Memory.Poke16(0x0020, 0x0300)
+
+		var accesses []MemoryAccess
+		cpu.OnMemoryAccess(func(access MemoryAccess) {
+			accesses = append(accesses, access)
+		})
+		for i := 0; i < 5000; i++ {
+			assert.NoError(t, cpu.Execute())
+		}
+		return accesses, cpu
+	}
+
+	expectedAccesses, expected := run()
+	accesses, cpu := run(WithDecodeCache())
+	assert.Equal(t, expectedAccesses, accesses, "expected identical bus accesses")
+	assert.Equal(t, expected.Registers, cpu.Registers, "expected identical registers")
+	assert.Equal(t, expected.TotalCycles, cpu.TotalCycles, "expected identical cycle count")
+	assert.Equal(t, expected.Memory.Dump(), cpu.Memory.Dump(), "expected identical memory")
+}
+
+func TestDecodeCacheSelfModifying(t *testing.T) {
+	cpu := NewCPU(WithDecodeCache())
+	cpu.Registers.PC = 0x0300
+	program := []uint8{
+		0xA9, 0xE8, // LDA #$E8
+		0x8D, 0x05, 0x03, // STA $0305
+		0xEA, // NOP, replaced by INX
+	}
+	for i, value := range program {
+		cpu.Memory.Poke(0x0300+uint16(i), value)
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, cpu.Execute())
+	}
+	assert.Equal(t, uint8(0x01), cpu.Registers.X, "expected modified instruction to be executed")
+}
+
+func TestDecodeCacheCycleHook(t *testing.T) {
+	cpu := NewCPU(WithDecodeCache())
+	cpu.Registers.PC = 0x0300
+	cpu.Memory.Poke(0x0300, 0xEA) // NOP, replaced by INX while being fetched
+
+	cpu.OnCycle = func(cycle Cycles) {
+		if cycle == 1 {
+			cpu.Memory.Poke(0x0300, 0xE8)
+		}
+	}
+	assert.NoError(t, cpu.Execute())
+	assert.Equal(t, uint8(0x01), cpu.Registers.X, "expected fetched instruction to be executed")
+}
+
+func TestDecodeCacheBlocks(t *testing.T) {
+	cpu := NewCPU(WithDecodeCache())
+	program := []uint8{
+		0xE8,       // INX
+		0xD0, 0x00, // BNE *+2
+		0xC8,             // INY
+		0x20, 0x00, 0x04, // JSR $0400
+		0xEA, // NOP
+	}
+	for i, value := range program {
+		cpu.Memory.Poke(0x0300+uint16(i), value)
+	}
+
+	var mnemonics []string
+	for _, decoded := range cpu.decodeBlock(nil, 0x0300, 0).instructions {
+		mnemonics = append(mnemonics, decoded.instruction.Mnemonic)
+	}
+	assert.Equal(t, []string{"INX", "BNE"}, mnemonics, "expected branch to end the block")
+
+	mnemonics = nil
+	for _, decoded := range cpu.decodeBlock(nil, 0x0303, 0).instructions {
+		mnemonics = append(mnemonics, decoded.instruction.Mnemonic)
+	}
+	assert.Equal(t, []string{"INY", "JSR"}, mnemonics, "expected jump to end the block")
+}
+
+func TestDecodeCacheBankSwitch(t *testing.T) {
+	mapper := &bankMapper{}
+	mapper.banks[0] = append([]uint8{
+		0xE8,       // INX
+		0xA9, 0x01, // LDA #$01
+		0x8D, 0x00, 0x90, // STA $9000
+		0x4C, 0x00, 0x80, // JMP $8000
+	}, make([]uint8, 0xF7)...)
+	mapper.banks[1] = append([]uint8{
+		0xC8, 0xC8, 0xC8, 0xC8, 0xC8, 0xC8, 0xC8, // INY
+		0x4C, 0x00, 0x80, // JMP $8000
+	}, make([]uint8, 0xF6)...)
+
+	cpu := NewCPU(WithDecodeCache())
+	cpu.Registers.PC = 0x8000
+	assert.NoError(t, cpu.Memory.AddMappings(mapper, MappingCPU))
+
+	// Switching banks does not write to the switched range, so the mapper has to invalidate it by itself
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, cpu.Execute())
+	}
+	assert.Equal(t, uint8(0x01), cpu.Registers.X, "unexpected x register")
+	assert.Equal(t, uint8(0x01), cpu.Registers.Y, "expected switched in bank to be executed")
+	assert.Equal(t, uint16(0x8007), cpu.Registers.PC, "expected switched out bank not to be executed")
+}
+
+func TestDecodeCacheReadSideEffects(t *testing.T) {
+	run := func(options ...Option) (*readCounter, *CPU) {
+		device := &readCounter{data: make([]uint8, 0x100)}
+		copy(device.data, []uint8{
+			0xE8,             // INX
+			0xEA,             // NOP
+			0x4C, 0x00, 0x80, // JMP $8000
+		})
+		cpu := NewCPU(options...)
+		cpu.Registers.PC = 0x8000
+		assert.NoError(t, cpu.Memory.AddMappings(device, MappingCPU))
+		for i := 0; i < 30; i++ {
+			assert.NoError(t, cpu.Execute())
+		}
+		return device, cpu
+	}
+
+	expectedDevice, expected := run()
+	device, cpu := run(WithDecodeCache())
+	assert.Equal(t, expectedDevice.reads, device.reads, "expected every read to reach devices not being cacheable")
+	assert.Equal(t, expected.Registers, cpu.Registers, "expected identical registers")
+}
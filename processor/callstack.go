@@ -0,0 +1,58 @@
+package processor
+
+// maxCallDepth limits the shadow call stack, as code which never returns from its subroutines would grow it forever
+const maxCallDepth = 256
+
+// Frame is an entry of the shadow call stack, which gets pushed by JSR, BRK, interrupts and reset.
+type Frame struct {
+	// Routine is the address of the subroutine or interrupt handler which got entered
+	Routine uint16
+	// CallSite is the address of the JSR or BRK instruction, or the address of the instruction which got interrupted
+	CallSite uint16
+	// Vector is the interrupt vector used for entering the routine, which is zero for subroutine calls
+	Vector uint16
+	// Stack is the stack pointer before the return address got pushed
+	Stack uint8
+	// Cycle is the total amount of cycles at which the routine got entered
+	Cycle Cycles
+}
+
+// CallStack returns a copy of the shadow call stack starting with the outermost frame. Frames get removed once the
+// stack pointer rises above the return address of a frame, so that RTS and RTI as well as code discarding return
+// addresses by pulling them or resetting the stack pointer are tracked as well.
+func (c *CPU) CallStack() []Frame {
+	frames := make([]Frame, len(c.calls))
+	copy(frames, c.calls)
+	return frames
+}
+
+// AppendCallStack appends the shadow call stack to the given frames, which allows reusing them for every instruction.
+func (c *CPU) AppendCallStack(frames []Frame) []Frame {
+	return append(frames, c.calls...)
+}
+
+func (c *CPU) pushCall(routine uint16, callSite uint16, vector uint16, stack uint8) {
+	if len(c.calls) >= maxCallDepth {
+		c.calls = append(c.calls[:0], c.calls[1:]...)
+	}
+	c.calls = append(c.calls, Frame{Routine: routine, CallSite: callSite, Vector: vector, Stack: stack, Cycle: c.TotalCycles})
+}
+
+// unwindCalls removes all frames whose return address has been pulled from the stack. The frame of the reset handler
+// is never removed, as there is nothing to return to.
+func (c *CPU) unwindCalls() {
+	for len(c.calls) > 0 {
+		frame := c.calls[len(c.calls)-1]
+		if frame.Vector == ResetVector || stackDepth(c.Registers.S, frame.Stack) < 0 {
+			return
+		}
+		c.calls = c.calls[:len(c.calls)-1]
+	}
+}
+
+// stackDepth returns how many bytes the stack pointer is above the given depth, which is negative if more has been
+// pushed since. Stack pointers are compared relative to each other instead of by their absolute values, as code might
+// have wrapped the stack pointer around between $00 and $FF. This limits the distance to 128 bytes in either direction.
+func stackDepth(s uint8, depth uint8) int8 {
+	return int8(s - depth)
+}
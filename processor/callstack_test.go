@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCallStack(t *testing.T) {
+	cpu := newRunTestCPU()
+	_, _, err := cpu.RunUntilPC(0xC020)
+	assert.NoError(t, err)
+
+	stack := cpu.CallStack()
+	if assert.Len(t, stack, 2, "expected nested subroutine calls") {
+		assert.Equal(t, Frame{Routine: 0xC010, CallSite: 0xC001, Stack: 0xFD, Cycle: 8}, stack[0])
+		assert.Equal(t, Frame{Routine: 0xC020, CallSite: 0xC011, Stack: 0xFB, Cycle: 16}, stack[1])
+	}
+
+	cpu.TriggerNMI()
+	assert.NoError(t, cpu.Execute())
+	stack = cpu.CallStack()
+	if assert.Len(t, stack, 3, "expected interrupt to push frame") {
+		assert.Equal(t, Frame{Routine: 0x1000, CallSite: 0xC020, Vector: NMIVector, Stack: 0xF9, Cycle: 23}, stack[2])
+	}
+
+	_, _, err = cpu.RunUntilPC(0xC004)
+	assert.NoError(t, err)
+	assert.Empty(t, cpu.CallStack(), "expected all frames to be removed after returning")
+}
+
+func TestCallStackReset(t *testing.T) {
+	cpu := newRunTestCPU()
+	cpu.Memory.Poke16(ResetVector, 0xC000)
+	cpu.Reset()
+
+	stack := cpu.CallStack()
+	if assert.Len(t, stack, 1, "expected reset handler frame") {
+		assert.Equal(t, uint16(0xC000), stack[0].Routine, "unexpected routine")
+		assert.Equal(t, uint16(ResetVector), stack[0].Vector, "unexpected vector")
+	}
+
+	// Resetting the stack pointer discards all frames except for the reset handler
+	_, _, err := cpu.RunUntilPC(0xC020)
+	assert.NoError(t, err)
+	cpu.Memory.Poke(0xC020, 0x9A) // TXS
+	cpu.Registers.X = 0xFF
+	assert.NoError(t, cpu.Execute())
+	assert.Len(t, cpu.CallStack(), 1, "expected discarded frames to be removed")
+}
+
+func TestCallStackRTSTrick(t *testing.T) {
+	cpu := newRunTestCPU()
+	_, _, err := cpu.RunUntilPC(0xC020)
+	assert.NoError(t, err)
+
+	// Jumping by pushing an address and executing RTS must not be treated as returning from the subroutine
+	program := []uint8{0xA9, 0xC0, 0x48, 0xA9, 0x2F, 0x48, 0x60} // LDA #$C0; PHA; LDA #$2F; PHA; RTS
+	for i, value := range program {
+		cpu.Memory.Poke(0xC020+uint16(i), value)
+	}
+	_, _, err = cpu.RunUntilPC(0xC030)
+	assert.NoError(t, err)
+	assert.Len(t, cpu.CallStack(), 2, "expected frames to be kept")
+}
@@ -1,13 +1,28 @@
 package processor
 
-import "fmt"
-
 type Cycles uint64
+type CycleHandler func(cycle Cycles)
 
 type Register int
 type Status uint8
+type Variant int
+type Option func(cpu *CPU)
+
+// DefaultMagicConstant is ORed into the accumulator by the unstable XAA and LAX immediate instructions. The actual
+// value depends on the individual chip and temperature, but 0xEE matches the behavior observed on most consoles.
+const DefaultMagicConstant = 0xEE
 
-const ResetVector = 0xFFFE
+const (
+	NMIVector   = 0xFFFA
+	ResetVector = 0xFFFC
+	IRQVector   = 0xFFFE
+)
+
+const (
+	Ricoh2A03 Variant = iota
+	NMOS6502
+	WDC65C02
+)
 
 const (
 	FlagCarry Status = 1 << iota
@@ -38,18 +53,50 @@ type CPUState struct {
 }
 
 type CPU struct {
-	Debug       bool
-	Halted      bool
-	TotalCycles Cycles
-	Registers   Registers
-	Memory      *MappedMemory
+	Debug         bool
+	Halted        bool
+	TotalCycles   Cycles
+	Registers     Registers
+	Memory        *MappedMemory
+	OnCycle       CycleHandler
+	MagicConstant uint8
+	JamPolicy     JamPolicy
+
+	variant    Variant
+	waiting    bool
+	nmiLine    bool
+	nmiPending bool
+	irqLine    bool
+	calls      []Frame
+	cache      *decodeCache
+	decoded    *decodedInstruction
+	ticker     *ticker
+	ticking    bool
+	ticked     bool
+
+	// Interrupts are polled during the second to last cycle of each instruction, so only line changes up to pollCycle
+	// are seen before the next instruction. Changes from outside of Execute are treated as if they happened in time.
+	executing    bool
+	nmiCycle     Cycles
+	irqCycle     Cycles
+	pollCycle    Cycles
+	pollLatency  Cycles
+	pollDelayed  bool
+	pollDisabled bool
 
+	hooks              hooks
 	previousState      CPUState
 	addressingHandlers AddressingHandlerTable
 	instructions       InstructionTable
+
+	// sparse is set if the instruction table lacks some opcodes, which then have to be checked before fetching them
+	sparse       bool
+	officialOnly bool
+	// tableErr keeps the CPU halted if building the instruction table failed
+	tableErr error
 }
 
-func NewCPU() (cpu *CPU) {
+func NewCPU(options ...Option) (cpu *CPU) {
 	cpu = &CPU{
 		Halted:      false,
 		TotalCycles: 0,
@@ -58,34 +105,264 @@ func NewCPU() (cpu *CPU) {
 			P:  FlagInterruptDisable | FlagBreak | FlagUnused,
 			S:  0xFD,
 		},
-		Memory: NewMappedMemory(NewBasicMemory()),
+		Memory:        NewMappedMemory(NewBasicMemory()),
+		MagicConstant: DefaultMagicConstant,
+		pollLatency:   1,
+	}
+
+	for _, option := range options {
+		option(cpu)
 	}
 
 	cpu.registerAddressingHandlers()
-	cpu.registerInstructions()
+	if err := cpu.registerInstructions(); err != nil {
+		cpu.Halted = true
+		cpu.tableErr = err
+	}
 
 	return
 }
 
-func (c *CPU) Execute() {
+// Execute runs a single instruction or interrupt sequence. It fails with ErrInstructionInProgress if Tick has been
+// used to start an instruction which is not completed yet.
+func (c *CPU) Execute() error {
+	if c.ticking {
+		return ErrInstructionInProgress
+	}
+	c.ticked = false
+	return c.execute()
+}
+
+func (c *CPU) execute() error {
 	if c.Halted {
-		panic("cpu is halted")
+		if c.tableErr != nil {
+			return c.tableErr
+		}
+		return ErrCPUJammed
 	}
 	if c.Debug {
 		c.collectState()
 	}
 
-	opcode := Opcode(c.Memory.Peek(c.Registers.PC))
-	c.Registers.PC++
+	c.executing = true
+	defer c.endExecute()
+
+	// A waiting 65C02 idles until any interrupt line gets asserted, even if interrupts are disabled
+	if c.waiting {
+		if !c.nmiPending && !c.irqLine {
+			c.dummyRead(c.Registers.PC)
+			c.pollLatency = 0
+			return nil
+		}
+		c.waiting = false
+	}
+
+	// Service pending interrupts before fetching the next opcode, NMI always takes precedence over IRQ. Interrupt
+	// sequences do not poll, so that the first instruction of the handler always gets executed.
+	pc, startCycles := c.Registers.PC, c.TotalCycles
+	if c.pollNMI() {
+		c.nmiPending = false
+		c.interrupt(NMIVector)
+		c.pollLatency = c.TotalCycles - startCycles
+		return nil
+	}
+	if c.pollIRQ() {
+		c.interrupt(IRQVector)
+		c.pollLatency = c.TotalCycles - startCycles
+		return nil
+	}
+	c.pollDelayed = false
+
+	// Invalid opcodes are detected before fetching them, so that the error can be inspected and execution resumed
+	// without any cycles having passed. Only tables without undocumented opcodes are sparse, decoded instructions are
+	// always valid.
+	if c.cache != nil {
+		c.decoded = c.decodeNext(pc)
+	}
+	if c.sparse && c.decoded == nil {
+		if opcode := Opcode(c.Memory.DebugPeek(pc)); c.instructions[opcode].Handler == nil {
+			return &InvalidOpcodeError{PC: pc, Opcode: opcode}
+		}
+	}
+	// Decoded instructions get dispatched from the cache, unless a cycle hook changed their page during the fetch
+	opcode := c.fetchOpcode()
+	var instruction *Instruction
+	var handler OpcodeHandler
+	var mode AddressingMode
+	if decoded := c.decoded; decoded != nil && c.Memory.generations[pc>>8] == decoded.generation {
+		instruction, handler, mode = decoded.instruction, decoded.handler, decoded.mode
+	} else {
+		instruction = &c.instructions[opcode]
+		handler, mode = instruction.Handler, instruction.Variant.AddressingMode
+	}
+	if len(c.hooks.beforeInstruction) > 0 {
+		c.notifyBeforeInstruction(pc, opcode, instruction)
+	}
 
-	instruction, ok := c.instructions[opcode]
-	if !ok {
-		panic(fmt.Errorf("invalid opcode: 0x%02X", opcode))
+	// Single-byte instructions always read the following byte during their second cycle
+	if (mode == Implicit || mode == Accumulator) && instruction.Variant.StaticCycles > 1 {
+		c.dummyRead(c.Registers.PC)
 	}
 
-	cycles := instruction.Variant.StaticCycles
-	cycles += instruction.Handler(instruction.Variant.AddressingMode)
-	c.TotalCycles += cycles
+	stack := c.Registers.S
+	handler(mode)
+	if stackDepth(c.Registers.S, stack) > 0 && len(c.calls) > 0 {
+		c.unwindCalls()
+	}
+	if len(c.hooks.afterInstruction) > 0 {
+		c.notifyAfterInstruction(c.TotalCycles - startCycles)
+	}
+
+	if c.Halted {
+		return ErrCPUJammed
+	}
+
+	return nil
+}
+
+func (c *CPU) Step() (cycles Cycles, err error) {
+	previousCycles := c.TotalCycles
+	err = c.Execute()
+	cycles = c.TotalCycles - previousCycles
+	return
+}
+
+func (c *CPU) Reset() {
+	// Reset behaves like an interrupt with suppressed stack writes, so the stack is only read instead
+	c.dummyRead(c.Registers.PC)
+	c.dummyRead(c.Registers.PC)
+	for i := 0; i < 3; i++ {
+		c.dummyRead(0x0100 | uint16(c.Registers.S))
+		c.Registers.S--
+	}
+
+	c.Registers.P |= FlagInterruptDisable
+	pc := c.Registers.PC
+	c.Registers.PC = c.read16(ResetVector)
+
+	if c.variant == WDC65C02 {
+		c.Registers.P &^= FlagDecimal
+	}
+
+	c.Halted = c.tableErr != nil
+	c.waiting = false
+	c.nmiPending = false
+	c.pollDelayed = false
+	c.calls = c.calls[:0]
+	c.pushCall(c.Registers.PC, pc, ResetVector, c.Registers.S)
+	c.notifyInterrupt(ResetVector)
+}
+
+func WithVariant(variant Variant) Option {
+	return func(cpu *CPU) {
+		cpu.variant = variant
+	}
+}
+
+// WithOfficialOpcodesOnly leaves the undocumented opcodes of the NMOS 6502 and the Ricoh 2A03 unregistered, so that
+// executing them fails with an InvalidOpcodeError. This has no effect on the 65C02, which has no undocumented opcodes.
+func WithOfficialOpcodesOnly() Option {
+	return func(cpu *CPU) {
+		cpu.officialOnly = true
+	}
+}
+
+func (c *CPU) Variant() Variant {
+	return c.variant
+}
+
+func (c *CPU) SetNMI(active bool) {
+	// NMI is edge-triggered and only gets latched on a transition from inactive to active
+	if active && !c.nmiLine {
+		c.nmiPending = true
+		c.nmiCycle = c.lineCycle()
+	}
+	c.nmiLine = active
+}
+
+func (c *CPU) TriggerNMI() {
+	c.SetNMI(true)
+	c.SetNMI(false)
+}
+
+func (c *CPU) SetIRQ(active bool) {
+	// IRQ is level-triggered and gets serviced as long as the line is held active and interrupts are enabled
+	if active && !c.irqLine {
+		c.irqCycle = c.lineCycle()
+	}
+	c.irqLine = active
+}
+
+// lineCycle returns the cycle during which an interrupt line changed. Changes made after a tick happen ahead of the
+// next cycle, just like changes made by the cycle handler during that cycle.
+func (c *CPU) lineCycle() Cycles {
+	if c.ticked {
+		return c.TotalCycles + 1
+	}
+	if c.executing {
+		return c.TotalCycles
+	}
+	return c.pollCycle
+}
+
+// endExecute determines the cycle during which interrupts were polled, which is the second to last cycle unless the
+// instruction changed the poll latency
+func (c *CPU) endExecute() {
+	if c.pollLatency == 0 {
+		c.pollCycle = c.TotalCycles
+	} else if c.TotalCycles >= c.pollLatency {
+		c.pollCycle = c.TotalCycles - c.pollLatency
+	}
+	c.pollLatency = 1
+	c.executing = false
+	c.decoded = nil
+}
+
+// delayInterruptDisable lets the next interrupt poll see the I flag as it was before the current instruction, because
+// CLI, SEI and PLP only change it during their last cycle
+func (c *CPU) delayInterruptDisable(previous Status) {
+	c.pollDelayed = true
+	c.pollDisabled = previous&FlagInterruptDisable != 0
+}
+
+func (c *CPU) pollNMI() bool {
+	return c.nmiPending && c.nmiCycle <= c.pollCycle
+}
+
+func (c *CPU) pollIRQ() bool {
+	disabled := c.Registers.P&FlagInterruptDisable != 0
+	if c.pollDelayed {
+		disabled = c.pollDisabled
+	}
+	return c.irqLine && !disabled && c.irqCycle <= c.pollCycle
+}
+
+func (c *CPU) interrupt(vector uint16) {
+	c.dummyRead(c.Registers.PC)
+	c.dummyRead(c.Registers.PC)
+	pc, stack := c.Registers.PC, c.Registers.S
+
+	// Hardware interrupts push the status register with the break flag cleared
+	c.push16(c.Registers.PC)
+	vector = c.hijackVector(vector)
+	c.push(uint8((c.Registers.P | FlagUnused) &^ FlagBreak))
+	c.Registers.P |= FlagInterruptDisable
+	if c.variant == WDC65C02 {
+		c.Registers.P &^= FlagDecimal
+	}
+	c.Registers.PC = c.read16(vector)
+	c.pushCall(c.Registers.PC, pc, vector, stack)
+	c.notifyInterrupt(vector)
+}
+
+// hijackVector switches an interrupt sequence to the NMI vector if an NMI got detected up to the cycle pushing the low
+// byte of the return address. The status register still gets pushed as it would have been for the original interrupt.
+func (c *CPU) hijackVector(vector uint16) uint16 {
+	if c.nmiPending && c.nmiCycle <= c.TotalCycles {
+		c.nmiPending = false
+		return NMIVector
+	}
+	return vector
 }
 
 func (c *CPU) Push(value uint8) {
@@ -134,3 +411,16 @@ func (c *CPU) toSigned(value uint8) int8 {
 		return int8(value & 0x7F)
 	}
 }
+
+func VariantName(variant Variant) string {
+	switch variant {
+	case Ricoh2A03:
+		return "Ricoh2A03"
+	case NMOS6502:
+		return "NMOS6502"
+	case WDC65C02:
+		return "WDC65C02"
+	default:
+		return "<unknown>"
+	}
+}
@@ -0,0 +1,44 @@
+package processor
+
+import "testing"
+
+// benchmarkProgram is a small loop which mixes common addressing modes, read-modify-write instructions and branches:
+//
+//	loop: LDA $10,X / ADC $0200,Y / STA ($20),Y / INC $30 / ROL A / DEX / BNE loop / INY / JMP loop
+var benchmarkProgram = []uint8{
+	0xB5, 0x10,
+	0x79, 0x00, 0x02,
+	0x91, 0x20,
+	0xE6, 0x30,
+	0x2A,
+	0xCA,
+	0xD0, 0xF3,
+	0xC8,
+	0x4C, 0x00, 0xC0,
+}
+
+func BenchmarkExecute(b *testing.B) {
+	benchmarkExecute(b)
+}
+
+func BenchmarkExecuteDecodeCache(b *testing.B) {
+	benchmarkExecute(b, WithDecodeCache())
+}
+
+func benchmarkExecute(b *testing.B, options ...Option) {
+	cpu := NewCPU(options...)
+	cpu.Registers.PC = MemoryTestLocation
+	for i, value := range benchmarkProgram {
+		cpu.Memory.Poke(MemoryTestLocation+uint16(i), value)
+	}
+	cpu.Memory.Poke16(0x0020, 0x0300)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	// Every iteration executes exactly one instruction, so ns/op equals the time spent per instruction
+	for i := 0; i < b.N; i++ {
+		cpu.Execute()
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "instr/s")
+}
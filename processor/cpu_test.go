@@ -0,0 +1,171 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newInterruptTestCPU(options ...Option) *CPU {
+	cpu := NewCPU(options...)
+	cpu.Registers.PC = MemoryTestLocation
+	cpu.Registers.P = FlagUnused
+	cpu.Memory.Poke(MemoryTestLocation, 0xEA)
+	cpu.Memory.Poke16(NMIVector, 0x1000)
+	cpu.Memory.Poke16(ResetVector, 0x2000)
+	cpu.Memory.Poke16(IRQVector, 0x3000)
+	return cpu
+}
+
+func TestReset(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.Halted = true
+	cpu.Reset()
+
+	assert.Equal(t, uint16(0x2000), cpu.Registers.PC, "unexpected program counter")
+	assert.Equal(t, uint8(0xFA), cpu.Registers.S, "unexpected stack pointer")
+	assert.Equal(t, FlagInterruptDisable, cpu.Registers.P&FlagInterruptDisable, "expected interrupts to be disabled")
+	assert.Equal(t, Cycles(7), cpu.TotalCycles, "unexpected cycle count")
+	assert.False(t, cpu.Halted, "expected cpu to be running")
+	assert.Equal(t, uint8(0x00), cpu.Memory.Peek(0x01FD), "expected no stack writes")
+}
+
+func TestNMI(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.Registers.P |= FlagInterruptDisable
+	cpu.SetNMI(true)
+	cpu.Execute()
+
+	assert.Equal(t, uint16(0x1000), cpu.Registers.PC, "unexpected program counter")
+	assert.Equal(t, Cycles(7), cpu.TotalCycles, "unexpected cycle count")
+	assert.Equal(t, uint8(0xFA), cpu.Registers.S, "unexpected stack pointer")
+	assert.Equal(t, MemoryTestLocation, cpu.Memory.Peek16(0x01FC), "unexpected return address")
+	assert.Equal(t, uint8(FlagUnused|FlagInterruptDisable), cpu.Memory.Peek(0x01FB), "unexpected pushed status")
+
+	// NMI must not retrigger while the line stays active
+	cpu.Memory.Poke(0x1000, 0xEA)
+	cpu.Execute()
+	assert.Equal(t, uint16(0x1001), cpu.Registers.PC, "expected nmi to be edge-triggered")
+}
+
+func TestNMIEdge(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.SetNMI(true)
+	cpu.SetNMI(false)
+	cpu.SetNMI(true)
+	cpu.Execute()
+	assert.Equal(t, uint16(0x1000), cpu.Registers.PC, "expected nmi to be serviced")
+
+	cpu.Memory.Poke(0x1000, 0xEA)
+	cpu.Execute()
+	assert.Equal(t, uint16(0x1001), cpu.Registers.PC, "expected nmi to be serviced only once")
+}
+
+func TestIRQ(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.SetIRQ(true)
+	cpu.Execute()
+
+	assert.Equal(t, uint16(0x3000), cpu.Registers.PC, "unexpected program counter")
+	assert.Equal(t, Cycles(7), cpu.TotalCycles, "unexpected cycle count")
+	assert.Equal(t, uint8(FlagUnused), cpu.Memory.Peek(0x01FB), "unexpected pushed status")
+	assert.Equal(t, FlagInterruptDisable, cpu.Registers.P&FlagInterruptDisable, "expected interrupts to be disabled")
+}
+
+func TestIRQDisabled(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.Registers.P |= FlagInterruptDisable
+	cpu.SetIRQ(true)
+	cpu.Execute()
+
+	assert.Equal(t, MemoryTestLocation+1, cpu.Registers.PC, "expected irq to be ignored")
+}
+
+func TestNMIPrecedence(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.SetIRQ(true)
+	cpu.TriggerNMI()
+	cpu.Execute()
+
+	assert.Equal(t, uint16(0x1000), cpu.Registers.PC, "expected nmi to take precedence")
+}
+
+func TestJamPolicy(t *testing.T) {
+	testJamPolicy := func(policy JamPolicy, expectedErr error, expectedPC uint16, isHalted bool) {
+		cpu := newInterruptTestCPU()
+		cpu.JamPolicy = policy
+		cpu.Memory.Poke(MemoryTestLocation, 0x02)
+
+		assert.Equal(t, expectedErr, cpu.Execute(), "unexpected error")
+		assert.Equal(t, expectedPC, cpu.Registers.PC, "unexpected program counter")
+		assert.Equal(t, isHalted, cpu.Halted, "unexpected halt state")
+	}
+
+	testJamPolicy(JamHalt, ErrCPUJammed, MemoryTestLocation+1, true)
+	testJamPolicy(JamNOP, nil, MemoryTestLocation+1, false)
+	testJamPolicy(JamReset, nil, 0x2000, false)
+}
+
+func TestExecuteWhileHalted(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.Halted = true
+
+	assert.Equal(t, ErrCPUJammed, cpu.Execute(), "expected jammed cpu to return error")
+	assert.Equal(t, Cycles(0), cpu.TotalCycles, "expected jammed cpu to not consume cycles")
+}
+
+func TestInvalidOpcode(t *testing.T) {
+	for _, options := range [][]Option{{WithOfficialOpcodesOnly()}, {WithOfficialOpcodesOnly(), WithDecodeCache()}} {
+		cpu := newInterruptTestCPU(options...)
+		cpu.Memory.Poke(MemoryTestLocation, 0xEA)   // NOP
+		cpu.Memory.Poke(MemoryTestLocation+1, 0xA7) // LAX $00
+
+		var accesses int
+		cpu.OnMemoryAccess(func(access MemoryAccess) {
+			accesses++
+		})
+		assert.NoError(t, cpu.Execute(), "expected official opcode to be executed")
+
+		// Invalid opcodes must not have any effects, so that execution can be resumed after fixing them
+		cycles, accesses := cpu.TotalCycles, 0
+		err := cpu.Execute()
+		if assert.IsType(t, &InvalidOpcodeError{}, err, "expected invalid opcode error") {
+			assert.Equal(t, MemoryTestLocation+1, err.(*InvalidOpcodeError).PC, "unexpected program counter in error")
+			assert.Equal(t, Opcode(0xA7), err.(*InvalidOpcodeError).Opcode, "unexpected opcode in error")
+		}
+		assert.Equal(t, MemoryTestLocation+1, cpu.Registers.PC, "expected program counter to be unchanged")
+		assert.Equal(t, cycles, cpu.TotalCycles, "expected invalid opcode not to consume cycles")
+		assert.Equal(t, 0, accesses, "expected invalid opcode not to be fetched")
+	}
+
+	_, ok := NewCPU(WithVariant(WDC65C02), WithOfficialOpcodesOnly()).Lookup(0xA7)
+	assert.True(t, ok, "expected 65C02 opcodes to be unaffected")
+}
+
+func TestInstructionTables(t *testing.T) {
+	for _, variant := range []Variant{Ricoh2A03, NMOS6502, WDC65C02} {
+		for _, options := range [][]Option{nil, {WithOfficialOpcodesOnly()}} {
+			cpu := NewCPU(append(options, WithVariant(variant))...)
+			assert.NoError(t, cpu.tableErr, "unexpected error building the %v table", VariantName(variant))
+		}
+	}
+
+	// Duplicate registrations are reported instead of replacing the first registration
+	cpu := NewCPU()
+	r := &instructionRegistry{table: &cpu.instructions}
+	r.registerVariants("NOP", cpu.opNOP, InstructionVariant{0xEA, Implicit, 2}, InstructionVariant{0xE8, Implicit, 2})
+	assert.EqualError(t, r.err, "duplicate opcode registration: 0xEA")
+	assert.Equal(t, "INX", cpu.instructions[0xE8].Mnemonic, "expected first registration to be kept")
+
+	cpu.Halted, cpu.tableErr = true, r.err
+	assert.Equal(t, r.err, cpu.Execute(), "expected table error to be returned")
+	cpu.Reset()
+	assert.True(t, cpu.Halted, "expected broken table to keep the cpu halted")
+}
+
+func TestStep(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cycles, err := cpu.Step()
+
+	assert.NoError(t, err)
+	assert.Equal(t, Cycles(2), cycles, "unexpected cycle count")
+}
@@ -0,0 +1,29 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+)
+
+type JamPolicy int
+
+const (
+	// JamHalt halts the CPU until the next reset, which matches the behavior of real hardware
+	JamHalt JamPolicy = iota
+	// JamNOP treats jamming opcodes as single-byte NOPs and continues execution
+	JamNOP
+	// JamReset resets the CPU as soon as a jamming opcode gets executed
+	JamReset
+)
+
+var ErrCPUJammed = errors.New("cpu is jammed")
+var ErrInstructionInProgress = errors.New("instruction in progress, use Tick to complete it")
+
+type InvalidOpcodeError struct {
+	PC     uint16
+	Opcode Opcode
+}
+
+func (e *InvalidOpcodeError) Error() string {
+	return fmt.Sprintf("invalid opcode 0x%02X at 0x%04X", e.Opcode, e.PC)
+}
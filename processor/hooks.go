@@ -0,0 +1,160 @@
+package processor
+
+type AccessType int
+type HookID int
+
+type BeforeInstructionHook func(pc uint16, opcode Opcode, instruction *Instruction)
+type AfterInstructionHook func(registers Registers, cycles Cycles)
+type MemoryAccessHook func(access MemoryAccess)
+type InterruptHook func(vector uint16)
+
+const (
+	AccessOpcode AccessType = iota
+	AccessOperand
+	AccessRead
+	AccessWrite
+	AccessDummyRead
+)
+
+type MemoryAccess struct {
+	Type    AccessType
+	Address uint16
+	Value   uint8
+	Cycle   Cycles
+}
+
+type hooks struct {
+	nextID            HookID
+	beforeInstruction []beforeInstructionEntry
+	afterInstruction  []afterInstructionEntry
+	memoryAccess      []memoryAccessEntry
+	interrupt         []interruptEntry
+}
+
+type beforeInstructionEntry struct {
+	id   HookID
+	hook BeforeInstructionHook
+}
+
+type afterInstructionEntry struct {
+	id   HookID
+	hook AfterInstructionHook
+}
+
+type memoryAccessEntry struct {
+	id   HookID
+	hook MemoryAccessHook
+}
+
+type interruptEntry struct {
+	id   HookID
+	hook InterruptHook
+}
+
+// OnBeforeInstruction registers a hook which gets called after an opcode has been fetched and decoded, but before the
+// instruction itself gets executed. The program counter passed to the hook points at the opcode.
+func (c *CPU) OnBeforeInstruction(hook BeforeInstructionHook) HookID {
+	id := c.hooks.allocateID()
+	c.hooks.beforeInstruction = append(c.hooks.beforeInstruction, beforeInstructionEntry{id, hook})
+	return id
+}
+
+// OnAfterInstruction registers a hook which gets called with the resulting registers and the amount of cycles spent
+// after every executed instruction. Interrupt sequences are not reported as instructions.
+func (c *CPU) OnAfterInstruction(hook AfterInstructionHook) HookID {
+	id := c.hooks.allocateID()
+	c.hooks.afterInstruction = append(c.hooks.afterInstruction, afterInstructionEntry{id, hook})
+	return id
+}
+
+// OnMemoryAccess registers a hook which gets called for every bus access performed by the CPU, including dummy reads
+// and writes. Dummy reads, whose value gets discarded by the CPU, are reported as AccessDummyRead while dummy writes
+// are indistinguishable from regular writes. Accesses through Memory which are not caused by the CPU itself are not
+// reported.
+func (c *CPU) OnMemoryAccess(hook MemoryAccessHook) HookID {
+	id := c.hooks.allocateID()
+	c.hooks.memoryAccess = append(c.hooks.memoryAccess, memoryAccessEntry{id, hook})
+	return id
+}
+
+// OnInterrupt registers a hook which gets called with the vector of every serviced NMI, IRQ or reset, after the CPU
+// has entered the interrupt handler. BRK is an instruction and therefore not reported as an interrupt.
+func (c *CPU) OnInterrupt(hook InterruptHook) HookID {
+	id := c.hooks.allocateID()
+	c.hooks.interrupt = append(c.hooks.interrupt, interruptEntry{id, hook})
+	return id
+}
+
+func (c *CPU) RemoveHook(id HookID) {
+	for i, entry := range c.hooks.beforeInstruction {
+		if entry.id == id {
+			c.hooks.beforeInstruction = append(c.hooks.beforeInstruction[:i], c.hooks.beforeInstruction[i+1:]...)
+			return
+		}
+	}
+	for i, entry := range c.hooks.afterInstruction {
+		if entry.id == id {
+			c.hooks.afterInstruction = append(c.hooks.afterInstruction[:i], c.hooks.afterInstruction[i+1:]...)
+			return
+		}
+	}
+	for i, entry := range c.hooks.memoryAccess {
+		if entry.id == id {
+			c.hooks.memoryAccess = append(c.hooks.memoryAccess[:i], c.hooks.memoryAccess[i+1:]...)
+			return
+		}
+	}
+	for i, entry := range c.hooks.interrupt {
+		if entry.id == id {
+			c.hooks.interrupt = append(c.hooks.interrupt[:i], c.hooks.interrupt[i+1:]...)
+			return
+		}
+	}
+}
+
+func (h *hooks) allocateID() HookID {
+	h.nextID++
+	return h.nextID
+}
+
+func (c *CPU) notifyBeforeInstruction(pc uint16, opcode Opcode, instruction *Instruction) {
+	for _, entry := range c.hooks.beforeInstruction {
+		entry.hook(pc, opcode, instruction)
+	}
+}
+
+func (c *CPU) notifyAfterInstruction(cycles Cycles) {
+	for _, entry := range c.hooks.afterInstruction {
+		entry.hook(c.Registers, cycles)
+	}
+}
+
+func (c *CPU) notifyMemoryAccess(accessType AccessType, address uint16, value uint8) {
+	access := MemoryAccess{Type: accessType, Address: address, Value: value, Cycle: c.TotalCycles}
+	for _, entry := range c.hooks.memoryAccess {
+		entry.hook(access)
+	}
+}
+
+func (c *CPU) notifyInterrupt(vector uint16) {
+	for _, entry := range c.hooks.interrupt {
+		entry.hook(vector)
+	}
+}
+
+func AccessTypeName(accessType AccessType) string {
+	switch accessType {
+	case AccessOpcode:
+		return "Opcode"
+	case AccessOperand:
+		return "Operand"
+	case AccessRead:
+		return "Read"
+	case AccessWrite:
+		return "Write"
+	case AccessDummyRead:
+		return "DummyRead"
+	default:
+		return "<unknown>"
+	}
+}
@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestInstructionHooks(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Registers.PC = MemoryTestLocation
+	cpu.Memory.Poke(MemoryTestLocation, 0xA9)
+	cpu.Memory.Poke(MemoryTestLocation+1, 0x42)
+
+	var beforeCalled, afterCalled bool
+	cpu.OnBeforeInstruction(func(pc uint16, opcode Opcode, instruction *Instruction) {
+		beforeCalled = true
+		assert.Equal(t, MemoryTestLocation, pc, "unexpected program counter")
+		assert.Equal(t, Opcode(0xA9), opcode, "unexpected opcode")
+		assert.Equal(t, "LDA", instruction.Mnemonic, "unexpected instruction")
+		assert.Equal(t, uint8(0x00), cpu.Registers.A, "expected hook to run before execution")
+	})
+	cpu.OnAfterInstruction(func(registers Registers, cycles Cycles) {
+		afterCalled = true
+		assert.Equal(t, uint8(0x42), registers.A, "unexpected accumulator")
+		assert.Equal(t, MemoryTestLocation+2, registers.PC, "unexpected program counter")
+		assert.Equal(t, Cycles(2), cycles, "unexpected cycle count")
+	})
+
+	assert.NoError(t, cpu.Execute())
+	assert.True(t, beforeCalled, "expected before instruction hook to be called")
+	assert.True(t, afterCalled, "expected after instruction hook to be called")
+}
+
+func TestMemoryAccessHook(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Registers.PC = MemoryTestLocation
+	cpu.Registers.A = 0x42
+	cpu.Memory.Poke(MemoryTestLocation, 0x8D)
+	cpu.Memory.Poke16(MemoryTestLocation+1, AbsoluteTestLocation)
+
+	var accesses []MemoryAccess
+	cpu.OnMemoryAccess(func(access MemoryAccess) {
+		accesses = append(accesses, access)
+	})
+
+	assert.NoError(t, cpu.Execute())
+	assert.Equal(t, []MemoryAccess{
+		{AccessOpcode, MemoryTestLocation, 0x8D, 1},
+		{AccessOperand, MemoryTestLocation + 1, 0x00, 2},
+		{AccessOperand, MemoryTestLocation + 2, 0xD0, 3},
+		{AccessWrite, AbsoluteTestLocation, 0x42, 4},
+	}, accesses, "unexpected memory accesses")
+}
+
+func TestMemoryAccessHookDummyRead(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Registers.PC = MemoryTestLocation
+	cpu.Registers.S = 0xFD
+	cpu.Memory.Poke(MemoryTestLocation, 0x20)
+	cpu.Memory.Poke16(MemoryTestLocation+1, AbsoluteTestLocation)
+
+	var accesses []MemoryAccess
+	cpu.OnMemoryAccess(func(access MemoryAccess) {
+		accesses = append(accesses, access)
+	})
+
+	// JSR reads the stack without using the value and fetches the high byte of its target after pushing
+	assert.NoError(t, cpu.Execute())
+	assert.Equal(t, []MemoryAccess{
+		{AccessOpcode, MemoryTestLocation, 0x20, 1},
+		{AccessOperand, MemoryTestLocation + 1, 0x00, 2},
+		{AccessDummyRead, 0x01FD, 0x00, 3},
+		{AccessWrite, 0x01FD, 0xC0, 4},
+		{AccessWrite, 0x01FC, 0x02, 5},
+		{AccessOperand, MemoryTestLocation + 2, 0xD0, 6},
+	}, accesses, "unexpected memory accesses")
+}
+
+func TestInterruptHook(t *testing.T) {
+	cpu := newInterruptTestCPU()
+
+	var vectors []uint16
+	cpu.OnInterrupt(func(vector uint16) {
+		vectors = append(vectors, vector)
+		assert.Equal(t, cpu.Memory.Peek16(vector), cpu.Registers.PC, "expected hook to run after entering handler")
+	})
+
+	cpu.TriggerNMI()
+	assert.NoError(t, cpu.Execute())
+	cpu.Reset()
+	assert.Equal(t, []uint16{NMIVector, ResetVector}, vectors, "unexpected interrupt vectors")
+}
+
+func TestRemoveHook(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Registers.PC = MemoryTestLocation
+	cpu.Memory.Poke(MemoryTestLocation, 0xEA)
+	cpu.Memory.Poke(MemoryTestLocation+1, 0xEA)
+
+	calls := 0
+	id := cpu.OnMemoryAccess(func(access MemoryAccess) {
+		calls++
+	})
+
+	assert.NoError(t, cpu.Execute())
+	cpu.RemoveHook(id)
+	assert.NoError(t, cpu.Execute())
+	assert.Equal(t, 2, calls, "expected hook to be removed")
+}
@@ -2,23 +2,29 @@ package processor
 
 import "fmt"
 
-type InstructionTable map[Opcode]Instruction
+type InstructionTable [256]Instruction
 type InstructionVariant struct {
 	Opcode         Opcode
 	AddressingMode AddressingMode
 	StaticCycles   Cycles
 }
 type Instruction struct {
-	Mnemonic string
-	Handler  OpcodeHandler
-	Variant  InstructionVariant
+	Mnemonic   string
+	Handler    OpcodeHandler
+	Variant    InstructionVariant
+	Unofficial bool
+
+	// endsBlock marks instructions which change the control flow, ending blocks of the decode cache
+	endsBlock bool
 }
 
-func (c *CPU) registerInstructions() {
+// registerInstructions builds the instruction table of the variant, failing if an opcode gets registered twice
+func (c *CPU) registerInstructions() error {
 	c.instructions = InstructionTable{}
+	r := &instructionRegistry{table: &c.instructions}
 
 	// AND - Logical AND
-	c.instructions.registerVariants("AND", c.opAND,
+	r.registerVariants("AND", c.opAND,
 		InstructionVariant{0x29, Immediate, 2},
 		InstructionVariant{0x25, ZeroPage, 3},
 		InstructionVariant{0x35, ZeroPageX, 4},
@@ -30,7 +36,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// ORA - Logical Inclusive OR
-	c.instructions.registerVariants("ORA", c.opORA,
+	r.registerVariants("ORA", c.opORA,
 		InstructionVariant{0x09, Immediate, 2},
 		InstructionVariant{0x05, ZeroPage, 3},
 		InstructionVariant{0x15, ZeroPageX, 4},
@@ -42,7 +48,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// EOR - Exclusive OR
-	c.instructions.registerVariants("EOR", c.opEOR,
+	r.registerVariants("EOR", c.opEOR,
 		InstructionVariant{0x49, Immediate, 2},
 		InstructionVariant{0x45, ZeroPage, 3},
 		InstructionVariant{0x55, ZeroPageX, 4},
@@ -54,7 +60,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// INC - Increment Memory
-	c.instructions.registerVariants("INC", c.opINC,
+	r.registerVariants("INC", c.opINC,
 		InstructionVariant{0xE6, ZeroPage, 5},
 		InstructionVariant{0xF6, ZeroPageX, 6},
 		InstructionVariant{0xEE, Absolute, 6},
@@ -62,17 +68,17 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// INX - Increment X Register
-	c.instructions.registerVariants("INX", c.opINX,
+	r.registerVariants("INX", c.opINX,
 		InstructionVariant{0xE8, Implicit, 2},
 	)
 
 	// INY - Increment Y Register
-	c.instructions.registerVariants("INY", c.opINY,
+	r.registerVariants("INY", c.opINY,
 		InstructionVariant{0xC8, Implicit, 2},
 	)
 
 	// DEC - Decrement Memory
-	c.instructions.registerVariants("DEC", c.opDEC,
+	r.registerVariants("DEC", c.opDEC,
 		InstructionVariant{0xC6, ZeroPage, 5},
 		InstructionVariant{0xD6, ZeroPageX, 6},
 		InstructionVariant{0xCE, Absolute, 6},
@@ -80,17 +86,17 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// DEX - Decrement X Register
-	c.instructions.registerVariants("DEX", c.opDEX,
+	r.registerVariants("DEX", c.opDEX,
 		InstructionVariant{0xCA, Implicit, 2},
 	)
 
 	// DEY - Decrement Y Register
-	c.instructions.registerVariants("DEY", c.opDEY,
+	r.registerVariants("DEY", c.opDEY,
 		InstructionVariant{0x88, Implicit, 2},
 	)
 
 	// CMP - Compare
-	c.instructions.registerVariants("CMP", c.opCMP,
+	r.registerVariants("CMP", c.opCMP,
 		InstructionVariant{0xC9, Immediate, 2},
 		InstructionVariant{0xC5, ZeroPage, 3},
 		InstructionVariant{0xD5, ZeroPageX, 4},
@@ -102,91 +108,91 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// CPX - Compare X Register
-	c.instructions.registerVariants("CPX", c.opCPX,
+	r.registerVariants("CPX", c.opCPX,
 		InstructionVariant{0xE0, Immediate, 2},
 		InstructionVariant{0xE4, ZeroPage, 3},
 		InstructionVariant{0xEC, Absolute, 4},
 	)
 
 	// CPY - Compare Y Register
-	c.instructions.registerVariants("CPY", c.opCPY,
+	r.registerVariants("CPY", c.opCPY,
 		InstructionVariant{0xC0, Immediate, 2},
 		InstructionVariant{0xC4, ZeroPage, 3},
 		InstructionVariant{0xCC, Absolute, 4},
 	)
 
 	// TAX - Transfer Accumulator to X
-	c.instructions.registerVariants("TAX", c.opTAX,
+	r.registerVariants("TAX", c.opTAX,
 		InstructionVariant{0xAA, Implicit, 2},
 	)
 
 	// TXA - Transfer X to Accumulator
-	c.instructions.registerVariants("TXA", c.opTXA,
+	r.registerVariants("TXA", c.opTXA,
 		InstructionVariant{0x8A, Implicit, 2},
 	)
 
 	// TAY - Transfer Accumulator to Y
-	c.instructions.registerVariants("TAY", c.opTAY,
+	r.registerVariants("TAY", c.opTAY,
 		InstructionVariant{0xA8, Implicit, 2},
 	)
 
 	// TYA - Transfer Y to Accumulator
-	c.instructions.registerVariants("TYA", c.opTYA,
+	r.registerVariants("TYA", c.opTYA,
 		InstructionVariant{0x98, Implicit, 2},
 	)
 
 	// TSX - Transfer Stack Pointer to X
-	c.instructions.registerVariants("TSX", c.opTSX,
+	r.registerVariants("TSX", c.opTSX,
 		InstructionVariant{0xBA, Implicit, 2},
 	)
 
 	// TXS - Transfer X to Stack Pointer
-	c.instructions.registerVariants("TXS", c.opTXS,
+	r.registerVariants("TXS", c.opTXS,
 		InstructionVariant{0x9A, Implicit, 2},
 	)
 
 	// BCS - Branch if Carry Set
-	c.instructions.registerVariants("BCS", c.opBCS,
+	r.registerControlFlow("BCS", c.opBCS,
 		InstructionVariant{0xB0, Relative, 2},
 	)
 
 	// BCC - Branch if Carry Clear
-	c.instructions.registerVariants("BCC", c.opBCC,
+	r.registerControlFlow("BCC", c.opBCC,
 		InstructionVariant{0x90, Relative, 2},
 	)
 
 	// BEQ - Branch if Equal
-	c.instructions.registerVariants("BEQ", c.opBEQ,
+	r.registerControlFlow("BEQ", c.opBEQ,
 		InstructionVariant{0xF0, Relative, 2},
 	)
 
 	// BNE - Branch if Not Equal
-	c.instructions.registerVariants("BNE", c.opBNE,
+	r.registerControlFlow("BNE", c.opBNE,
 		InstructionVariant{0xD0, Relative, 2},
 	)
 
 	// BMI - Branch if Minus
-	c.instructions.registerVariants("BMI", c.opBMI,
+	r.registerControlFlow("BMI", c.opBMI,
 		InstructionVariant{0x30, Relative, 2},
 	)
 
 	// BPL - Branch if Positive
-	c.instructions.registerVariants("BPL", c.opBPL,
+	r.registerControlFlow("BPL", c.opBPL,
 		InstructionVariant{0x10, Relative, 2},
 	)
 
 	// BVS - Branch if Overflow Set
-	c.instructions.registerVariants("BVS", c.opBVS,
+	r.registerControlFlow("BVS", c.opBVS,
 		InstructionVariant{0x70, Relative, 2},
 	)
 
 	// BVC - Branch if Overflow Clear
-	c.instructions.registerVariants("BVC", c.opBVC,
+	r.registerControlFlow("BVC", c.opBVC,
 		InstructionVariant{0x50, Relative, 2},
 	)
 
 	// LDA - Load Accumulator
-	c.instructions.registerVariants("LDA", c.opLDA,
+	r.registerVariants("LDA", c.opLDA,
 		InstructionVariant{0xA9, Immediate, 2},
 		InstructionVariant{0xA5, ZeroPage, 3},
 		InstructionVariant{0xB5, ZeroPageX, 4},
@@ -198,7 +204,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// LDX - Load X Register
-	c.instructions.registerVariants("LDX", c.opLDX,
+	r.registerVariants("LDX", c.opLDX,
 		InstructionVariant{0xA2, Immediate, 2},
 		InstructionVariant{0xA6, ZeroPage, 3},
 		InstructionVariant{0xB6, ZeroPageY, 4},
@@ -207,7 +213,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// LDY - Load Y Register
-	c.instructions.registerVariants("LDY", c.opLDY,
+	r.registerVariants("LDY", c.opLDY,
 		InstructionVariant{0xA0, Immediate, 2},
 		InstructionVariant{0xA4, ZeroPage, 3},
 		InstructionVariant{0xB4, ZeroPageX, 4},
@@ -216,7 +222,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// STA - Store Accumulator
-	c.instructions.registerVariants("STA", c.opSTA,
+	r.registerVariants("STA", c.opSTA,
 		InstructionVariant{0x85, ZeroPage, 3},
 		InstructionVariant{0x95, ZeroPageX, 4},
 		InstructionVariant{0x8D, Absolute, 4},
@@ -227,142 +233,113 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// STX - Store X Register
-	c.instructions.registerVariants("STX", c.opSTX,
+	r.registerVariants("STX", c.opSTX,
 		InstructionVariant{0x86, ZeroPage, 3},
 		InstructionVariant{0x96, ZeroPageY, 4},
 		InstructionVariant{0x8E, Absolute, 4},
 	)
 
 	// STY - Store Y Register
-	c.instructions.registerVariants("STY", c.opSTY,
+	r.registerVariants("STY", c.opSTY,
 		InstructionVariant{0x84, ZeroPage, 3},
 		InstructionVariant{0x94, ZeroPageX, 4},
 		InstructionVariant{0x8C, Absolute, 4},
 	)
 
 	// CLC - Clear Carry Status
-	c.instructions.registerVariants("CLC", c.opCLC,
+	r.registerVariants("CLC", c.opCLC,
 		InstructionVariant{0x18, Implicit, 2},
 	)
 
 	// SEC - Set Carry Status
-	c.instructions.registerVariants("SEC", c.opSEC,
+	r.registerVariants("SEC", c.opSEC,
 		InstructionVariant{0x38, Implicit, 2},
 	)
 
 	// CLD - Set Decimal Mode
-	c.instructions.registerVariants("CLD", c.opCLD,
+	r.registerVariants("CLD", c.opCLD,
 		InstructionVariant{0xD8, Implicit, 2},
 	)
 
 	// SED - Set Decimal Mode
-	c.instructions.registerVariants("SED", c.opSED,
+	r.registerVariants("SED", c.opSED,
 		InstructionVariant{0xF8, Implicit, 2},
 	)
 
 	// CLI - Clear Interrupt Disable
-	c.instructions.registerVariants("CLI", c.opCLI,
+	r.registerVariants("CLI", c.opCLI,
 		InstructionVariant{0x58, Implicit, 2},
 	)
 
 	// SEI - Set Interrupt Disable
-	c.instructions.registerVariants("SEI", c.opSEI,
+	r.registerVariants("SEI", c.opSEI,
 		InstructionVariant{0x78, Implicit, 2},
 	)
 
 	// CLV - Clear Overflow Status
-	c.instructions.registerVariants("CLV", c.opCLV,
+	r.registerVariants("CLV", c.opCLV,
 		InstructionVariant{0xB8, Implicit, 2},
 	)
 
 	// JMP - Jump
-	c.instructions.registerVariants("JMP", c.opJMP,
+	r.registerControlFlow("JMP", c.opJMP,
 		InstructionVariant{0x4C, Absolute, 3},
 		InstructionVariant{0x6C, Indirect, 5},
 	)
 
 	// JSR - Jump to Subroutine
-	c.instructions.registerVariants("JSR", c.opJSR,
+	r.registerControlFlow("JSR", c.opJSR,
 		InstructionVariant{0x20, Absolute, 6},
 	)
 
 	// RTS - Return from Subroutine
-	c.instructions.registerVariants("RTS", c.opRTS,
+	r.registerControlFlow("RTS", c.opRTS,
 		InstructionVariant{0x60, Implicit, 6},
 	)
 
 	// PHA - Push Accumulator
-	c.instructions.registerVariants("PHA", c.opPHA,
+	r.registerVariants("PHA", c.opPHA,
 		InstructionVariant{0x48, Implicit, 3},
 	)
 
 	// PLA - Pull Accumulator
-	c.instructions.registerVariants("PLA", c.opPLA,
+	r.registerVariants("PLA", c.opPLA,
 		InstructionVariant{0x68, Implicit, 4},
 	)
 
 	// PHP - Push Processor Status
-	c.instructions.registerVariants("PHP", c.opPHP,
+	r.registerVariants("PHP", c.opPHP,
 		InstructionVariant{0x08, Implicit, 3},
 	)
 
 	// PLP - Pull Processor Status
-	c.instructions.registerVariants("PLP", c.opPLP,
+	r.registerVariants("PLP", c.opPLP,
 		InstructionVariant{0x28, Implicit, 4},
 	)
 
 	// NOP - No Operation
-	c.instructions.registerVariants("NOP", c.opNOP,
+	r.registerVariants("NOP", c.opNOP,
 		InstructionVariant{0xEA, Implicit, 2},
-
-		// Unofficial Opcodes
-		InstructionVariant{0x04, ZeroPage, 3},
-		InstructionVariant{0x0C, Absolute, 4},
-		InstructionVariant{0x14, ZeroPageX, 4},
-		InstructionVariant{0x1A, Implicit, 2},
-		InstructionVariant{0x1C, AbsoluteX, 4},
-		InstructionVariant{0x34, ZeroPageX, 4},
-		InstructionVariant{0x3A, Implicit, 2},
-		InstructionVariant{0x3C, AbsoluteX, 4},
-		InstructionVariant{0x44, ZeroPage, 3},
-		InstructionVariant{0x54, ZeroPageX, 4},
-		InstructionVariant{0x5A, Implicit, 2},
-		InstructionVariant{0x5C, AbsoluteX, 4},
-		InstructionVariant{0x64, ZeroPage, 3},
-		InstructionVariant{0x74, ZeroPageX, 4},
-		InstructionVariant{0x7A, Implicit, 2},
-		InstructionVariant{0x7C, AbsoluteX, 4},
-		InstructionVariant{0x80, Immediate, 2},
-		InstructionVariant{0x82, Immediate, 2},
-		InstructionVariant{0x89, Immediate, 2},
-		InstructionVariant{0xC2, Immediate, 2},
-		InstructionVariant{0xD4, ZeroPageX, 4},
-		InstructionVariant{0xDA, Implicit, 2},
-		InstructionVariant{0xDC, AbsoluteX, 4},
-		InstructionVariant{0xE2, Immediate, 2},
-		InstructionVariant{0xF4, ZeroPageX, 4},
-		InstructionVariant{0xFA, Implicit, 2},
-		InstructionVariant{0xFC, AbsoluteX, 4},
 	)
 
 	// RTI - Return from Interrupt
-	c.instructions.registerVariants("RTI", c.opRTI,
+	r.registerControlFlow("RTI", c.opRTI,
 		InstructionVariant{0x40, Implicit, 6},
 	)
 
 	// BRK - Force Interrupt
-	c.instructions.registerVariants("BRK", c.opBRK,
+	r.registerControlFlow("BRK", c.opBRK,
 		InstructionVariant{0x00, Implicit, 7},
 	)
 
 	// BIT - Bit Test
-	c.instructions.registerVariants("BIT", c.opBIT,
+	r.registerVariants("BIT", c.opBIT,
 		InstructionVariant{0x24, ZeroPage, 3},
 		InstructionVariant{0x2C, Absolute, 4},
 	)
 
 	// ROL - Rotate Left
-	c.instructions.registerVariants("ROL", c.opROL,
+	r.registerVariants("ROL", c.opROL,
 		InstructionVariant{0x2A, Accumulator, 2},
 		InstructionVariant{0x26, ZeroPage, 5},
 		InstructionVariant{0x36, ZeroPageX, 6},
@@ -371,7 +348,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// ROR - Rotate Right
-	c.instructions.registerVariants("ROR", c.opROR,
+	r.registerVariants("ROR", c.opROR,
 		InstructionVariant{0x6A, Accumulator, 2},
 		InstructionVariant{0x66, ZeroPage, 5},
 		InstructionVariant{0x76, ZeroPageX, 6},
@@ -380,7 +357,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// ASL - Arithmetic Shift Left
-	c.instructions.registerVariants("ASL", c.opASL,
+	r.registerVariants("ASL", c.opASL,
 		InstructionVariant{0x0A, Accumulator, 2},
 		InstructionVariant{0x06, ZeroPage, 5},
 		InstructionVariant{0x16, ZeroPageX, 6},
@@ -389,7 +366,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// LSR - Logical Shift Right
-	c.instructions.registerVariants("LSR", c.opLSR,
+	r.registerVariants("LSR", c.opLSR,
 		InstructionVariant{0x4A, Accumulator, 2},
 		InstructionVariant{0x46, ZeroPage, 5},
 		InstructionVariant{0x56, ZeroPageX, 6},
@@ -398,7 +375,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// ADC - Add with Carry
-	c.instructions.registerVariants("ADC", c.opADC,
+	r.registerVariants("ADC", c.opADC,
 		InstructionVariant{0x69, Immediate, 2},
 		InstructionVariant{0x65, ZeroPage, 3},
 		InstructionVariant{0x75, ZeroPageX, 4},
@@ -410,7 +387,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// SBC - Subtract with Carry
-	c.instructions.registerVariants("SBC", c.opSBC,
+	r.registerVariants("SBC", c.opSBC,
 		InstructionVariant{0xE9, Immediate, 2},
 		InstructionVariant{0xE5, ZeroPage, 3},
 		InstructionVariant{0xF5, ZeroPageX, 4},
@@ -419,13 +396,62 @@ func (c *CPU) registerInstructions() {
 		InstructionVariant{0xF9, AbsoluteY, 4},
 		InstructionVariant{0xE1, IndirectX, 6},
 		InstructionVariant{0xF1, IndirectY, 5},
+	)
+
+	switch {
+	case c.variant == WDC65C02:
+		c.registerCMOSInstructions(r)
+	case !c.officialOnly:
+		official := c.instructions
+		c.registerUnofficialInstructions(r)
+		for opcode := range c.instructions {
+			c.instructions[opcode].Unofficial = official[opcode].Handler == nil && c.instructions[opcode].Handler != nil
+		}
+	}
+	c.sparse = c.instructions.sparse()
+	return r.err
+}
+
+// registerUnofficialInstructions registers the undocumented opcodes of the NMOS 6502 and the Ricoh 2A03.
+func (c *CPU) registerUnofficialInstructions(r *instructionRegistry) {
+	// [Unofficial] NOP - No Operation
+	r.registerVariants("NOP", c.opNOP,
+		InstructionVariant{0x04, ZeroPage, 3},
+		InstructionVariant{0x0C, Absolute, 4},
+		InstructionVariant{0x14, ZeroPageX, 4},
+		InstructionVariant{0x1A, Implicit, 2},
+		InstructionVariant{0x1C, AbsoluteX, 4},
+		InstructionVariant{0x34, ZeroPageX, 4},
+		InstructionVariant{0x3A, Implicit, 2},
+		InstructionVariant{0x3C, AbsoluteX, 4},
+		InstructionVariant{0x44, ZeroPage, 3},
+		InstructionVariant{0x54, ZeroPageX, 4},
+		InstructionVariant{0x5A, Implicit, 2},
+		InstructionVariant{0x5C, AbsoluteX, 4},
+		InstructionVariant{0x64, ZeroPage, 3},
+		InstructionVariant{0x74, ZeroPageX, 4},
+		InstructionVariant{0x7A, Implicit, 2},
+		InstructionVariant{0x7C, AbsoluteX, 4},
+		InstructionVariant{0x80, Immediate, 2},
+		InstructionVariant{0x82, Immediate, 2},
+		InstructionVariant{0x89, Immediate, 2},
+		InstructionVariant{0xC2, Immediate, 2},
+		InstructionVariant{0xD4, ZeroPageX, 4},
+		InstructionVariant{0xDA, Implicit, 2},
+		InstructionVariant{0xDC, AbsoluteX, 4},
+		InstructionVariant{0xE2, Immediate, 2},
+		InstructionVariant{0xF4, ZeroPageX, 4},
+		InstructionVariant{0xFA, Implicit, 2},
+		InstructionVariant{0xFC, AbsoluteX, 4},
+	)
 
-		// Unofficial Opcodes
+	// [Unofficial] SBC - Subtract with Carry
+	r.registerVariants("SBC", c.opSBC,
 		InstructionVariant{0xEB, Immediate, 2},
 	)
 
 	// [Unofficial] LAX - Load Accumulator and X Register
-	c.instructions.registerVariants("LAX", c.opLAX,
+	r.registerVariants("LAX", c.opLAX,
 		InstructionVariant{0xA3, IndirectX, 6},
 		InstructionVariant{0xA7, ZeroPage, 3},
 		InstructionVariant{0xAF, Absolute, 4},
@@ -435,7 +461,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// [Unofficial] SAX - Store Accumulator and X Register
-	c.instructions.registerVariants("SAX", c.opSAX,
+	r.registerVariants("SAX", c.opSAX,
 		InstructionVariant{0x83, IndirectX, 6},
 		InstructionVariant{0x87, ZeroPage, 3},
 		InstructionVariant{0x8F, Absolute, 4},
@@ -443,7 +469,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// [Unofficial] DCP - Decrement Memory and Compare
-	c.instructions.registerVariants("DCP", c.opDCP,
+	r.registerVariants("DCP", c.opDCP,
 		InstructionVariant{0xC3, IndirectX, 8},
 		InstructionVariant{0xC7, ZeroPage, 5},
 		InstructionVariant{0xCF, Absolute, 6},
@@ -454,7 +480,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// [Unofficial] ISC - Increment Memory and Subtract with Carry
-	c.instructions.registerVariants("ISC", c.opISC,
+	r.registerVariants("ISC", c.opISC,
 		InstructionVariant{0xE3, IndirectX, 8},
 		InstructionVariant{0xE7, ZeroPage, 5},
 		InstructionVariant{0xEF, Absolute, 6},
@@ -465,7 +491,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// [Unofficial] SLO - Arithmetic Shift Left and Logical Inclusive OR
-	c.instructions.registerVariants("SLO", c.opSLO,
+	r.registerVariants("SLO", c.opSLO,
 		InstructionVariant{0x03, IndirectX, 8},
 		InstructionVariant{0x07, ZeroPage, 5},
 		InstructionVariant{0x0F, Absolute, 6},
@@ -476,7 +502,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// [Unofficial] RLA - Rotate Left and Logical AND
-	c.instructions.registerVariants("RLA", c.opRLA,
+	r.registerVariants("RLA", c.opRLA,
 		InstructionVariant{0x23, IndirectX, 8},
 		InstructionVariant{0x27, ZeroPage, 5},
 		InstructionVariant{0x2F, Absolute, 6},
@@ -487,7 +513,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// [Unofficial] SRE - Logical Shift Right and Exclusive OR
-	c.instructions.registerVariants("SRE", c.opSRE,
+	r.registerVariants("SRE", c.opSRE,
 		InstructionVariant{0x43, IndirectX, 8},
 		InstructionVariant{0x47, ZeroPage, 5},
 		InstructionVariant{0x4F, Absolute, 6},
@@ -498,7 +524,7 @@ func (c *CPU) registerInstructions() {
 	)
 
 	// [Unofficial] RRA - Rotate Right and Add with Carry
-	c.instructions.registerVariants("RRA", c.opRRA,
+	r.registerVariants("RRA", c.opRRA,
 		InstructionVariant{0x63, IndirectX, 8},
 		InstructionVariant{0x67, ZeroPage, 5},
 		InstructionVariant{0x6F, Absolute, 6},
@@ -508,50 +534,270 @@ func (c *CPU) registerInstructions() {
 		InstructionVariant{0x7F, AbsoluteX, 7},
 	)
 
+	// [Unofficial] ANC - Logical AND and Copy Negative to Carry
+	r.registerVariants("ANC", c.opANC,
+		InstructionVariant{0x0B, Immediate, 2},
+		InstructionVariant{0x2B, Immediate, 2},
+	)
+
+	// [Unofficial] ALR - Logical AND and Logical Shift Right
+	r.registerVariants("ALR", c.opALR,
+		InstructionVariant{0x4B, Immediate, 2},
+	)
+
+	// [Unofficial] ARR - Logical AND and Rotate Right
+	r.registerVariants("ARR", c.opARR,
+		InstructionVariant{0x6B, Immediate, 2},
+	)
+
+	// [Unofficial] AXS - Logical AND of Accumulator and X Register and Subtract into X Register
+	r.registerVariants("AXS", c.opAXS,
+		InstructionVariant{0xCB, Immediate, 2},
+	)
+
+	// [Unofficial] LAS - Logical AND with Stack Pointer and Load Accumulator, X Register and Stack Pointer
+	r.registerVariants("LAS", c.opLAS,
+		InstructionVariant{0xBB, AbsoluteY, 4},
+	)
+
+	// [Unofficial] LAX - Load Accumulator and X Register (unstable immediate variant)
+	r.registerVariants("LAX", c.opLXA,
+		InstructionVariant{0xAB, Immediate, 2},
+	)
+
+	// [Unofficial] XAA - Transfer X Register to Accumulator and Logical AND (unstable)
+	r.registerVariants("XAA", c.opXAA,
+		InstructionVariant{0x8B, Immediate, 2},
+	)
+
+	// [Unofficial] SHX - Store X Register AND High Byte of Address (unstable)
+	r.registerVariants("SHX", c.opSHX,
+		InstructionVariant{0x9E, AbsoluteY, 5},
+	)
+
+	// [Unofficial] SHY - Store Y Register AND High Byte of Address (unstable)
+	r.registerVariants("SHY", c.opSHY,
+		InstructionVariant{0x9C, AbsoluteX, 5},
+	)
+
+	// [Unofficial] TAS - Transfer Accumulator AND X Register to Stack Pointer and Store AND High Byte (unstable)
+	r.registerVariants("TAS", c.opTAS,
+		InstructionVariant{0x9B, AbsoluteY, 5},
+	)
+
+	// [Unofficial] AHX - Store Accumulator AND X Register AND High Byte of Address (unstable)
+	r.registerVariants("AHX", c.opAHX,
+		InstructionVariant{0x93, IndirectY, 6},
+		InstructionVariant{0x9F, AbsoluteY, 5},
+	)
+
 	// [Unofficial] KIL - Halt CPU
-	c.instructions.registerVariants("KIL", c.opKIL,
-		InstructionVariant{0x02, Implicit, 0},
-		InstructionVariant{0x12, Implicit, 0},
-		InstructionVariant{0x22, Implicit, 0},
-		InstructionVariant{0x32, Implicit, 0},
-		InstructionVariant{0x42, Implicit, 0},
-		InstructionVariant{0x52, Implicit, 0},
-		InstructionVariant{0x62, Implicit, 0},
-		InstructionVariant{0x72, Implicit, 0},
-		InstructionVariant{0x92, Implicit, 0},
-		InstructionVariant{0xB2, Implicit, 0},
-		InstructionVariant{0xD2, Implicit, 0},
-		InstructionVariant{0xF2, Implicit, 0},
+	r.registerControlFlow("KIL", c.opKIL,
+		InstructionVariant{0x02, Implicit, 2},
+		InstructionVariant{0x12, Implicit, 2},
+		InstructionVariant{0x22, Implicit, 2},
+		InstructionVariant{0x32, Implicit, 2},
+		InstructionVariant{0x42, Implicit, 2},
+		InstructionVariant{0x52, Implicit, 2},
+		InstructionVariant{0x62, Implicit, 2},
+		InstructionVariant{0x72, Implicit, 2},
+		InstructionVariant{0x92, Implicit, 2},
+		InstructionVariant{0xB2, Implicit, 2},
+		InstructionVariant{0xD2, Implicit, 2},
+		InstructionVariant{0xF2, Implicit, 2},
 	)
 }
 
-func (t *InstructionTable) registerVariant(mnemonic string, handler OpcodeHandler, variant InstructionVariant) {
-	if _, ok := (*t)[variant.Opcode]; ok {
-		panic(fmt.Errorf("duplicate opcode registration: 0x%02X", variant.Opcode))
+// registerCMOSInstructions registers the additional instructions and addressing modes of the WDC 65C02, which
+// replaced all undocumented opcodes of the NMOS 6502 with new instructions or well-defined NOPs.
+func (c *CPU) registerCMOSInstructions(r *instructionRegistry) {
+	// [65C02] (zp) addressing mode for existing instructions
+	r.registerVariant("ORA", c.opORA, InstructionVariant{0x12, ZeroPageIndirect, 5})
+	r.registerVariant("AND", c.opAND, InstructionVariant{0x32, ZeroPageIndirect, 5})
+	r.registerVariant("EOR", c.opEOR, InstructionVariant{0x52, ZeroPageIndirect, 5})
+	r.registerVariant("ADC", c.opADC, InstructionVariant{0x72, ZeroPageIndirect, 5})
+	r.registerVariant("STA", c.opSTA, InstructionVariant{0x92, ZeroPageIndirect, 5})
+	r.registerVariant("LDA", c.opLDA, InstructionVariant{0xB2, ZeroPageIndirect, 5})
+	r.registerVariant("CMP", c.opCMP, InstructionVariant{0xD2, ZeroPageIndirect, 5})
+	r.registerVariant("SBC", c.opSBC, InstructionVariant{0xF2, ZeroPageIndirect, 5})
+
+	// [65C02] Additional addressing modes for existing instructions
+	r.registerVariants("BIT", c.opBIT,
+		InstructionVariant{0x89, Immediate, 2},
+		InstructionVariant{0x34, ZeroPageX, 4},
+		InstructionVariant{0x3C, AbsoluteX, 4},
+	)
+	r.registerVariant("INC", c.opINC, InstructionVariant{0x1A, Accumulator, 2})
+	r.registerVariant("DEC", c.opDEC, InstructionVariant{0x3A, Accumulator, 2})
+	r.registerControlFlow("JMP", c.opJMP, InstructionVariant{0x7C, AbsoluteIndirectX, 6})
+
+	// [65C02] Changed timings of existing instructions. JMP (abs) spends an extra cycle on fixing the page wrap bug,
+	// while shift and rotate instructions using absolute,X addressing skip the page fixup if no boundary is crossed.
+	c.instructions[0x6C].Variant.StaticCycles = 6
+	for _, opcode := range []Opcode{0x1E, 0x3E, 0x5E, 0x7E} {
+		c.instructions[opcode].Variant.StaticCycles = 6
 	}
 
-	(*t)[variant.Opcode] = Instruction{
-		Mnemonic: mnemonic,
-		Handler:  handler,
-		Variant:  variant,
+	// [65C02] BRA - Branch Always
+	r.registerControlFlow("BRA", c.opBRA,
+		InstructionVariant{0x80, Relative, 3},
+	)
+
+	// [65C02] PHX - Push X Register
+	r.registerVariants("PHX", c.opPHX,
+		InstructionVariant{0xDA, Implicit, 3},
+	)
+
+	// [65C02] PLX - Pull X Register
+	r.registerVariants("PLX", c.opPLX,
+		InstructionVariant{0xFA, Implicit, 4},
+	)
+
+	// [65C02] PHY - Push Y Register
+	r.registerVariants("PHY", c.opPHY,
+		InstructionVariant{0x5A, Implicit, 3},
+	)
+
+	// [65C02] PLY - Pull Y Register
+	r.registerVariants("PLY", c.opPLY,
+		InstructionVariant{0x7A, Implicit, 4},
+	)
+
+	// [65C02] STZ - Store Zero
+	r.registerVariants("STZ", c.opSTZ,
+		InstructionVariant{0x64, ZeroPage, 3},
+		InstructionVariant{0x74, ZeroPageX, 4},
+		InstructionVariant{0x9C, Absolute, 4},
+		InstructionVariant{0x9E, AbsoluteX, 5},
+	)
+
+	// [65C02] TRB - Test and Reset Bits
+	r.registerVariants("TRB", c.opTRB,
+		InstructionVariant{0x14, ZeroPage, 5},
+		InstructionVariant{0x1C, Absolute, 6},
+	)
+
+	// [65C02] TSB - Test and Set Bits
+	r.registerVariants("TSB", c.opTSB,
+		InstructionVariant{0x04, ZeroPage, 5},
+		InstructionVariant{0x0C, Absolute, 6},
+	)
+
+	// [65C02] RMB, SMB, BBR and BBS - Reset, Set and Branch on Memory Bits
+	for bit := uint8(0); bit < 8; bit++ {
+		r.registerVariant(fmt.Sprintf("RMB%d", bit), c.opRMB(bit),
+			InstructionVariant{Opcode(0x07 | bit<<4), ZeroPage, 5})
+		r.registerVariant(fmt.Sprintf("SMB%d", bit), c.opSMB(bit),
+			InstructionVariant{Opcode(0x87 | bit<<4), ZeroPage, 5})
+		r.registerControlFlow(fmt.Sprintf("BBR%d", bit), c.opBBR(bit),
+			InstructionVariant{Opcode(0x0F | bit<<4), ZeroPageRelative, 5})
+		r.registerControlFlow(fmt.Sprintf("BBS%d", bit), c.opBBS(bit),
+			InstructionVariant{Opcode(0x8F | bit<<4), ZeroPageRelative, 5})
+	}
+
+	// [65C02] WAI - Wait for Interrupt
+	r.registerControlFlow("WAI", c.opWAI,
+		InstructionVariant{0xCB, Implicit, 3},
+	)
+
+	// [65C02] STP - Stop
+	r.registerControlFlow("STP", c.opSTP,
+		InstructionVariant{0xDB, Implicit, 2},
+	)
+
+	// [65C02] NOP - No Operation
+	r.registerVariants("NOP", c.opNOP,
+		InstructionVariant{0x02, Immediate, 2},
+		InstructionVariant{0x22, Immediate, 2},
+		InstructionVariant{0x42, Immediate, 2},
+		InstructionVariant{0x62, Immediate, 2},
+		InstructionVariant{0x82, Immediate, 2},
+		InstructionVariant{0xC2, Immediate, 2},
+		InstructionVariant{0xE2, Immediate, 2},
+		InstructionVariant{0x44, ZeroPage, 3},
+		InstructionVariant{0x54, ZeroPageX, 4},
+		InstructionVariant{0xD4, ZeroPageX, 4},
+		InstructionVariant{0xF4, ZeroPageX, 4},
+		InstructionVariant{0xDC, Absolute, 4},
+		InstructionVariant{0xFC, Absolute, 4},
+	)
+	r.registerVariant("NOP", c.opNOP5C, InstructionVariant{0x5C, Absolute, 8})
+
+	// [65C02] Single-cycle NOPs in columns 3 and B
+	for opcode := 0x03; opcode <= 0xFF; opcode += 0x08 {
+		if opcode != 0xCB && opcode != 0xDB {
+			r.registerVariant("NOP", c.opNOP, InstructionVariant{Opcode(opcode), Implicit, 1})
+		}
+	}
+}
+
+// Lookup returns the instruction which gets executed for the given opcode by the variant of this CPU.
+func (c *CPU) Lookup(opcode Opcode) (instruction Instruction, ok bool) {
+	entry, ok := c.instructions.lookup(opcode)
+	return *entry, ok
+}
+
+func (t *InstructionTable) lookup(opcode Opcode) (instruction *Instruction, ok bool) {
+	instruction = &t[opcode]
+	ok = instruction.Handler != nil
+	return
+}
+
+func (t *InstructionTable) sparse() bool {
+	for opcode := range t {
+		if t[opcode].Handler == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// instructionRegistry fills an instruction table, keeping the first registration of every opcode as well as the error
+// of the first duplicate registration
+type instructionRegistry struct {
+	table *InstructionTable
+	err   error
+}
+
+func (r *instructionRegistry) registerVariant(mnemonic string, handler OpcodeHandler, variant InstructionVariant) {
+	r.register(Instruction{Mnemonic: mnemonic, Handler: handler, Variant: variant})
+}
+
+func (r *instructionRegistry) registerVariants(mnemonic string, handler OpcodeHandler, variants ...InstructionVariant) {
+	for _, variant := range variants {
+		r.registerVariant(mnemonic, handler, variant)
 	}
 }
 
-func (t *InstructionTable) registerVariants(mnemonic string, handler OpcodeHandler, variants ...InstructionVariant) {
+// registerControlFlow registers jumps, branches and other instructions which do not continue with the next one
+func (r *instructionRegistry) registerControlFlow(mnemonic string, handler OpcodeHandler, variants ...InstructionVariant) {
 	for _, variant := range variants {
-		t.registerVariant(mnemonic, handler, variant)
+		r.register(Instruction{Mnemonic: mnemonic, Handler: handler, Variant: variant, endsBlock: true})
 	}
 }
 
+func (r *instructionRegistry) register(instruction Instruction) {
+	opcode := instruction.Variant.Opcode
+	if _, ok := r.table.lookup(opcode); ok {
+		if r.err == nil {
+			r.err = fmt.Errorf("duplicate opcode registration: 0x%02X", opcode)
+		}
+		return
+	}
+
+	r.table[opcode] = instruction
+}
+
 func (c *CPU) Decode(pc uint16) (instruction Instruction, bytes []byte, disassembly string) {
-	opcode, arg1, arg2 := c.Memory.Peek(pc), c.Memory.Peek(pc+1), c.Memory.Peek(pc+2)
-	arg16 := c.Memory.Peek16(pc + 1)
+	opcode, arg1, arg2 := c.Memory.DebugPeek(pc), c.Memory.DebugPeek(pc+1), c.Memory.DebugPeek(pc+2)
+	arg16 := c.Memory.DebugPeek16(pc + 1)
 
-	instruction, ok := c.instructions[Opcode(opcode)]
+	entry, ok := c.instructions.lookup(Opcode(opcode))
 	if !ok {
 		disassembly = fmt.Sprintf("invalid opcode: 0x%02X", opcode)
 		return
 	}
+	instruction = *entry
 
 	switch instruction.Variant.AddressingMode {
 	case Implicit:
@@ -583,7 +829,7 @@ func (c *CPU) Decode(pc uint16) (instruction Instruction, bytes []byte, disassem
 		disassembly = fmt.Sprintf("%s %04X,Y", instruction.Mnemonic, arg16)
 	case Relative:
 		bytes = []byte{opcode, arg1}
-		disassembly = fmt.Sprintf("%s %d", instruction.Mnemonic, c.toSigned(arg1))
+		disassembly = fmt.Sprintf("%s %04X", instruction.Mnemonic, pc+2+uint16(c.toSigned(arg1)))
 	case Indirect:
 		bytes = []byte{opcode, arg1, arg2}
 		disassembly = fmt.Sprintf("%s (%04X)", instruction.Mnemonic, arg16)
@@ -591,8 +837,17 @@ func (c *CPU) Decode(pc uint16) (instruction Instruction, bytes []byte, disassem
 		bytes = []byte{opcode, arg1}
 		disassembly = fmt.Sprintf("%s (%02X,X)", instruction.Mnemonic, arg1)
 	case IndirectY:
-		bytes = []byte{opcode, arg2}
+		bytes = []byte{opcode, arg1}
 		disassembly = fmt.Sprintf("%s (%02X),Y", instruction.Mnemonic, arg1)
+	case ZeroPageIndirect:
+		bytes = []byte{opcode, arg1}
+		disassembly = fmt.Sprintf("%s (%02X)", instruction.Mnemonic, arg1)
+	case AbsoluteIndirectX:
+		bytes = []byte{opcode, arg1, arg2}
+		disassembly = fmt.Sprintf("%s (%04X,X)", instruction.Mnemonic, arg16)
+	case ZeroPageRelative:
+		bytes = []byte{opcode, arg1, arg2}
+		disassembly = fmt.Sprintf("%s %02X,%04X", instruction.Mnemonic, arg1, pc+3+uint16(c.toSigned(arg2)))
 	}
 
 	return
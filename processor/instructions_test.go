@@ -5,12 +5,6 @@ import (
 	"testing"
 )
 
-type memoryDiff struct {
-	Address  uint16
-	Expected uint8
-	Actual   uint8
-}
-
 type cpuTestState struct {
 	Cycles    Cycles
 	Registers Registers
@@ -22,22 +16,12 @@ type cpuTestFunc func(cpu *CPU, state *cpuTestState)
 const MemoryTestLocation uint16 = 0xC000
 const AbsoluteTestLocation uint16 = 0xD000
 
-func diffMemory(expected []uint8, actual []uint8) (diffs []memoryDiff) {
-	for address := 0; address < len(expected); address++ {
-		if expected[address] != actual[address] {
-			diffs = append(diffs, memoryDiff{
-				Address:  uint16(address),
-				Expected: expected[address],
-				Actual:   actual[address],
-			})
-		}
-	}
-
-	return
+func testCPU(t *testing.T, testFunc cpuTestFunc) {
+	testCPUVariant(t, Ricoh2A03, testFunc)
 }
 
-func testCPU(t *testing.T, testFunc cpuTestFunc) {
-	cpu := NewCPU()
+func testCPUVariant(t *testing.T, variant Variant, testFunc cpuTestFunc) {
+	cpu := NewCPU(WithVariant(variant))
 	expectedState := &cpuTestState{
 		Cycles:    cpu.TotalCycles,
 		Registers: cpu.Registers,
@@ -45,18 +29,18 @@ func testCPU(t *testing.T, testFunc cpuTestFunc) {
 	}
 
 	testFunc(cpu, expectedState)
-	cpu.Execute()
+	assert.NoError(t, cpu.Execute())
 
 	actualState := &cpuTestState{
 		Cycles:    cpu.TotalCycles,
 		Registers: cpu.Registers,
 		Memory:    cpu.Memory.Dump(),
 	}
-	memoryDiff := diffMemory(expectedState.Memory, actualState.Memory)
+	memoryDiff := Snapshot(expectedState.Memory).Diff(actualState.Memory)
 
 	assert.Equal(t, expectedState.Cycles, actualState.Cycles, "unexpected cycle count")
 	assert.EqualValues(t, expectedState.Registers, cpu.Registers, "unexpected cpu registers")
-	assert.Emptyf(t, memoryDiff, "unexpected memory changes: %+v", memoryDiff)
+	assert.Emptyf(t, memoryDiff, "unexpected memory changes: %v", memoryDiff)
 }
 
 func (s *cpuTestState) expectFlag(flag Status, isEnabled bool) {
@@ -84,7 +68,7 @@ func testCommon(cpu *CPU, state *cpuTestState, opcode uint8) {
 	// verify
 	state.Registers.PC = MemoryTestLocation + 1
 	state.Memory[cpu.Registers.PC] = opcode
-	if instruction, ok := cpu.instructions[Opcode(opcode)]; ok {
+	if instruction, ok := cpu.instructions.lookup(Opcode(opcode)); ok {
 		state.Cycles = instruction.Variant.StaticCycles
 	}
 }
@@ -951,17 +935,17 @@ func TestBRK(t *testing.T) {
 	testCPU(t, func(cpu *CPU, state *cpuTestState) {
 		// prepare
 		testImplicit(cpu, state, 0x00)
-		cpu.Memory.Poke16(ResetVector, 0x1234)
+		cpu.Registers.P &^= FlagInterruptDisable
+		cpu.Memory.Poke16(IRQVector, 0x1234)
 
 		// verify
-		previousPC := state.Registers.PC
+		previousPC := state.Registers.PC + 1
 		state.Registers.PC = 0x1234
-		state.Registers.P |= FlagInterruptDisable
 		state.Registers.S -= 3
-		state.Memory[ResetVector] = 0x34
-		state.Memory[ResetVector+1] = 0x12
+		state.Memory[IRQVector] = 0x34
+		state.Memory[IRQVector+1] = 0x12
 		state.expectStack16(0xFC, previousPC)
-		state.expectStack(0xFB, uint8(state.Registers.P|FlagBreak))
+		state.expectStack(0xFB, uint8((state.Registers.P|FlagBreak)&^FlagInterruptDisable))
 	})
 }
 
@@ -1159,3 +1143,197 @@ func TestSBC(t *testing.T) {
 	testSBC(0x10, 0x20, 0xEF, false, false, false, true)
 	testSBC(0x80, 0x01, 0x7E, true, false, true, false)
 }
+
+func TestOpcodeTable(t *testing.T) {
+	cpu := NewCPU()
+	for opcode := 0; opcode <= 0xFF; opcode++ {
+		_, ok := cpu.instructions.lookup(Opcode(opcode))
+		assert.Truef(t, ok, "missing opcode 0x%02X", opcode)
+	}
+
+	assert.False(t, cpu.instructions[0xE9].Unofficial, "expected SBC 0xE9 to be official")
+	assert.True(t, cpu.instructions[0xEB].Unofficial, "expected SBC 0xEB to be unofficial")
+	assert.False(t, NewCPU(WithVariant(WDC65C02)).instructions[0x1A].Unofficial, "expected 65C02 opcodes to be official")
+}
+
+func TestANC(t *testing.T) {
+	testANC := func(a uint8, b uint8, result uint8, isCarry bool, isZero bool, isNegative bool) {
+		testCPU(t, func(cpu *CPU, state *cpuTestState) {
+			// prepare
+			testImmediate(cpu, state, 0x0B, b)
+			cpu.Registers.A = a
+
+			// verify
+			state.Registers.A = result
+			state.expectFlag(FlagCarry, isCarry)
+			state.expectFlag(FlagZero, isZero)
+			state.expectFlag(FlagNegative, isNegative)
+		})
+	}
+
+	testANC(0x0F, 0x03, 0x03, false, false, false)
+	testANC(0xF0, 0x0F, 0x00, false, true, false)
+	testANC(0xF0, 0x80, 0x80, true, false, true)
+}
+
+func TestALR(t *testing.T) {
+	testALR := func(a uint8, b uint8, result uint8, isCarry bool, isZero bool) {
+		testCPU(t, func(cpu *CPU, state *cpuTestState) {
+			// prepare
+			testImmediate(cpu, state, 0x4B, b)
+			cpu.Registers.A = a
+
+			// verify
+			state.Registers.A = result
+			state.expectFlag(FlagCarry, isCarry)
+			state.expectFlag(FlagZero, isZero)
+			state.expectFlag(FlagNegative, false)
+		})
+	}
+
+	testALR(0xFF, 0x84, 0x42, false, false)
+	testALR(0xFF, 0x01, 0x00, true, true)
+	testALR(0x0F, 0xF3, 0x01, true, false)
+}
+
+func TestARR(t *testing.T) {
+	testARR := func(a uint8, b uint8, carry bool, result uint8, isCarry bool, isOverflow bool, isNegative bool) {
+		testCPU(t, func(cpu *CPU, state *cpuTestState) {
+			// prepare
+			testImmediate(cpu, state, 0x6B, b)
+			cpu.Registers.A = a
+			if carry {
+				cpu.Registers.P |= FlagCarry
+			}
+
+			// verify
+			state.Registers.A = result
+			state.expectFlag(FlagCarry, isCarry)
+			state.expectFlag(FlagZero, result == 0)
+			state.expectFlag(FlagOverflow, isOverflow)
+			state.expectFlag(FlagNegative, isNegative)
+		})
+	}
+
+	testARR(0xFF, 0xC0, false, 0x60, true, false, false)
+	testARR(0xFF, 0x40, false, 0x20, false, true, false)
+	testARR(0xFF, 0x80, true, 0xC0, true, true, true)
+	testARR(0xFF, 0x01, false, 0x00, false, false, false)
+}
+
+func TestAXS(t *testing.T) {
+	testAXS := func(a uint8, x uint8, b uint8, result uint8, isCarry bool, isZero bool, isNegative bool) {
+		testCPU(t, func(cpu *CPU, state *cpuTestState) {
+			// prepare
+			testImmediate(cpu, state, 0xCB, b)
+			cpu.Registers.A = a
+			cpu.Registers.X = x
+
+			// verify
+			state.Registers.A = a
+			state.Registers.X = result
+			state.expectFlag(FlagCarry, isCarry)
+			state.expectFlag(FlagZero, isZero)
+			state.expectFlag(FlagNegative, isNegative)
+		})
+	}
+
+	testAXS(0xFF, 0x0F, 0x01, 0x0E, true, false, false)
+	testAXS(0xF0, 0x3F, 0x30, 0x00, true, true, false)
+	testAXS(0xFF, 0x01, 0x02, 0xFF, false, false, true)
+}
+
+func TestLAS(t *testing.T) {
+	testCPU(t, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testCommon(cpu, state, 0xBB)
+		cpu.Memory.Poke16(cpu.Registers.PC+1, AbsoluteTestLocation)
+		cpu.Memory.Poke(AbsoluteTestLocation, 0xF3)
+		cpu.Registers.S = 0x3F
+
+		// verify
+		state.Registers.PC += 2
+		state.Memory[cpu.Registers.PC+1] = uint8(AbsoluteTestLocation & 0xFF)
+		state.Memory[cpu.Registers.PC+2] = uint8((AbsoluteTestLocation >> 8) & 0xFF)
+		state.Memory[AbsoluteTestLocation] = 0xF3
+		state.Registers.A = 0x33
+		state.Registers.X = 0x33
+		state.Registers.S = 0x33
+	})
+}
+
+func TestXAA(t *testing.T) {
+	testXAA := func(magic uint8, a uint8, x uint8, b uint8, result uint8) {
+		testCPU(t, func(cpu *CPU, state *cpuTestState) {
+			// prepare
+			testImmediate(cpu, state, 0x8B, b)
+			cpu.MagicConstant = magic
+			cpu.Registers.A = a
+			cpu.Registers.X = x
+
+			// verify
+			state.Registers.A = result
+			state.Registers.X = x
+			state.expectFlag(FlagZero, result == 0)
+			state.expectFlag(FlagNegative, result&0x80 == 0x80)
+		})
+	}
+
+	testXAA(0xEE, 0x00, 0xFF, 0xFF, 0xEE)
+	testXAA(0xFF, 0x00, 0x0F, 0xFC, 0x0C)
+	testXAA(0x00, 0x01, 0xFF, 0xFF, 0x01)
+}
+
+func TestSHX(t *testing.T) {
+	testSHX := func(baseAddress uint16, y uint8, address uint16, result uint8) {
+		testCPU(t, func(cpu *CPU, state *cpuTestState) {
+			// prepare
+			testAbsoluteDirect(cpu, state, 0x9E, baseAddress)
+			cpu.Registers.X = 0xFF
+			cpu.Registers.Y = y
+
+			// verify
+			state.Registers.X = 0xFF
+			state.Registers.Y = y
+			state.Memory[address] = result
+		})
+	}
+
+	testSHX(0x1210, 0x10, 0x1220, 0x13)
+	testSHX(0x12F0, 0x20, 0x1310, 0x13)
+}
+
+func TestSHY(t *testing.T) {
+	testCPU(t, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testAbsoluteDirect(cpu, state, 0x9C, 0x02F0)
+		cpu.Registers.X = 0x20
+		cpu.Registers.Y = 0xFF
+
+		// verify
+		state.Registers.X = 0x20
+		state.Registers.Y = 0xFF
+		state.Memory[0x0310] = 0x03
+	})
+}
+
+func TestDecodeIndirectY(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Memory.Poke(MemoryTestLocation, 0xB1)
+	cpu.Memory.Poke(MemoryTestLocation+1, 0x10)
+	cpu.Memory.Poke(MemoryTestLocation+2, 0x20)
+
+	_, bytes, disassembly := cpu.Decode(MemoryTestLocation)
+	assert.Equal(t, []byte{0xB1, 0x10}, bytes, "unexpected instruction bytes")
+	assert.Equal(t, "LDA (10),Y", disassembly, "unexpected disassembly")
+}
+
+func TestDecodeRelative(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Memory.Poke(MemoryTestLocation, 0xD0)
+	cpu.Memory.Poke(MemoryTestLocation+1, 0xFE)
+
+	_, bytes, disassembly := cpu.Decode(MemoryTestLocation)
+	assert.Equal(t, []byte{0xD0, 0xFE}, bytes, "unexpected instruction bytes")
+	assert.Equal(t, "BNE C000", disassembly, "expected branch target to be shown")
+}
@@ -0,0 +1,159 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// The following tests mirror the expectations of blargg's cpu_interrupts_v2 test ROMs
+
+// atCycle calls the handler during the given cycle, which is counted from the next instruction on
+func atCycle(cpu *CPU, cycle Cycles, handler func()) {
+	start := cpu.TotalCycles
+	cpu.OnCycle = func(cycles Cycles) {
+		if cycles-start == cycle {
+			handler()
+		}
+	}
+}
+
+func loadInterruptProgram(cpu *CPU, address uint16, program ...uint8) {
+	for i, value := range program {
+		cpu.Memory.Poke(address+uint16(i), value)
+	}
+	cpu.Memory.Poke(0x1000, 0xEA)
+	cpu.Memory.Poke(0x3000, 0xEA)
+}
+
+func TestCLILatency(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.Registers.P |= FlagInterruptDisable
+	loadInterruptProgram(cpu, MemoryTestLocation, 0x58, 0xEA, 0xEA) // CLI; NOP; NOP
+	cpu.SetIRQ(true)
+
+	cpu.Execute()
+	cpu.Execute()
+	assert.Equal(t, MemoryTestLocation+2, cpu.Registers.PC, "expected instruction after CLI to be executed")
+	cpu.Execute()
+	assert.Equal(t, uint16(0x3000), cpu.Registers.PC, "expected irq after instruction following CLI")
+	assert.Equal(t, MemoryTestLocation+2, cpu.Memory.Peek16(0x01FC), "unexpected return address")
+}
+
+func TestSEILatency(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	loadInterruptProgram(cpu, MemoryTestLocation, 0x78, 0xEA) // SEI; NOP
+	atCycle(cpu, 1, func() { cpu.SetIRQ(true) })
+
+	cpu.Execute()
+	cpu.Execute()
+	assert.Equal(t, uint16(0x3000), cpu.Registers.PC, "expected irq right after SEI")
+	assert.Equal(t, FlagInterruptDisable, Status(cpu.Memory.Peek(0x01FB))&FlagInterruptDisable, "expected pushed status to have interrupts disabled")
+}
+
+func TestPLPLatency(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.Registers.P |= FlagInterruptDisable
+	cpu.Registers.S = 0xFC
+	cpu.Memory.Poke(0x01FD, uint8(FlagUnused))
+	loadInterruptProgram(cpu, MemoryTestLocation, 0x28, 0xEA, 0xEA) // PLP; NOP; NOP
+	cpu.SetIRQ(true)
+
+	cpu.Execute()
+	cpu.Execute()
+	assert.Equal(t, MemoryTestLocation+2, cpu.Registers.PC, "expected instruction after PLP to be executed")
+	cpu.Execute()
+	assert.Equal(t, uint16(0x3000), cpu.Registers.PC, "expected irq after instruction following PLP")
+}
+
+func TestRTINoLatency(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.Registers.P |= FlagInterruptDisable
+	cpu.Registers.S = 0xFA
+	cpu.Memory.Poke(0x01FB, uint8(FlagUnused))
+	cpu.Memory.Poke16(0x01FC, 0xC100)
+	loadInterruptProgram(cpu, MemoryTestLocation, 0x40) // RTI
+	cpu.SetIRQ(true)
+
+	cpu.Execute()
+	cpu.Execute()
+	assert.Equal(t, uint16(0x3000), cpu.Registers.PC, "expected irq right after RTI")
+	assert.Equal(t, uint16(0xC100), cpu.Memory.Peek16(0x01FC), "unexpected return address")
+}
+
+func TestIRQPolledBeforeLastCycle(t *testing.T) {
+	testIRQ := func(cycle Cycles, expectedPC uint16) {
+		cpu := newInterruptTestCPU()
+		loadInterruptProgram(cpu, MemoryTestLocation, 0xEA, 0xEA) // NOP; NOP
+		atCycle(cpu, cycle, func() { cpu.SetIRQ(true) })
+
+		cpu.Execute()
+		cpu.Execute()
+		assert.Equal(t, expectedPC, cpu.Registers.PC, "unexpected program counter for irq during cycle %d", cycle)
+	}
+
+	testIRQ(1, 0x3000)
+	testIRQ(2, MemoryTestLocation+2)
+}
+
+func TestBranchDelaysIRQ(t *testing.T) {
+	testBranch := func(address uint16, offset uint8, cycle Cycles, expectedPC uint16) {
+		cpu := newInterruptTestCPU()
+		cpu.Registers.PC = address
+		cpu.Registers.P |= FlagZero
+		target := address + 2 + uint16(offset)
+		loadInterruptProgram(cpu, address, 0xF0, offset) // BEQ
+		cpu.Memory.Poke(target, 0xEA)
+		atCycle(cpu, cycle, func() { cpu.SetIRQ(true) })
+
+		cpu.Execute()
+		cpu.Execute()
+		assert.Equal(t, expectedPC, cpu.Registers.PC, "unexpected program counter for irq during cycle %d of branch at $%04X", cycle, address)
+	}
+
+	// Taken branches without page crossing only poll during their first cycle
+	testBranch(MemoryTestLocation, 0x00, 1, 0x3000)
+	testBranch(MemoryTestLocation, 0x00, 2, MemoryTestLocation+3)
+
+	// Crossing a page polls during the third cycle again
+	testBranch(0xC0FD, 0x01, 2, 0x3000)
+	testBranch(0xC0FD, 0x01, 3, 0x3000)
+	testBranch(0xC0FD, 0x01, 4, 0xC101)
+}
+
+func TestNMIHijacksBRK(t *testing.T) {
+	testBRK := func(cycle Cycles, expectedPC uint16) {
+		cpu := newInterruptTestCPU()
+		loadInterruptProgram(cpu, MemoryTestLocation, 0x00, 0x00) // BRK
+		atCycle(cpu, cycle, cpu.TriggerNMI)
+
+		cpu.Execute()
+		assert.Equal(t, expectedPC, cpu.Registers.PC, "unexpected vector for nmi during cycle %d", cycle)
+		assert.Equal(t, FlagBreak, Status(cpu.Memory.Peek(0x01FB))&FlagBreak, "expected pushed status to have break flag set")
+		assert.Equal(t, MemoryTestLocation+2, cpu.Memory.Peek16(0x01FC), "unexpected return address")
+	}
+
+	testBRK(1, 0x1000)
+	testBRK(4, 0x1000)
+	testBRK(5, 0x3000)
+	testBRK(7, 0x3000)
+
+	// An NMI which is too late to hijack BRK is serviced after the first instruction of the handler
+	cpu := newInterruptTestCPU()
+	loadInterruptProgram(cpu, MemoryTestLocation, 0x00, 0x00)
+	atCycle(cpu, 5, cpu.TriggerNMI)
+	cpu.Execute()
+	cpu.Execute()
+	assert.Equal(t, uint16(0x3001), cpu.Registers.PC, "expected first instruction of irq handler to be executed")
+	cpu.Execute()
+	assert.Equal(t, uint16(0x1000), cpu.Registers.PC, "expected nmi to be serviced")
+}
+
+func TestNMIHijacksIRQ(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	cpu.SetIRQ(true)
+	atCycle(cpu, 3, cpu.TriggerNMI)
+
+	cpu.Execute()
+	assert.Equal(t, uint16(0x1000), cpu.Registers.PC, "expected irq to be hijacked by nmi")
+	assert.Equal(t, Status(0), Status(cpu.Memory.Peek(0x01FB))&FlagBreak, "expected pushed status to have break flag cleared")
+}
@@ -0,0 +1,140 @@
+package processor
+
+type mappingTable [DefaultMemorySize >> 8]mappingPage
+
+// mappingPage either belongs to a single mapper as a whole or dispatches by address, which is only allocated if needed
+type mappingPage struct {
+	mapper    mappingEntry
+	addresses *[0x100]mappingEntry
+}
+
+// mappingEntry is the mapper active for an address, followed by the mappings shadowed by it in case it is an overlay
+type mappingEntry struct {
+	mapper    MemoryMapper
+	partial   PartialMapper
+	cacheable CacheableMapper
+	from      uint16
+	to        uint16
+	mirror    uint16
+	shadowed  *mappingEntry
+}
+
+// canonical translates an address into the first window of a mirrored mapping
+func (e *mappingEntry) canonical(address uint16) uint16 {
+	return e.from + (address-e.from)&e.mirror
+}
+
+func (e mappingEntry) clone() mappingEntry {
+	if e.shadowed != nil {
+		shadowed := e.shadowed.clone()
+		e.shadowed = &shadowed
+	}
+	return e
+}
+
+// over returns the entry shadowing the given one
+func (e mappingEntry) over(shadowed mappingEntry) mappingEntry {
+	if shadowed.mapper != nil {
+		e.shadowed = &shadowed
+	}
+	return e
+}
+
+// unlink removes the first occurrence of the mapper from the chain of entries
+func (e *mappingEntry) unlink(mapper MemoryMapper) bool {
+	for entry := e; entry != nil && entry.mapper != nil; entry = entry.shadowed {
+		if entry.mapper != mapper {
+			continue
+		}
+
+		if entry.shadowed != nil {
+			*entry = *entry.shadowed
+		} else {
+			*entry = mappingEntry{}
+		}
+		return true
+	}
+	return false
+}
+
+func (t *mappingTable) lookup(address uint16) *mappingEntry {
+	page := &t[address>>8]
+	if page.addresses != nil {
+		return &page.addresses[address&0xFF]
+	}
+	return &page.mapper
+}
+
+// check returns the first address within the range which is in use by a mapper other than the ignored one
+func (t *mappingTable) check(mapping Mapping, ignored MemoryMapper) (address uint32, existing MemoryMapper, ok bool) {
+	for address = mapping.From; address <= mapping.To; address++ {
+		for entry := t.lookup(uint16(address)); entry != nil && entry.mapper != nil; entry = entry.shadowed {
+			if ignored == nil || entry.mapper != ignored {
+				return address, entry.mapper, false
+			}
+		}
+	}
+	return 0, nil, true
+}
+
+// add maps the range to the mapper, shadowing existing mappings. Pages get only split up into addresses if the range
+// does not cover them completely.
+func (t *mappingTable) add(mapping Mapping, mapper MemoryMapper, overlay bool) {
+	entry := mappingEntry{mapper: mapper, from: uint16(mapping.From), to: uint16(mapping.To), mirror: 0xFFFF}
+	entry.partial, _ = mapper.(PartialMapper)
+	entry.cacheable, _ = mapper.(CacheableMapper)
+	if mapping.Window != 0 {
+		entry.mirror = uint16(mapping.Window - 1)
+	}
+
+	for address := mapping.From; address <= mapping.To; {
+		page := &t[address>>8]
+		last := address | 0xFF
+		if last > mapping.To {
+			last = mapping.To
+		}
+
+		if page.addresses == nil && address&0xFF == 0 && last&0xFF == 0xFF {
+			page.mapper = entry.over(page.mapper)
+			address = last + 1
+			continue
+		}
+
+		page.split()
+		for ; address <= last; address++ {
+			slot := &page.addresses[address&0xFF]
+			*slot = entry.over(*slot)
+		}
+	}
+}
+
+// remove unlinks the mapper from all addresses, returning false if it was not mapped at all
+func (t *mappingTable) remove(mapper MemoryMapper) (removed bool) {
+	for i := range t {
+		page := &t[i]
+		if page.addresses == nil {
+			for page.mapper.unlink(mapper) {
+				removed = true
+			}
+			continue
+		}
+		for j := range page.addresses {
+			for page.addresses[j].unlink(mapper) {
+				removed = true
+			}
+		}
+	}
+	return
+}
+
+func (p *mappingPage) split() {
+	if p.addresses != nil {
+		return
+	}
+
+	p.addresses = &[0x100]mappingEntry{}
+	for i := range p.addresses {
+		p.addresses[i] = p.mapper.clone()
+	}
+	p.mapper = mappingEntry{}
+}
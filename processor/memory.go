@@ -5,9 +5,13 @@ import (
 )
 
 type MappingType int
+
+// Mapping assigns an address range to a mapper. If Window is non-zero, the range consists of mirrors of a window with
+// the given size starting at From and the mapper only ever receives addresses within the first window.
 type Mapping struct {
-	From uint32
-	To   uint32
+	From   uint32
+	To     uint32
+	Window uint32
 }
 
 const DefaultMemorySize = 0x10000
@@ -16,11 +20,14 @@ const (
 	MappingPPU
 )
 
+// Memory and MemoryMapper distinguish between reads performed by the CPU via Peek, which may have side effects like
+// clearing status flags, and reads for debugging purposes via DebugPeek, which must not change any state.
 type Memory interface {
 	Reset()
 	Dump() []uint8
 	Peek(address uint16) (value uint8)
 	Peek16(address uint16) (value uint16)
+	DebugPeek(address uint16) (value uint8)
 	Poke(address uint16, value uint8) (oldValue uint8)
 	Poke16(address uint16, value uint16) (oldValue uint16)
 }
@@ -28,18 +35,46 @@ type Memory interface {
 type MemoryMapper interface {
 	Reset()
 	Peek(address uint16) (value uint8)
+	DebugPeek(address uint16) (value uint8)
 	Poke(address uint16, value uint8) (oldValue uint8)
 	Mappings(mapping MappingType) (peek, poke []Mapping)
 }
 
+// PartialMapper is implemented by devices which only drive some bits of the data bus when being read, like the NES
+// controller ports. All other bits keep the value which was last seen on the bus.
+type PartialMapper interface {
+	MemoryMapper
+	DataMask(address uint16) (mask uint8)
+}
+
+// CacheableMapper is implemented by devices whose reads have no side effects, like ROM and RAM. Only their contents get
+// cached by the decode cache, reads from all other devices always reach Peek.
+type CacheableMapper interface {
+	MemoryMapper
+	Cacheable(address uint16) bool
+}
+
+// BankSwitchingMapper is implemented by devices whose contents change without being written at the affected addresses,
+// like cartridges switching banks. They receive a function to call after every such change when getting mapped.
+type BankSwitchingMapper interface {
+	MemoryMapper
+	SetInvalidate(mappingType MappingType, invalidate func(from, to uint16))
+}
+
 type BasicMemory struct {
 	data []uint8
 }
 
+// MappedMemory dispatches accesses to mappers by page, falling back to dispatching by address for pages which are
+// shared by several mappers. Unmapped accesses go to the base memory, or to the open bus if there is none.
 type MappedMemory struct {
 	Memory
-	peek [DefaultMemorySize]MemoryMapper
-	poke [DefaultMemorySize]MemoryMapper
+	peek mappingTable
+	poke mappingTable
+	bus  uint8
+
+	// generations get incremented whenever the contents of a page might have changed, which invalidates decoded code
+	generations [DefaultMemorySize >> 8]uint32
 }
 
 func NewBasicMemory() *BasicMemory {
@@ -67,6 +102,11 @@ func (m *BasicMemory) Peek(address uint16) (value uint8) {
 	return
 }
 
+func (m *BasicMemory) DebugPeek(address uint16) (value uint8) {
+	value = m.data[address]
+	return
+}
+
 func (m *BasicMemory) Peek16(address uint16) (value uint16) {
 	lowByte := m.data[address]
 	highByte := m.data[address+1]
@@ -91,42 +131,191 @@ func NewMappedMemory(base Memory) *MappedMemory {
 	return &MappedMemory{Memory: base}
 }
 
+// NewBus returns memory without any backing RAM like the NES CPU bus, where reads from addresses not mapped to any
+// device return the last value seen on the data bus and writes to them get lost.
+func NewBus() *MappedMemory {
+	return NewMappedMemory(nil)
+}
+
+// AddMappings maps all ranges requested by the mapper, failing without any changes if one of them is already in use.
 func (m *MappedMemory) AddMappings(mapper MemoryMapper, mappingType MappingType) error {
 	peekMappings, pokeMappings := mapper.Mappings(mappingType)
+	if err := m.checkMappings(mapper, peekMappings, pokeMappings, nil); err != nil {
+		return err
+	}
+
+	m.applyMappings(mapper, mappingType, peekMappings, pokeMappings, false)
+	return nil
+}
+
+// AddOverlay maps all ranges requested by the mapper on top of existing mappings, e.g. for cheat devices or patches.
+// The shadowed mappings become active again once the overlay gets removed.
+func (m *MappedMemory) AddOverlay(mapper MemoryMapper, mappingType MappingType) error {
+	peekMappings, pokeMappings := mapper.Mappings(mappingType)
+	if err := checkWindows(mapper, peekMappings, pokeMappings); err != nil {
+		return err
+	}
+
+	m.applyMappings(mapper, mappingType, peekMappings, pokeMappings, true)
+	return nil
+}
+
+// RemoveMappings detaches the mapper from all addresses it has been mapped to, including overlays.
+func (m *MappedMemory) RemoveMappings(mapper MemoryMapper) error {
+	removedPeek, removedPoke := m.peek.remove(mapper), m.poke.remove(mapper)
+	if !removedPeek && !removedPoke {
+		return fmt.Errorf("can not unmap %v, not mapped", mapper)
+	}
+	if removedPeek {
+		m.Invalidate(0x0000, 0xFFFF)
+	}
+	return nil
+}
+
+// ReplaceMappings removes the old mapper and maps the new one in a single step, e.g. for swapping cartridges. If the
+// new mapper conflicts with any mapping other than those of the old mapper, nothing gets changed.
+func (m *MappedMemory) ReplaceMappings(old, new MemoryMapper, mappingType MappingType) error {
+	peekMappings, pokeMappings := new.Mappings(mappingType)
+	if err := m.checkMappings(new, peekMappings, pokeMappings, old); err != nil {
+		return err
+	}
+	if err := m.RemoveMappings(old); err != nil {
+		return err
+	}
+
+	m.applyMappings(new, mappingType, peekMappings, pokeMappings, false)
+	return nil
+}
+
+// checkMappings returns an error if any of the mappings is invalid or in use by a mapper other than the ignored one
+func (m *MappedMemory) checkMappings(mapper MemoryMapper, peekMappings, pokeMappings []Mapping, ignored MemoryMapper) error {
+	if err := checkWindows(mapper, peekMappings, pokeMappings); err != nil {
+		return err
+	}
 
 	for _, peekMapping := range peekMappings {
-		for address := peekMapping.From; address <= peekMapping.To; address++ {
-			if m.peek[address] != nil {
-				return fmt.Errorf("can not map peek@0x%04X to %v, already in use", address, mapper)
-			}
+		if address, existing, ok := m.peek.check(peekMapping, ignored); !ok {
+			return fmt.Errorf("can not map peek@0x%04X to %v, already in use by %v", address, mapper, existing)
+		}
+	}
+	for _, pokeMapping := range pokeMappings {
+		if address, existing, ok := m.poke.check(pokeMapping, ignored); !ok {
+			return fmt.Errorf("can not map poke@0x%04X to %v, already in use by %v", address, mapper, existing)
+		}
+	}
+	return nil
+}
 
-			m.peek[address] = mapper
+func checkWindows(mapper MemoryMapper, peekMappings, pokeMappings []Mapping) error {
+	for _, mapping := range append(append([]Mapping{}, peekMappings...), pokeMappings...) {
+		if mapping.Window&(mapping.Window-1) != 0 || mapping.Window > DefaultMemorySize {
+			return fmt.Errorf("can not map 0x%04X-0x%04X to %v, window size 0x%X is not a power of two", mapping.From, mapping.To, mapper, mapping.Window)
 		}
 	}
+	return nil
+}
 
+func (m *MappedMemory) applyMappings(mapper MemoryMapper, mappingType MappingType, peekMappings, pokeMappings []Mapping, overlay bool) {
+	for _, peekMapping := range peekMappings {
+		m.peek.add(peekMapping, mapper, overlay)
+		m.Invalidate(uint16(peekMapping.From), uint16(peekMapping.To))
+	}
 	for _, pokeMapping := range pokeMappings {
-		for address := pokeMapping.From; address <= pokeMapping.To; address++ {
-			if m.poke[address] != nil {
-				return fmt.Errorf("can not map poke@0x%04X to %v, already in use", address, mapper)
-			}
+		m.poke.add(pokeMapping, mapper, overlay)
+	}
+	if switching, ok := mapper.(BankSwitchingMapper); ok {
+		switching.SetInvalidate(mappingType, m.Invalidate)
+	}
+}
 
-			m.poke[address] = mapper
-		}
+// Invalidate marks the given address range as changed without a write, so that code decoded from it does not get
+// executed anymore. Mappers implementing BankSwitchingMapper get this function for calling it after switching banks.
+func (m *MappedMemory) Invalidate(from, to uint16) {
+	for page := int(from >> 8); page <= int(to>>8); page++ {
+		m.generations[page]++
 	}
+}
 
-	return nil
+func (m *MappedMemory) Reset() {
+	if m.Memory != nil {
+		m.Memory.Reset()
+	}
+	m.Invalidate(0x0000, 0xFFFF)
+}
+
+// Dump returns the contents of the base memory only, see DumpMapped for the memory as seen by the CPU.
+func (m *MappedMemory) Dump() []uint8 {
+	if m.Memory == nil {
+		return nil
+	}
+	return m.Memory.Dump()
+}
+
+// DumpMapped returns all 64 KiB as currently visible to the CPU, including mapped regions like cartridge ROM. Reading
+// is free of side effects, see DebugPeek.
+func (m *MappedMemory) DumpMapped() []uint8 {
+	data := make([]uint8, DefaultMemorySize)
+	for address := range data {
+		data[address] = m.DebugPeek(uint16(address))
+	}
+	return data
+}
+
+// OpenBus returns the value which was last read from or written to memory.
+func (m *MappedMemory) OpenBus() uint8 {
+	return m.bus
 }
 
 func (m *MappedMemory) Peek(address uint16) (value uint8) {
-	if mapping := m.peek[address]; mapping != nil {
-		value = mapping.Peek(address)
-	} else {
+	switch entry := m.peek.lookup(address); {
+	case entry.partial != nil:
+		address = entry.canonical(address)
+		mask := entry.partial.DataMask(address)
+		value = entry.mapper.Peek(address)&mask | m.bus&^mask
+	case entry.mapper != nil:
+		value = entry.mapper.Peek(entry.canonical(address))
+	case m.Memory != nil:
 		value = m.Memory.Peek(address)
+	default:
+		value = m.bus
+	}
+
+	m.bus = value
+	return
+}
+
+// DebugPeek returns the value the CPU would currently read, without any side effects on mappers or the open bus.
+func (m *MappedMemory) DebugPeek(address uint16) (value uint8) {
+	switch entry := m.peek.lookup(address); {
+	case entry.partial != nil:
+		address = entry.canonical(address)
+		mask := entry.partial.DataMask(address)
+		value = entry.mapper.DebugPeek(address)&mask | m.bus&^mask
+	case entry.mapper != nil:
+		value = entry.mapper.DebugPeek(entry.canonical(address))
+	case m.Memory != nil:
+		value = m.Memory.DebugPeek(address)
+	default:
+		value = m.bus
 	}
+	return
+}
 
+func (m *MappedMemory) DebugPeek16(address uint16) (value uint16) {
+	value = uint16(m.DebugPeek(address)) | uint16(m.DebugPeek(address+1))<<8
 	return
 }
 
+// cacheable returns true if reading the address always yields the same value until the page generation changes, which
+// is not the case for the open bus, devices only driving some data bits or devices with read side effects
+func (m *MappedMemory) cacheable(address uint16) bool {
+	entry := m.peek.lookup(address)
+	if entry.mapper == nil {
+		return m.Memory != nil
+	}
+	return entry.partial == nil && entry.cacheable != nil && entry.cacheable.Cacheable(entry.canonical(address))
+}
+
 func (m *MappedMemory) Peek16(address uint16) (value uint16) {
 	lowByte := m.Peek(address)
 	highByte := m.Peek(address + 1)
@@ -135,17 +324,39 @@ func (m *MappedMemory) Peek16(address uint16) (value uint16) {
 }
 
 func (m *MappedMemory) Poke(address uint16, value uint8) (oldValue uint8) {
-	if mapping := m.poke[address]; mapping != nil {
-		oldValue = mapping.Poke(address, value)
+	m.bus = value
+	if entry := m.poke.lookup(address); entry.mapper != nil {
+		m.invalidateAliases(entry, address)
+		oldValue = entry.mapper.Poke(entry.canonical(address), value)
 	} else {
-		oldValue = m.Memory.Poke(address, value)
+		m.generations[address>>8]++
+		if m.Memory != nil {
+			oldValue = m.Memory.Poke(address, value)
+		}
 	}
 
 	return
 }
 
+// invalidateAliases bumps the generation of every page showing the written address, including all its mirrors.
+func (m *MappedMemory) invalidateAliases(entry *mappingEntry, address uint16) {
+	if entry.mirror == 0xFFFF {
+		m.generations[address>>8]++
+		return
+	}
+
+	// Windows smaller than a page are mirrored within every page of the mapping
+	step := uint32(entry.mirror) + 1
+	if step < 0x100 {
+		step = 0x100
+	}
+	for alias := uint32(entry.canonical(address)); alias <= uint32(entry.to); alias += step {
+		m.generations[alias>>8]++
+	}
+}
+
 func (m *MappedMemory) Poke16(address uint16, value uint16) (oldValue uint16) {
-	oldValue = m.Peek16(oldValue)
+	oldValue = m.DebugPeek16(address)
 	m.Poke(address, uint8(value&0xFF))
 	m.Poke(address+1, uint8((value>>8)&0xFF))
 	return
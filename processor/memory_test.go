@@ -0,0 +1,294 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// testDevice maps a mirrored chunk of memory, optionally driving only some bits of the data bus
+type testDevice struct {
+	data     []uint8
+	mappings []Mapping
+	mask     uint8
+}
+
+func newTestDevice(size int, mappings ...Mapping) *testDevice {
+	return &testDevice{data: make([]uint8, size), mappings: mappings}
+}
+
+func (d *testDevice) Reset() {}
+
+func (d *testDevice) Peek(address uint16) uint8 {
+	return d.data[int(address)%len(d.data)]
+}
+
+func (d *testDevice) DebugPeek(address uint16) uint8 {
+	return d.data[int(address)%len(d.data)]
+}
+
+func (d *testDevice) Cacheable(address uint16) bool {
+	return true
+}
+
+func (d *testDevice) Poke(address uint16, value uint8) (oldValue uint8) {
+	oldValue, d.data[int(address)%len(d.data)] = d.data[int(address)%len(d.data)], value
+	return
+}
+
+func (d *testDevice) Mappings(mappingType MappingType) (peek, poke []Mapping) {
+	return d.mappings, d.mappings
+}
+
+type partialTestDevice struct {
+	*testDevice
+}
+
+func (d partialTestDevice) DataMask(address uint16) uint8 {
+	return d.mask
+}
+
+func TestMappedMemoryPages(t *testing.T) {
+	memory := NewMappedMemory(NewBasicMemory())
+	rom := newTestDevice(0x100, Mapping{From: 0x8000, To: 0x80FF})
+	registers := newTestDevice(0x10, Mapping{From: 0x4000, To: 0x400F})
+	port := newTestDevice(1, Mapping{From: 0x4016, To: 0x4016})
+	assert.NoError(t, memory.AddMappings(rom, MappingCPU))
+	assert.NoError(t, memory.AddMappings(registers, MappingCPU))
+	assert.NoError(t, memory.AddMappings(port, MappingCPU))
+
+	rom.data[0x42], registers.data[0x05], port.data[0] = 0x11, 0x22, 0x33
+	memory.Memory.Poke(0x4010, 0x44)
+	assert.Equal(t, uint8(0x11), memory.Peek(0x8042), "expected page to be dispatched to rom")
+	assert.Equal(t, uint8(0x22), memory.Peek(0x4005), "expected address to be dispatched to registers")
+	assert.Equal(t, uint8(0x33), memory.Peek(0x4016), "expected address to be dispatched to port")
+	assert.Equal(t, uint8(0x44), memory.Peek(0x4010), "expected unmapped address to fall back to base memory")
+
+	err := memory.AddMappings(newTestDevice(1, Mapping{From: 0x7F00, To: 0x8000}), MappingCPU)
+	if assert.Error(t, err, "expected conflict with rom") {
+		assert.Contains(t, err.Error(), "can not map peek@0x8000")
+	}
+	err = memory.AddMappings(newTestDevice(1, Mapping{From: 0x4000, To: 0x40FF}), MappingCPU)
+	assert.Error(t, err, "expected conflict within shared page")
+}
+
+func TestOpenBus(t *testing.T) {
+	memory := NewBus()
+	ram := newTestDevice(0x800, Mapping{From: 0x0000, To: 0x1FFF})
+	assert.NoError(t, memory.AddMappings(ram, MappingCPU))
+
+	memory.Poke(0x0801, 0x5A)
+	assert.Equal(t, uint8(0x5A), memory.Peek(0x0001), "expected mirrored ram to be written")
+	assert.Equal(t, uint8(0x5A), memory.Peek(0x6000), "expected unmapped read to return open bus")
+
+	memory.Poke(0x6000, 0xA5)
+	assert.Equal(t, uint8(0xA5), memory.OpenBus(), "expected write to drive the bus")
+	assert.Equal(t, uint8(0x5A), memory.Peek(0x1001), "expected ram to be unaffected by unmapped write")
+	assert.Equal(t, uint8(0x5A), memory.Peek(0x6000), "expected unmapped write to be lost")
+	assert.Nil(t, memory.Dump(), "expected no base memory to be dumped")
+}
+
+func TestPartialMapper(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Memory = NewBus()
+	ram := newTestDevice(0x800, Mapping{From: 0x0000, To: 0x1FFF})
+	controller := partialTestDevice{newTestDevice(1, Mapping{From: 0x4016, To: 0x4016})}
+	controller.mask = 0x1F
+	assert.NoError(t, cpu.Memory.AddMappings(ram, MappingCPU))
+	assert.NoError(t, cpu.Memory.AddMappings(controller, MappingCPU))
+
+	// The upper bits of controller reads keep the high byte of the address, which was the last value on the bus
+	controller.data[0] = 0xE1
+	copy(ram.data, []uint8{0xAD, 0x16, 0x40}) // LDA $4016
+	cpu.Registers.PC = 0x0000
+	assert.NoError(t, cpu.Execute())
+	assert.Equal(t, uint8(0x41), cpu.Registers.A, "expected controller to only drive the lower bits")
+}
+
+func TestOpenBusExecution(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithDecodeCache()}} {
+		cpu := NewCPU(options...)
+		cpu.Memory = NewBus()
+		ram := newTestDevice(0x800, Mapping{From: 0x0000, To: 0x1FFF})
+		assert.NoError(t, cpu.Memory.AddMappings(ram, MappingCPU))
+
+		// Jumping to an unmapped address executes the high byte of the target as opcode, which is BVC $50 here
+		copy(ram.data, []uint8{0x4C, 0x00, 0x50}) // JMP $5000
+		cpu.Registers.PC = 0x0000
+		assert.NoError(t, cpu.Execute())
+		assert.NoError(t, cpu.Execute())
+		assert.Equal(t, uint16(0x5052), cpu.Registers.PC, "expected open bus to be executed")
+	}
+}
+
+// addressRecorder remembers the addresses it receives
+type addressRecorder struct {
+	*testDevice
+	addresses []uint16
+}
+
+func (r *addressRecorder) Peek(address uint16) uint8 {
+	r.addresses = append(r.addresses, address)
+	return r.testDevice.Peek(address)
+}
+
+func (r *addressRecorder) Poke(address uint16, value uint8) uint8 {
+	r.addresses = append(r.addresses, address)
+	return r.testDevice.Poke(address, value)
+}
+
+func TestMappingWindow(t *testing.T) {
+	memory := NewBus()
+	ram := &addressRecorder{testDevice: newTestDevice(0x800, Mapping{From: 0x0000, To: 0x1FFF, Window: 0x800})}
+	registers := &addressRecorder{testDevice: newTestDevice(8, Mapping{From: 0x2000, To: 0x3FFF, Window: 8})}
+	assert.NoError(t, memory.AddMappings(ram, MappingCPU))
+	assert.NoError(t, memory.AddMappings(registers, MappingCPU))
+
+	memory.Poke(0x0800, 0x12)
+	assert.Equal(t, uint8(0x12), memory.Peek(0x0000), "expected mirrored write to be visible")
+	assert.Equal(t, uint8(0x12), memory.Peek(0x1800), "expected last mirror to be visible")
+	assert.Equal(t, []uint16{0x0000, 0x0000, 0x0000}, ram.addresses, "expected ram to receive canonical addresses")
+
+	memory.Poke(0x3FFE, 0x34)
+	assert.Equal(t, uint8(0x34), memory.Peek(0x200E), "expected mirrored register to be visible")
+	assert.Equal(t, []uint16{0x2006, 0x2006}, registers.addresses, "expected registers to receive canonical addresses")
+
+	err := memory.AddMappings(newTestDevice(3, Mapping{From: 0x6000, To: 0x7FFF, Window: 0x600}), MappingCPU)
+	assert.Error(t, err, "expected error for window size not being a power of two")
+}
+
+func TestMappingWindowDecodeCache(t *testing.T) {
+	cpu := NewCPU(WithDecodeCache())
+	cpu.Memory = NewBus()
+	ram := newTestDevice(0x800, Mapping{From: 0x0000, To: 0x1FFF, Window: 0x800})
+	registers := newTestDevice(8, Mapping{From: 0x2000, To: 0x3FFF, Window: 8})
+	assert.NoError(t, cpu.Memory.AddMappings(ram, MappingCPU))
+	assert.NoError(t, cpu.Memory.AddMappings(registers, MappingCPU))
+
+	// Writing through a mirror modifies the following instruction
+	copy(ram.data[0x300:], []uint8{
+		0xA9, 0x77, // LDA #$77
+		0x8D, 0x06, 0x0B, // STA $0B06
+		0xA2, 0x11, // LDX #$11, operand replaced by $77
+	})
+	cpu.Registers.PC = 0x0300
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, cpu.Execute())
+	}
+	assert.Equal(t, uint8(0x77), cpu.Registers.X, "expected write through mirror to invalidate the decoded instruction")
+
+	// Registers are mirrored within every page, so writes have to invalidate all pages of the mapping
+	generations := cpu.Memory.generations
+	cpu.Memory.Poke(0x2105, 0x42)
+	for page := 0x20; page <= 0x3F; page++ {
+		assert.NotEqual(t, generations[page], cpu.Memory.generations[page], "expected page $%02X to be invalidated", page)
+	}
+	assert.Equal(t, generations[0x40], cpu.Memory.generations[0x40], "expected unrelated page to stay valid")
+}
+
+func TestRemoveMappings(t *testing.T) {
+	memory := NewMappedMemory(NewBasicMemory())
+	memory.Memory.Poke(0x8042, 0x44)
+	rom := newTestDevice(0x100, Mapping{From: 0x8000, To: 0x80FF})
+	rom.data[0x42] = 0x11
+	assert.NoError(t, memory.AddMappings(rom, MappingCPU))
+	assert.Equal(t, uint8(0x11), memory.Peek(0x8042), "expected rom to be mapped")
+
+	assert.NoError(t, memory.RemoveMappings(rom))
+	assert.Equal(t, uint8(0x44), memory.Peek(0x8042), "expected base memory after removing rom")
+	assert.EqualError(t, memory.RemoveMappings(rom), fmt.Sprintf("can not unmap %v, not mapped", rom))
+
+	// Removed ranges can be mapped again
+	assert.NoError(t, memory.AddMappings(rom, MappingCPU))
+}
+
+func TestReplaceMappings(t *testing.T) {
+	memory := NewBus()
+	first := newTestDevice(0x100, Mapping{From: 0x8000, To: 0x80FF})
+	second := newTestDevice(0x100, Mapping{From: 0x8000, To: 0x81FF})
+	registers := newTestDevice(0x10, Mapping{From: 0x4000, To: 0x400F})
+	first.data[0x42], second.data[0x42] = 0x11, 0x22
+	assert.NoError(t, memory.AddMappings(first, MappingCPU))
+	assert.NoError(t, memory.AddMappings(registers, MappingCPU))
+
+	assert.NoError(t, memory.ReplaceMappings(first, second, MappingCPU))
+	assert.Equal(t, uint8(0x22), memory.Peek(0x8042), "expected replacement to be mapped")
+	assert.Equal(t, uint8(0x22), memory.Peek(0x8142), "expected additional range of replacement to be mapped")
+
+	// Conflicts with other mappers leave the existing mappings untouched
+	conflicting := newTestDevice(0x100, Mapping{From: 0x4000, To: 0x40FF})
+	err := memory.ReplaceMappings(second, conflicting, MappingCPU)
+	if assert.Error(t, err, "expected conflict with registers") {
+		assert.Contains(t, err.Error(), "can not map peek@0x4000")
+		assert.Contains(t, err.Error(), fmt.Sprintf("already in use by %v", registers))
+	}
+	assert.Equal(t, uint8(0x22), memory.Peek(0x8042), "expected replaced mapper to stay mapped")
+
+	err = memory.ReplaceMappings(first, conflicting, MappingCPU)
+	assert.Error(t, err, "expected error for replacing unmapped mapper")
+}
+
+func TestAddOverlay(t *testing.T) {
+	memory := NewBus()
+	rom := newTestDevice(0x100, Mapping{From: 0x8000, To: 0x80FF})
+	cheat := newTestDevice(1, Mapping{From: 0x8042, To: 0x8042})
+	patch := newTestDevice(0x100, Mapping{From: 0x8000, To: 0x80FF})
+	rom.data[0x41], rom.data[0x42], cheat.data[0], patch.data[0x42] = 0x11, 0x22, 0x33, 0x44
+	assert.NoError(t, memory.AddMappings(rom, MappingCPU))
+
+	assert.NoError(t, memory.AddOverlay(cheat, MappingCPU))
+	assert.NoError(t, memory.AddOverlay(patch, MappingCPU))
+	assert.Equal(t, uint8(0x44), memory.Peek(0x8042), "expected topmost overlay to be active")
+	assert.Error(t, memory.AddMappings(newTestDevice(1, Mapping{From: 0x8042, To: 0x8042}), MappingCPU), "expected conflict with overlays")
+
+	assert.NoError(t, memory.RemoveMappings(patch))
+	assert.Equal(t, uint8(0x33), memory.Peek(0x8042), "expected cheat to be active again")
+	assert.Equal(t, uint8(0x11), memory.Peek(0x8041), "expected rom next to the cheat")
+
+	assert.NoError(t, memory.RemoveMappings(rom))
+	assert.Equal(t, uint8(0x33), memory.Peek(0x8042), "expected cheat to stay active without rom")
+	assert.NoError(t, memory.RemoveMappings(cheat))
+	assert.Equal(t, uint8(0x33), memory.Peek(0x8042), "expected open bus after removing all mappings")
+	assert.Equal(t, uint8(0x33), memory.Peek(0x8041), "expected open bus after removing all mappings")
+}
+
+func TestDebugPeek(t *testing.T) {
+	memory := NewBus()
+	registers := &addressRecorder{testDevice: newTestDevice(8, Mapping{From: 0x2000, To: 0x3FFF, Window: 8})}
+	controller := partialTestDevice{newTestDevice(1, Mapping{From: 0x4016, To: 0x4016})}
+	registers.data[2], controller.data[0], controller.mask = 0x80, 0xFF, 0x01
+	assert.NoError(t, memory.AddMappings(registers, MappingCPU))
+	assert.NoError(t, memory.AddMappings(controller, MappingCPU))
+
+	memory.Poke(0x6000, 0x40)
+	assert.Equal(t, uint8(0x80), memory.DebugPeek(0x200A), "expected mirrored register value")
+	assert.Equal(t, uint8(0x41), memory.DebugPeek(0x4016), "expected partial value combined with open bus")
+	assert.Equal(t, uint8(0x40), memory.DebugPeek(0x6000), "expected open bus value")
+	assert.Equal(t, uint16(0x0080), memory.DebugPeek16(0x3FFA), "unexpected 16-bit value")
+	assert.Empty(t, registers.addresses, "expected debug reads not to reach peek")
+	assert.Equal(t, uint8(0x40), memory.OpenBus(), "expected debug reads not to drive the bus")
+}
+
+func TestPoke16(t *testing.T) {
+	memory := NewBus()
+	registers := &addressRecorder{testDevice: newTestDevice(8, Mapping{From: 0x2000, To: 0x3FFF, Window: 8})}
+	registers.data[2], registers.data[3] = 0x34, 0x12
+	assert.NoError(t, memory.AddMappings(registers, MappingCPU))
+
+	assert.Equal(t, uint16(0x1234), memory.Poke16(0x200A, 0xBEEF), "expected old value at the written address")
+	assert.Equal(t, []uint16{0x2002, 0x2003}, registers.addresses, "expected only the writes to reach the device")
+	assert.Equal(t, uint16(0xBEEF), memory.DebugPeek16(0x2002), "unexpected written value")
+}
+
+func TestDecodeWithoutSideEffects(t *testing.T) {
+	cpu := NewCPU()
+	recorder := newBusRecorder(cpu)
+	recorder.memory.Poke(MemoryTestLocation, 0x20)
+	cpu.Registers.PC = MemoryTestLocation
+
+	_, bytes, _ := cpu.Decode(MemoryTestLocation)
+	assert.Equal(t, []byte{0x20, 0x00, 0x00}, bytes, "unexpected instruction bytes")
+	cpu.Debug = true
+	cpu.collectState()
+	assert.Empty(t, recorder.accesses, "expected decoding not to access the bus")
+}
@@ -1,7 +1,7 @@
 package processor
 
 type Opcode uint8
-type OpcodeHandler func(mode AddressingMode) (extraCycles Cycles)
+type OpcodeHandler func(mode AddressingMode)
 
 func (c *CPU) setZeroNegative(value uint8) {
 	if value == 0 {
@@ -34,6 +34,27 @@ func (c *CPU) setOverflow(value1 uint16, value2 uint16, result uint16) {
 }
 
 func (c *CPU) addition(value uint8) {
+	if c.decimalMode() {
+		c.decimalAddition(value)
+	} else {
+		c.binaryAddition(value)
+	}
+}
+
+func (c *CPU) subtraction(value uint8) {
+	if c.decimalMode() {
+		c.decimalSubtraction(value)
+	} else {
+		c.binaryAddition(^value)
+	}
+}
+
+func (c *CPU) decimalMode() bool {
+	// The Ricoh 2A03 has the decimal flag, but its BCD circuitry has been disabled
+	return c.variant != Ricoh2A03 && c.Registers.P&FlagDecimal == FlagDecimal
+}
+
+func (c *CPU) binaryAddition(value uint8) {
 	value1 := uint16(c.Registers.A)
 	value2 := uint16(value)
 
@@ -49,18 +70,150 @@ func (c *CPU) addition(value uint8) {
 	c.setOverflow(value1, value2, uint16(result))
 }
 
-func (c *CPU) branch(condition bool, target uint16) (extraCycles Cycles) {
+func (c *CPU) decimalAddition(value uint8) {
+	value1 := uint16(c.Registers.A)
+	value2 := uint16(value)
+	carry := uint16(c.Registers.P & FlagCarry)
+
+	// Add lower nibbles and adjust them into BCD range
+	low := (value1 & 0x0F) + (value2 & 0x0F) + carry
+	if low >= 0x0A {
+		low = ((low + 0x06) & 0x0F) + 0x10
+	}
+	sum := (value1 & 0xF0) + (value2 & 0xF0) + low
+
+	// Negative and overflow are derived from the intermediate result before adjusting the upper nibble
+	signedSum := int16(int8(value1&0xF0)) + int16(int8(value2&0xF0)) + int16(low)
+	isOverflow := signedSum < -128 || signedSum > 127
+	isNegative := sum&0x80 == 0x80
+
+	// Adjust upper nibble into BCD range
+	if sum >= 0xA0 {
+		sum += 0x60
+	}
+	result := uint8(sum & 0xFF)
+
+	// The NMOS 6502 takes the zero flag from the binary sum, while the 65C02 fixed all flags except overflow
+	if c.variant == WDC65C02 {
+		c.setZeroNegative(result)
+	} else {
+		c.setZeroNegative(uint8(value1 + value2 + carry))
+		c.setFlag(FlagNegative, isNegative)
+	}
+	c.setFlag(FlagOverflow, isOverflow)
+	c.setCarry(sum)
+	c.Registers.A = result
+}
+
+func (c *CPU) decimalSubtraction(value uint8) {
+	value1 := int16(c.Registers.A)
+	value2 := int16(value)
+	borrow := 1 - int16(c.Registers.P&FlagCarry)
+
+	// Flags of a decimal subtraction match the binary subtraction, except for N and Z on the 65C02
+	c.binaryAddition(^value)
+
+	low := (value1 & 0x0F) - (value2 & 0x0F) - borrow
+	var result int16
+	if c.variant == WDC65C02 {
+		result = value1 - value2 - borrow
+		if result < 0 {
+			result -= 0x60
+		}
+		if low < 0 {
+			result -= 0x06
+		}
+	} else {
+		if low < 0 {
+			low = ((low - 0x06) & 0x0F) - 0x10
+		}
+		result = (value1 & 0xF0) - (value2 & 0xF0) + low
+		if result < 0 {
+			result -= 0x60
+		}
+	}
+
+	c.Registers.A = uint8(result)
+	if c.variant == WDC65C02 {
+		c.setZeroNegative(c.Registers.A)
+	}
+}
+
+func (c *CPU) setFlag(flag Status, isEnabled bool) {
+	if isEnabled {
+		c.Registers.P |= flag
+	} else {
+		c.Registers.P &^= flag
+	}
+}
+
+func (c *CPU) branch(mode AddressingMode, condition bool) {
+	target, _ := c.lookupAddress(mode)
+	c.branchTo(condition, target)
+}
+
+func (c *CPU) branchTo(condition bool, target uint16) {
 	if !condition {
 		return
 	}
 
+	// Taken branches spend one cycle on reading the next opcode and another one for fixing up the page. Without the
+	// page fixup, interrupts are not polled during the extra cycle, which delays them by another instruction.
+	c.dummyRead(c.Registers.PC)
 	if !SamePage(c.Registers.PC, target) {
-		extraCycles += 2
+		c.dummyRead((c.Registers.PC & 0xFF00) | (target & 0x00FF))
 	} else {
-		extraCycles += 1
+		c.pollLatency = 2
 	}
 
 	c.Registers.PC = target
+}
+
+// readOperand reads the value the addressing mode refers to. Immediate values are part of the instruction, so they get
+// fetched like any other operand byte.
+func (c *CPU) readOperand(mode AddressingMode) (value uint8) {
+	if mode == Immediate {
+		value = c.fetch()
+		return
+	}
+
+	address, _ := c.lookupAddress(mode)
+	value = c.read(address)
+	return
+}
+
+func (c *CPU) writeOperand(mode AddressingMode, value uint8) {
+	address := c.lookupWriteAddress(mode)
+	c.write(address, value)
+}
+
+// modifyOperand starts a read-modify-write cycle, which writes the unmodified value back before the actual result.
+// The 65C02 no longer writes twice and reads the value a second time instead.
+func (c *CPU) modifyOperand(mode AddressingMode) (address uint16, value uint8) {
+	address = c.lookupWriteAddress(mode)
+	value = c.modify(address)
+	return
+}
+
+// modifyShiftOperand behaves like modifyOperand, except that the 65C02 skips the page fixup cycle of shift and
+// rotate instructions using absolute,X addressing if no page boundary gets crossed.
+func (c *CPU) modifyShiftOperand(mode AddressingMode) (address uint16, value uint8) {
+	if c.variant != WDC65C02 || mode != AbsoluteX {
+		return c.modifyOperand(mode)
+	}
+
+	address, _ = c.lookupAddress(mode)
+	value = c.modify(address)
+	return
+}
+
+func (c *CPU) modify(address uint16) (value uint8) {
+	value = c.read(address)
+	if c.variant == WDC65C02 {
+		c.dummyRead(address)
+	} else {
+		c.write(address, value)
+	}
 	return
 }
 
@@ -70,320 +223,298 @@ func (c *CPU) compare(value1 uint8, value2 uint8) {
 	c.setZeroNegative(uint8(result))
 }
 
-func (c *CPU) opAND(mode AddressingMode) (extraCycles Cycles) {
-	address, extraCycles := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opAND(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	c.Registers.A &= value
 	c.setZeroNegative(c.Registers.A)
-	return
 }
 
-func (c *CPU) opORA(mode AddressingMode) (extraCycles Cycles) {
-	address, extraCycles := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opORA(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	c.Registers.A |= value
 	c.setZeroNegative(c.Registers.A)
-	return
 }
 
-func (c *CPU) opEOR(mode AddressingMode) (extraCycles Cycles) {
-	address, extraCycles := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opEOR(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	c.Registers.A ^= value
 	c.setZeroNegative(c.Registers.A)
-	return
 }
 
-func (c *CPU) opINC(mode AddressingMode) (extraCycles Cycles) {
-	address, _ := c.lookupAddress(mode)
-	result := c.Memory.Peek(address) + 1
+func (c *CPU) opINC(mode AddressingMode) {
+	if mode == Accumulator {
+		c.Registers.A++
+		c.setZeroNegative(c.Registers.A)
+		return
+	}
+
+	address, value := c.modifyOperand(mode)
+	result := value + 1
 
-	c.Memory.Poke(address, result)
+	c.write(address, result)
 	c.setZeroNegative(result)
-	return
 }
 
-func (c *CPU) opINX(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opINX(mode AddressingMode) {
 	c.Registers.X++
 	c.setZeroNegative(c.Registers.X)
-	return
 }
 
-func (c *CPU) opINY(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opINY(mode AddressingMode) {
 	c.Registers.Y++
 	c.setZeroNegative(c.Registers.Y)
-	return
 }
 
-func (c *CPU) opDEC(mode AddressingMode) (extraCycles Cycles) {
-	address, _ := c.lookupAddress(mode)
-	result := c.Memory.Peek(address) - 1
+func (c *CPU) opDEC(mode AddressingMode) {
+	if mode == Accumulator {
+		c.Registers.A--
+		c.setZeroNegative(c.Registers.A)
+		return
+	}
+
+	address, value := c.modifyOperand(mode)
+	result := value - 1
 
-	c.Memory.Poke(address, result)
+	c.write(address, result)
 	c.setZeroNegative(result)
-	return
 }
 
-func (c *CPU) opDEX(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opDEX(mode AddressingMode) {
 	c.Registers.X--
 	c.setZeroNegative(c.Registers.X)
-	return
 }
 
-func (c *CPU) opDEY(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opDEY(mode AddressingMode) {
 	c.Registers.Y--
 	c.setZeroNegative(c.Registers.Y)
-	return
 }
 
-func (c *CPU) opCMP(mode AddressingMode) (extraCycles Cycles) {
-	address, extraCycles := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opCMP(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	c.compare(c.Registers.A, value)
-	return
 }
 
-func (c *CPU) opCPX(mode AddressingMode) (extraCycles Cycles) {
-	address, _ := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opCPX(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	c.compare(c.Registers.X, value)
-	return
 }
 
-func (c *CPU) opCPY(mode AddressingMode) (extraCycles Cycles) {
-	address, _ := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opCPY(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	c.compare(c.Registers.Y, value)
-	return
 }
 
-func (c *CPU) opTAX(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opTAX(mode AddressingMode) {
 	c.Registers.X = c.Registers.A
 	c.setZeroNegative(c.Registers.X)
-	return
 }
 
-func (c *CPU) opTXA(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opTXA(mode AddressingMode) {
 	c.Registers.A = c.Registers.X
 	c.setZeroNegative(c.Registers.A)
-	return
 }
 
-func (c *CPU) opTAY(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opTAY(mode AddressingMode) {
 	c.Registers.Y = c.Registers.A
 	c.setZeroNegative(c.Registers.Y)
-	return
 }
 
-func (c *CPU) opTYA(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opTYA(mode AddressingMode) {
 	c.Registers.A = c.Registers.Y
 	c.setZeroNegative(c.Registers.A)
-	return
 }
 
-func (c *CPU) opTSX(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opTSX(mode AddressingMode) {
 	c.Registers.X = c.Registers.S
 	c.setZeroNegative(c.Registers.X)
-	return
 }
 
-func (c *CPU) opTXS(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opTXS(mode AddressingMode) {
 	c.Registers.S = c.Registers.X
-	return
 }
 
-func (c *CPU) opBCS(mode AddressingMode) (extraCycles Cycles) {
-	target, _ := c.lookupAddress(mode)
-	return c.branch(c.Registers.P&FlagCarry == FlagCarry, target)
+func (c *CPU) opBCS(mode AddressingMode) {
+	c.branch(mode, c.Registers.P&FlagCarry == FlagCarry)
 }
 
-func (c *CPU) opBCC(mode AddressingMode) (extraCycles Cycles) {
-	target, _ := c.lookupAddress(mode)
-	return c.branch(c.Registers.P&FlagCarry != FlagCarry, target)
+func (c *CPU) opBCC(mode AddressingMode) {
+	c.branch(mode, c.Registers.P&FlagCarry != FlagCarry)
 }
 
-func (c *CPU) opBEQ(mode AddressingMode) (extraCycles Cycles) {
-	target, _ := c.lookupAddress(mode)
-	return c.branch(c.Registers.P&FlagZero == FlagZero, target)
+func (c *CPU) opBEQ(mode AddressingMode) {
+	c.branch(mode, c.Registers.P&FlagZero == FlagZero)
 }
 
-func (c *CPU) opBNE(mode AddressingMode) (extraCycles Cycles) {
-	target, _ := c.lookupAddress(mode)
-	return c.branch(c.Registers.P&FlagZero != FlagZero, target)
+func (c *CPU) opBNE(mode AddressingMode) {
+	c.branch(mode, c.Registers.P&FlagZero != FlagZero)
 }
 
-func (c *CPU) opBMI(mode AddressingMode) (extraCycles Cycles) {
-	target, _ := c.lookupAddress(mode)
-	return c.branch(c.Registers.P&FlagNegative == FlagNegative, target)
+func (c *CPU) opBMI(mode AddressingMode) {
+	c.branch(mode, c.Registers.P&FlagNegative == FlagNegative)
 }
 
-func (c *CPU) opBPL(mode AddressingMode) (extraCycles Cycles) {
-	target, _ := c.lookupAddress(mode)
-	return c.branch(c.Registers.P&FlagNegative != FlagNegative, target)
+func (c *CPU) opBPL(mode AddressingMode) {
+	c.branch(mode, c.Registers.P&FlagNegative != FlagNegative)
 }
 
-func (c *CPU) opBVS(mode AddressingMode) (extraCycles Cycles) {
-	target, _ := c.lookupAddress(mode)
-	return c.branch(c.Registers.P&FlagOverflow == FlagOverflow, target)
+func (c *CPU) opBVS(mode AddressingMode) {
+	c.branch(mode, c.Registers.P&FlagOverflow == FlagOverflow)
 }
 
-func (c *CPU) opBVC(mode AddressingMode) (extraCycles Cycles) {
-	target, _ := c.lookupAddress(mode)
-	return c.branch(c.Registers.P&FlagOverflow != FlagOverflow, target)
+func (c *CPU) opBVC(mode AddressingMode) {
+	c.branch(mode, c.Registers.P&FlagOverflow != FlagOverflow)
 }
 
-func (c *CPU) opSTA(mode AddressingMode) (extraCycles Cycles) {
-	address, _ := c.lookupAddress(mode)
-	c.Memory.Poke(address, c.Registers.A)
-	return
+func (c *CPU) opSTA(mode AddressingMode) {
+	c.writeOperand(mode, c.Registers.A)
 }
 
-func (c *CPU) opSTX(mode AddressingMode) (extraCycles Cycles) {
-	address, _ := c.lookupAddress(mode)
-	c.Memory.Poke(address, c.Registers.X)
-	return
+func (c *CPU) opSTX(mode AddressingMode) {
+	c.writeOperand(mode, c.Registers.X)
 }
 
-func (c *CPU) opSTY(mode AddressingMode) (extraCycles Cycles) {
-	address, _ := c.lookupAddress(mode)
-	c.Memory.Poke(address, c.Registers.Y)
-	return
+func (c *CPU) opSTY(mode AddressingMode) {
+	c.writeOperand(mode, c.Registers.Y)
 }
 
-func (c *CPU) opCLC(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opCLC(mode AddressingMode) {
 	c.Registers.P &^= FlagCarry
-	return
 }
 
-func (c *CPU) opSEC(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opSEC(mode AddressingMode) {
 	c.Registers.P |= FlagCarry
-	return
 }
 
-func (c *CPU) opCLD(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opCLD(mode AddressingMode) {
 	c.Registers.P &^= FlagDecimal
-	return
 }
 
-func (c *CPU) opSED(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opSED(mode AddressingMode) {
 	c.Registers.P |= FlagDecimal
-	return
 }
 
-func (c *CPU) opCLI(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opCLI(mode AddressingMode) {
+	c.delayInterruptDisable(c.Registers.P)
 	c.Registers.P &^= FlagInterruptDisable
-	return
 }
 
-func (c *CPU) opSEI(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opSEI(mode AddressingMode) {
+	c.delayInterruptDisable(c.Registers.P)
 	c.Registers.P |= FlagInterruptDisable
-	return
 }
 
-func (c *CPU) opCLV(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opCLV(mode AddressingMode) {
 	c.Registers.P &^= FlagOverflow
-	return
 }
 
-func (c *CPU) opLDA(mode AddressingMode) (extraCycles Cycles) {
-	address, extraCycles := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opLDA(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	c.Registers.A = value
 	c.setZeroNegative(c.Registers.A)
-	return
 }
 
-func (c *CPU) opLDX(mode AddressingMode) (extraCycles Cycles) {
-	address, extraCycles := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opLDX(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	c.Registers.X = value
 	c.setZeroNegative(c.Registers.X)
-	return
 }
 
-func (c *CPU) opLDY(mode AddressingMode) (extraCycles Cycles) {
-	address, extraCycles := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opLDY(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	c.Registers.Y = value
 	c.setZeroNegative(c.Registers.Y)
-	return
 }
 
-func (c *CPU) opJMP(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opJMP(mode AddressingMode) {
 	target, _ := c.lookupAddress(mode)
 	c.Registers.PC = target
-	return
 }
 
-func (c *CPU) opJSR(mode AddressingMode) (extraCycles Cycles) {
-	target, _ := c.lookupAddress(mode)
-	c.Push16(c.Registers.PC - 1)
-	c.Registers.PC = target
-	return
+func (c *CPU) opJSR(mode AddressingMode) {
+	// JSR pushes the return address before fetching the high byte of its target
+	callSite, stack := c.Registers.PC-1, c.Registers.S
+	targetLow := uint16(c.fetch())
+	c.readStack()
+	c.push16(c.Registers.PC)
+	targetHigh := uint16(c.readAccess(c.Registers.PC, AccessOperand))
+	c.Registers.PC = targetLow | (targetHigh << 8)
+	c.pushCall(c.Registers.PC, callSite, 0, stack)
 }
 
-func (c *CPU) opRTS(mode AddressingMode) (extraCycles Cycles) {
-	target := c.Pop16() + 1
-	c.Registers.PC = target
-	return
+func (c *CPU) opRTS(mode AddressingMode) {
+	c.readStack()
+	c.Registers.PC = c.pull16()
+	c.fetch()
 }
 
-func (c *CPU) opPHA(mode AddressingMode) (extraCycles Cycles) {
-	c.Push(c.Registers.A)
-	return
+func (c *CPU) opPHA(mode AddressingMode) {
+	c.push(c.Registers.A)
 }
 
-func (c *CPU) opPLA(mode AddressingMode) (extraCycles Cycles) {
-	c.Registers.A = c.Pop()
+func (c *CPU) opPLA(mode AddressingMode) {
+	c.readStack()
+	c.Registers.A = c.pull()
 	c.setZeroNegative(c.Registers.A)
-	return
 }
 
-func (c *CPU) opPHP(mode AddressingMode) (extraCycles Cycles) {
-	c.Push(uint8(c.Registers.P | FlagBreak))
-	return
+func (c *CPU) opPHP(mode AddressingMode) {
+	c.push(uint8(c.Registers.P | FlagBreak))
+}
+
+func (c *CPU) opPLP(mode AddressingMode) {
+	c.readStack()
+	c.delayInterruptDisable(c.Registers.P)
+	c.pullStatus()
 }
 
-func (c *CPU) opPLP(mode AddressingMode) (extraCycles Cycles) {
-	c.Registers.P = Status(c.Pop())
+func (c *CPU) pullStatus() {
+	c.Registers.P = Status(c.pull())
 	c.Registers.P &^= FlagBreak
 	c.Registers.P |= FlagUnused
-	return
 }
 
-func (c *CPU) opNOP(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opNOP(mode AddressingMode) {
 	if mode != Implicit {
-		_, extraCycles = c.lookupAddress(mode)
+		c.readOperand(mode)
 	}
-	return
 }
 
-func (c *CPU) opRTI(mode AddressingMode) (extraCycles Cycles) {
-	c.opPLP(Implicit)
-	c.Registers.PC = c.Pop16()
-	return
+func (c *CPU) opRTI(mode AddressingMode) {
+	c.readStack()
+	c.pullStatus()
+	c.Registers.PC = c.pull16()
 }
 
-func (c *CPU) opBRK(mode AddressingMode) (extraCycles Cycles) {
-	c.Push16(c.Registers.PC)
+func (c *CPU) opBRK(mode AddressingMode) {
+	// Skip padding byte after BRK, which is commonly used as a signature byte
+	callSite, stack := c.Registers.PC-1, c.Registers.S
+	c.Registers.PC++
+
+	// Software interrupts push the status register with the break flag set. Like hardware interrupts, BRK can be
+	// hijacked by an NMI and does not poll for interrupts itself.
+	c.push16(c.Registers.PC)
+	vector := c.hijackVector(IRQVector)
+	c.push(uint8(c.Registers.P | FlagBreak | FlagUnused))
 	c.Registers.P |= FlagInterruptDisable
-	c.Registers.PC = c.Memory.Peek16(ResetVector)
-	c.Push(uint8(c.Registers.P | FlagBreak))
-	return
+	if c.variant == WDC65C02 {
+		c.Registers.P &^= FlagDecimal
+	}
+	c.Registers.PC = c.read16(vector)
+	c.pollLatency = 7
+	c.pushCall(c.Registers.PC, callSite, vector, stack)
 }
 
-func (c *CPU) opBIT(mode AddressingMode) (extraCycles Cycles) {
-	address, _ := c.lookupAddress(mode)
-	value := c.Memory.Peek(address)
+func (c *CPU) opBIT(mode AddressingMode) {
+	value := c.readOperand(mode)
 
 	// Set zero flag if result of AND equals zero
 	result := c.Registers.A & value
@@ -393,6 +524,11 @@ func (c *CPU) opBIT(mode AddressingMode) (extraCycles Cycles) {
 		c.Registers.P &^= FlagZero
 	}
 
+	// Immediate addressing on the 65C02 only affects the zero flag
+	if mode == Immediate {
+		return
+	}
+
 	// Set overflow flag to value of 6th bit
 	if value&0x40 == 0x40 {
 		c.Registers.P |= FlagOverflow
@@ -406,22 +542,9 @@ func (c *CPU) opBIT(mode AddressingMode) (extraCycles Cycles) {
 	} else {
 		c.Registers.P &^= FlagNegative
 	}
-
-	return
 }
 
-func (c *CPU) opROL(mode AddressingMode) (extraCycles Cycles) {
-	var value uint8
-	var address uint16
-
-	// Load value from accumulator or memory
-	if mode == Accumulator {
-		value = c.Registers.A
-	} else {
-		address, _ = c.lookupAddress(mode)
-		value = c.Memory.Peek(address)
-	}
-
+func (c *CPU) rotateLeft(value uint8) uint8 {
 	// Re-use bit 7 as new carry
 	previousCarry := (c.Registers.P & FlagCarry) == FlagCarry
 	if value&0x80 == 0x80 {
@@ -439,28 +562,10 @@ func (c *CPU) opROL(mode AddressingMode) (extraCycles Cycles) {
 	}
 	c.setZeroNegative(value)
 
-	// Store value back into accumulator or memory
-	if mode == Accumulator {
-		c.Registers.A = value
-	} else {
-		c.Memory.Poke(address, value)
-	}
-
-	return
+	return value
 }
 
-func (c *CPU) opROR(mode AddressingMode) (extraCycles Cycles) {
-	var value uint8
-	var address uint16
-
-	// Load value from accumulator or memory
-	if mode == Accumulator {
-		value = c.Registers.A
-	} else {
-		address, _ = c.lookupAddress(mode)
-		value = c.Memory.Peek(address)
-	}
-
+func (c *CPU) rotateRight(value uint8) uint8 {
 	// Re-use bit 0 as new carry
 	previousCarry := (c.Registers.P & FlagCarry) == FlagCarry
 	if value&0x1 == 0x1 {
@@ -469,7 +574,7 @@ func (c *CPU) opROR(mode AddressingMode) (extraCycles Cycles) {
 		c.Registers.P &^= FlagCarry
 	}
 
-	// Shift value to the left and re-add previous carry as bit 7
+	// Shift value to the right and re-add previous carry as bit 7
 	value >>= 1
 	if previousCarry {
 		value |= 0x80
@@ -478,28 +583,10 @@ func (c *CPU) opROR(mode AddressingMode) (extraCycles Cycles) {
 	}
 	c.setZeroNegative(value)
 
-	// Store value back into accumulator or memory
-	if mode == Accumulator {
-		c.Registers.A = value
-	} else {
-		c.Memory.Poke(address, value)
-	}
-
-	return
+	return value
 }
 
-func (c *CPU) opASL(mode AddressingMode) (extraCycles Cycles) {
-	var value uint8
-	var address uint16
-
-	// Load value from accumulator or memory
-	if mode == Accumulator {
-		value = c.Registers.A
-	} else {
-		address, _ = c.lookupAddress(mode)
-		value = c.Memory.Peek(address)
-	}
-
+func (c *CPU) shiftLeft(value uint8) uint8 {
 	// Re-use bit 7 as new carry
 	if value&0x80 == 0x80 {
 		c.Registers.P |= FlagCarry
@@ -511,124 +598,380 @@ func (c *CPU) opASL(mode AddressingMode) (extraCycles Cycles) {
 	value <<= 1
 	c.setZeroNegative(value)
 
-	// Store value back into accumulator or memory
-	if mode == Accumulator {
-		c.Registers.A = value
+	return value
+}
+
+func (c *CPU) shiftRight(value uint8) uint8 {
+	// Re-use bit 0 as new carry
+	if value&0x1 == 0x1 {
+		c.Registers.P |= FlagCarry
 	} else {
-		c.Memory.Poke(address, value)
+		c.Registers.P &^= FlagCarry
 	}
 
-	return
+	// Shift value to the right
+	value >>= 1
+	c.setZeroNegative(value)
+
+	return value
 }
 
-func (c *CPU) opLSR(mode AddressingMode) (extraCycles Cycles) {
-	var value uint8
-	var address uint16
+func (c *CPU) opROL(mode AddressingMode) {
+	if mode == Accumulator {
+		c.Registers.A = c.rotateLeft(c.Registers.A)
+		return
+	}
+
+	address, value := c.modifyShiftOperand(mode)
+	c.write(address, c.rotateLeft(value))
+}
+
+func (c *CPU) opROR(mode AddressingMode) {
+	if mode == Accumulator {
+		c.Registers.A = c.rotateRight(c.Registers.A)
+		return
+	}
 
-	// Load value from accumulator or memory
+	address, value := c.modifyShiftOperand(mode)
+	c.write(address, c.rotateRight(value))
+}
+
+func (c *CPU) opASL(mode AddressingMode) {
 	if mode == Accumulator {
-		value = c.Registers.A
+		c.Registers.A = c.shiftLeft(c.Registers.A)
+		return
+	}
+
+	address, value := c.modifyShiftOperand(mode)
+	c.write(address, c.shiftLeft(value))
+}
+
+func (c *CPU) opLSR(mode AddressingMode) {
+	if mode == Accumulator {
+		c.Registers.A = c.shiftRight(c.Registers.A)
+		return
+	}
+
+	address, value := c.modifyShiftOperand(mode)
+	c.write(address, c.shiftRight(value))
+}
+
+func (c *CPU) opADC(mode AddressingMode) {
+	c.addition(c.readOperand(mode))
+	c.decimalPenalty()
+}
+
+func (c *CPU) opSBC(mode AddressingMode) {
+	c.subtraction(c.readOperand(mode))
+	c.decimalPenalty()
+}
+
+// decimalPenalty spends the extra cycle which the 65C02 needs for correcting flags after decimal arithmetic.
+func (c *CPU) decimalPenalty() {
+	if c.variant == WDC65C02 && c.Registers.P&FlagDecimal == FlagDecimal {
+		c.dummyRead(c.Registers.PC)
+	}
+}
+
+func (c *CPU) opLAX(mode AddressingMode) {
+	value := c.readOperand(mode)
+
+	c.Registers.A = value
+	c.Registers.X = value
+	c.setZeroNegative(value)
+}
+
+func (c *CPU) opSAX(mode AddressingMode) {
+	c.writeOperand(mode, c.Registers.A&c.Registers.X)
+}
+
+func (c *CPU) opDCP(mode AddressingMode) {
+	address, value := c.modifyOperand(mode)
+	value--
+	c.write(address, value)
+	c.compare(c.Registers.A, value)
+}
+
+func (c *CPU) opISC(mode AddressingMode) {
+	address, value := c.modifyOperand(mode)
+	value++
+	c.write(address, value)
+	c.subtraction(value)
+}
+
+func (c *CPU) opSLO(mode AddressingMode) {
+	address, value := c.modifyOperand(mode)
+	value = c.shiftLeft(value)
+	c.write(address, value)
+
+	c.Registers.A |= value
+	c.setZeroNegative(c.Registers.A)
+}
+
+func (c *CPU) opRLA(mode AddressingMode) {
+	address, value := c.modifyOperand(mode)
+	value = c.rotateLeft(value)
+	c.write(address, value)
+
+	c.Registers.A &= value
+	c.setZeroNegative(c.Registers.A)
+}
+
+func (c *CPU) opSRE(mode AddressingMode) {
+	address, value := c.modifyOperand(mode)
+	value = c.shiftRight(value)
+	c.write(address, value)
+
+	c.Registers.A ^= value
+	c.setZeroNegative(c.Registers.A)
+}
+
+func (c *CPU) opRRA(mode AddressingMode) {
+	address, value := c.modifyOperand(mode)
+	value = c.rotateRight(value)
+	c.write(address, value)
+	c.addition(value)
+}
+
+func (c *CPU) opANC(mode AddressingMode) {
+	c.Registers.A &= c.readOperand(mode)
+	c.setZeroNegative(c.Registers.A)
+
+	// Copy negative flag into carry, as if the result had been shifted left
+	if c.Registers.A&0x80 == 0x80 {
+		c.Registers.P |= FlagCarry
 	} else {
-		address, _ = c.lookupAddress(mode)
-		value = c.Memory.Peek(address)
+		c.Registers.P &^= FlagCarry
 	}
+}
 
-	// Re-use bit 0 as new carry
-	if value&0x1 == 0x1 {
+func (c *CPU) opALR(mode AddressingMode) {
+	c.Registers.A &= c.readOperand(mode)
+	c.Registers.A = c.shiftRight(c.Registers.A)
+}
+
+func (c *CPU) opARR(mode AddressingMode) {
+	c.Registers.A &= c.readOperand(mode)
+	if c.decimalMode() {
+		c.decimalARR()
+		return
+	}
+
+	c.Registers.A = c.rotateRight(c.Registers.A)
+
+	// Carry is taken from bit 6 of the result, overflow from bit 6 XOR bit 5
+	if c.Registers.A&0x40 == 0x40 {
 		c.Registers.P |= FlagCarry
 	} else {
 		c.Registers.P &^= FlagCarry
 	}
+	if (c.Registers.A>>6)&0x1 != (c.Registers.A>>5)&0x1 {
+		c.Registers.P |= FlagOverflow
+	} else {
+		c.Registers.P &^= FlagOverflow
+	}
+}
 
-	// Shift value to the right
-	value >>= 1
-	c.setZeroNegative(value)
+// decimalARR implements the BCD fixup which the NMOS 6502 applies to ARR while the decimal flag is set.
+func (c *CPU) decimalARR() {
+	value := c.Registers.A
+	result := value >> 1
+	if c.Registers.P&FlagCarry == FlagCarry {
+		result |= 0x80
+	}
 
-	// Store value back into accumulator or memory
-	if mode == Accumulator {
-		c.Registers.A = value
+	c.setFlag(FlagNegative, c.Registers.P&FlagCarry == FlagCarry)
+	c.setFlag(FlagZero, result == 0)
+	c.setFlag(FlagOverflow, (value^result)&0x40 == 0x40)
+
+	if (value&0x0F)+(value&0x01) > 0x05 {
+		result = (result & 0xF0) | ((result + 0x06) & 0x0F)
+	}
+	if uint16(value&0xF0)+uint16(value&0x10) > 0x50 {
+		result += 0x60
+		c.Registers.P |= FlagCarry
 	} else {
-		c.Memory.Poke(address, value)
+		c.Registers.P &^= FlagCarry
 	}
 
-	return
+	c.Registers.A = result
 }
 
-func (c *CPU) opADC(mode AddressingMode) (extraCycles Cycles) {
-	address, extraCycles := c.lookupAddress(mode)
-	c.addition(c.Memory.Peek(address))
-	return
+func (c *CPU) opAXS(mode AddressingMode) {
+	value := c.readOperand(mode)
+	result := c.Registers.A & c.Registers.X
+
+	c.compare(result, value)
+	c.Registers.X = result - value
 }
 
-func (c *CPU) opSBC(mode AddressingMode) (extraCycles Cycles) {
-	address, extraCycles := c.lookupAddress(mode)
-	c.addition(^c.Memory.Peek(address))
-	return
+func (c *CPU) opLAS(mode AddressingMode) {
+	value := c.readOperand(mode) & c.Registers.S
+
+	c.Registers.A = value
+	c.Registers.X = value
+	c.Registers.S = value
+	c.setZeroNegative(value)
 }
 
-func (c *CPU) opLAX(mode AddressingMode) (extraCycles Cycles) {
-	previousPC := c.Registers.PC
-	extraCycles = c.opLDA(mode) // Re-use extra cycles from LDA opcode, as LAX behaves the same
-	c.Registers.PC = previousPC
-	_ = c.opLDX(mode)
-	return
+func (c *CPU) opLXA(mode AddressingMode) {
+	value := (c.Registers.A | c.MagicConstant) & c.readOperand(mode)
+
+	c.Registers.A = value
+	c.Registers.X = value
+	c.setZeroNegative(value)
 }
 
-func (c *CPU) opSAX(mode AddressingMode) (extraCycles Cycles) {
-	address, _ := c.lookupAddress(mode)
-	c.Memory.Poke(address, c.Registers.A&c.Registers.X)
-	return
+func (c *CPU) opXAA(mode AddressingMode) {
+	c.Registers.A = (c.Registers.A | c.MagicConstant) & c.Registers.X & c.readOperand(mode)
+	c.setZeroNegative(c.Registers.A)
 }
 
-func (c *CPU) opDCP(mode AddressingMode) (extraCycles Cycles) {
-	previousPC := c.Registers.PC
-	_ = c.opDEC(mode)
-	c.Registers.PC = previousPC
-	_ = c.opCMP(mode)
-	return
+func (c *CPU) opSHX(mode AddressingMode) {
+	c.storeHighAnd(mode, c.Registers.X)
 }
 
-func (c *CPU) opISC(mode AddressingMode) (extraCycles Cycles) {
-	previousPC := c.Registers.PC
-	_ = c.opINC(mode)
-	c.Registers.PC = previousPC
-	_ = c.opSBC(mode)
-	return
+func (c *CPU) opSHY(mode AddressingMode) {
+	c.storeHighAnd(mode, c.Registers.Y)
 }
 
-func (c *CPU) opSLO(mode AddressingMode) (extraCycles Cycles) {
-	previousPC := c.Registers.PC
-	_ = c.opASL(mode)
-	c.Registers.PC = previousPC
-	_ = c.opORA(mode)
-	return
+func (c *CPU) opTAS(mode AddressingMode) {
+	c.Registers.S = c.Registers.A & c.Registers.X
+	c.storeHighAnd(mode, c.Registers.S)
 }
 
-func (c *CPU) opRLA(mode AddressingMode) (extraCycles Cycles) {
-	previousPC := c.Registers.PC
-	_ = c.opROL(mode)
-	c.Registers.PC = previousPC
-	_ = c.opAND(mode)
-	return
+func (c *CPU) opAHX(mode AddressingMode) {
+	c.storeHighAnd(mode, c.Registers.A&c.Registers.X)
 }
 
-func (c *CPU) opSRE(mode AddressingMode) (extraCycles Cycles) {
-	previousPC := c.Registers.PC
-	_ = c.opLSR(mode)
-	c.Registers.PC = previousPC
-	_ = c.opEOR(mode)
-	return
+// storeHighAnd implements the unstable store instructions, which AND the stored value with the high byte of the base
+// address plus one. If indexing crosses a page, the stored value also replaces the high byte of the target address.
+func (c *CPU) storeHighAnd(mode AddressingMode, value uint8) {
+	var baseAddress uint16
+	var index uint8
+
+	switch mode {
+	case AbsoluteX:
+		baseAddress, index = c.fetch16(), c.Registers.X
+	case AbsoluteY:
+		baseAddress, index = c.fetch16(), c.Registers.Y
+	case IndirectY:
+		addressPtr := c.fetch()
+		baseLow := uint16(c.read(uint16(addressPtr)))
+		baseHigh := uint16(c.read(uint16(addressPtr + 1)))
+		baseAddress, index = baseLow|(baseHigh<<8), c.Registers.Y
+	}
+
+	address, extraCycles := c.indexAddress(baseAddress, index, true)
+	value &= uint8(baseAddress>>8) + 1
+	if extraCycles > 0 {
+		address = (uint16(value) << 8) | (address & 0x00FF)
+	}
+
+	c.write(address, value)
 }
 
-func (c *CPU) opRRA(mode AddressingMode) (extraCycles Cycles) {
-	previousPC := c.Registers.PC
-	_ = c.opROR(mode)
-	c.Registers.PC = previousPC
-	_ = c.opADC(mode)
-	return
+func (c *CPU) opKIL(mode AddressingMode) {
+	switch c.JamPolicy {
+	case JamNOP:
+	case JamReset:
+		c.Reset()
+	default:
+		c.Halted = true
+	}
 }
 
-func (c *CPU) opKIL(mode AddressingMode) (extraCycles Cycles) {
+func (c *CPU) opSTP(mode AddressingMode) {
 	c.Halted = true
+}
+
+func (c *CPU) opBRA(mode AddressingMode) {
+	c.branch(mode, true)
+}
+
+func (c *CPU) opPHX(mode AddressingMode) {
+	c.push(c.Registers.X)
+}
+
+func (c *CPU) opPLX(mode AddressingMode) {
+	c.readStack()
+	c.Registers.X = c.pull()
+	c.setZeroNegative(c.Registers.X)
+}
+
+func (c *CPU) opPHY(mode AddressingMode) {
+	c.push(c.Registers.Y)
+}
+
+func (c *CPU) opPLY(mode AddressingMode) {
+	c.readStack()
+	c.Registers.Y = c.pull()
+	c.setZeroNegative(c.Registers.Y)
+}
+
+func (c *CPU) opSTZ(mode AddressingMode) {
+	c.writeOperand(mode, 0)
+}
+
+func (c *CPU) opTRB(mode AddressingMode) {
+	address, value := c.modifyOperand(mode)
+	c.setFlag(FlagZero, value&c.Registers.A == 0)
+	c.write(address, value&^c.Registers.A)
+}
+
+func (c *CPU) opTSB(mode AddressingMode) {
+	address, value := c.modifyOperand(mode)
+	c.setFlag(FlagZero, value&c.Registers.A == 0)
+	c.write(address, value|c.Registers.A)
+}
+
+func (c *CPU) opRMB(bit uint8) OpcodeHandler {
+	return func(mode AddressingMode) {
+		address, value := c.modifyOperand(mode)
+		c.write(address, value&^(1<<bit))
+	}
+}
+
+func (c *CPU) opSMB(bit uint8) OpcodeHandler {
+	return func(mode AddressingMode) {
+		address, value := c.modifyOperand(mode)
+		c.write(address, value|(1<<bit))
+	}
+}
+
+func (c *CPU) opBBR(bit uint8) OpcodeHandler {
+	return func(mode AddressingMode) {
+		value := c.readBranchBit(mode)
+		target, _ := c.lookupAddress(Relative)
+		c.branchTo(value&(1<<bit) == 0, target)
+	}
+}
+
+func (c *CPU) opBBS(bit uint8) OpcodeHandler {
+	return func(mode AddressingMode) {
+		value := c.readBranchBit(mode)
+		target, _ := c.lookupAddress(Relative)
+		c.branchTo(value&(1<<bit) != 0, target)
+	}
+}
+
+func (c *CPU) readBranchBit(mode AddressingMode) (value uint8) {
+	address, _ := c.lookupAddress(mode)
+	value = c.read(address)
+	c.dummyRead(address)
 	return
 }
+
+func (c *CPU) opWAI(mode AddressingMode) {
+	c.dummyRead(c.Registers.PC)
+	c.waiting = true
+}
+
+func (c *CPU) opNOP5C(mode AddressingMode) {
+	// Opcode 0x5C of the 65C02 takes eight cycles and keeps the bus busy with reads from the last page
+	address, _ := c.lookupAddress(mode)
+	c.dummyRead(0xFF00 | (address & 0x00FF))
+	for i := 0; i < 4; i++ {
+		c.dummyRead(0xFFFF)
+	}
+}
@@ -0,0 +1,112 @@
+package processor
+
+type StopReason int
+
+const (
+	// StopCondition means that the condition passed to RunUntil was met
+	StopCondition StopReason = iota
+	// StopCycles means that the requested amount of cycles has been consumed
+	StopCycles
+	// StopPC means that the program counter reached the requested address
+	StopPC
+	// StopReturn means that the subroutine or interrupt handler being stepped over or out of has returned
+	StopReturn
+	// StopError means that executing an instruction failed, e.g. because the CPU jammed
+	StopError
+)
+
+// RunUntil executes at least one instruction and stops as soon as the condition is met after an instruction.
+func (c *CPU) RunUntil(condition func(cpu *CPU) bool) (reason StopReason, cycles Cycles, err error) {
+	return c.run(StopCondition, func() bool { return condition(c) })
+}
+
+// RunCycles executes instructions until at least the given amount of cycles has been consumed. As instructions are
+// never interrupted, the last instruction can overshoot the requested amount.
+func (c *CPU) RunCycles(amount Cycles) (reason StopReason, cycles Cycles, err error) {
+	if amount == 0 {
+		return StopCycles, 0, nil
+	}
+
+	target := c.TotalCycles + amount
+	return c.run(StopCycles, func() bool { return c.TotalCycles >= target })
+}
+
+// RunUntilPC executes at least one instruction and stops once the program counter reaches the given address.
+func (c *CPU) RunUntilPC(address uint16) (reason StopReason, cycles Cycles, err error) {
+	return c.run(StopPC, func() bool { return c.Registers.PC == address })
+}
+
+// StepOver executes a single instruction, except for JSR and pending interrupts where execution continues until the
+// subroutine or interrupt handler returned. Returning is detected by the stack pointer going back to its previous
+// depth, so that routines discarding their return address by manipulating the stack stop as well.
+func (c *CPU) StepOver() (reason StopReason, cycles Cycles, err error) {
+	if instruction, ok := c.instructions.lookup(Opcode(c.Memory.DebugPeek(c.Registers.PC))); !c.interruptPending() &&
+		(!ok || instruction.Mnemonic != "JSR") {
+		cycles, err = c.Step()
+		if err != nil {
+			return StopError, cycles, err
+		}
+		return StopReturn, cycles, nil
+	}
+
+	depth := c.Registers.S
+	return c.run(StopReturn, func() bool { return stackDepth(c.Registers.S, depth) >= 0 })
+}
+
+// StepOut executes instructions until the current subroutine or interrupt handler returns, which is detected by an
+// RTS or RTI leaving the stack above its depth at the time StepOut was called.
+func (c *CPU) StepOut() (reason StopReason, cycles Cycles, err error) {
+	depth := c.Registers.S
+	returning := false
+	return c.run(StopReturn, func() bool {
+		return returning && stackDepth(c.Registers.S, depth) > 0
+	}, func() {
+		returning = false
+		if c.interruptPending() {
+			return
+		}
+		if instruction, ok := c.instructions.lookup(Opcode(c.Memory.DebugPeek(c.Registers.PC))); ok {
+			returning = instruction.Mnemonic == "RTS" || instruction.Mnemonic == "RTI"
+		}
+	})
+}
+
+// run executes instructions until done returns true after an instruction, optionally calling before ahead of every
+// instruction
+func (c *CPU) run(reason StopReason, done func() bool, before ...func()) (StopReason, Cycles, error) {
+	start := c.TotalCycles
+	for {
+		for _, handler := range before {
+			handler()
+		}
+
+		if err := c.Execute(); err != nil {
+			return StopError, c.TotalCycles - start, err
+		}
+		if done() {
+			return reason, c.TotalCycles - start, nil
+		}
+	}
+}
+
+// interruptPending returns true if the next call to Execute services an interrupt instead of an instruction
+func (c *CPU) interruptPending() bool {
+	return c.pollNMI() || c.pollIRQ()
+}
+
+func StopReasonName(reason StopReason) string {
+	switch reason {
+	case StopCondition:
+		return "Condition"
+	case StopCycles:
+		return "Cycles"
+	case StopPC:
+		return "PC"
+	case StopReturn:
+		return "Return"
+	case StopError:
+		return "Error"
+	default:
+		return "<unknown>"
+	}
+}
@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newRunTestCPU loads a main loop calling a subroutine at $C010, which itself calls a nested subroutine at $C020
+func newRunTestCPU() *CPU {
+	cpu := NewCPU()
+	cpu.Registers.PC = 0xC000
+	cpu.Registers.P = FlagUnused
+	cpu.Memory.Poke16(NMIVector, 0x1000)
+
+	program := map[uint16][]uint8{
+		0xC000: {0xE8, 0x20, 0x10, 0xC0, 0x4C, 0x00, 0xC0}, // INX; JSR $C010; JMP $C000
+		0xC010: {0xC8, 0x20, 0x20, 0xC0, 0xC8, 0x60},       // INY; JSR $C020; INY; RTS
+		0xC020: {0xEA, 0xEA, 0x60},                         // NOP; NOP; RTS
+		0x1000: {0x40},                                     // RTI
+	}
+	for address, bytes := range program {
+		for i, value := range bytes {
+			cpu.Memory.Poke(address+uint16(i), value)
+		}
+	}
+
+	return cpu
+}
+
+func TestRunCycles(t *testing.T) {
+	cpu := newRunTestCPU()
+	reason, cycles, err := cpu.RunCycles(5)
+	assert.NoError(t, err)
+	assert.Equal(t, StopCycles, reason, "unexpected stop reason")
+	assert.Equal(t, Cycles(8), cycles, "expected JSR to overshoot the requested cycles")
+	assert.Equal(t, uint16(0xC010), cpu.Registers.PC, "unexpected program counter")
+
+	reason, cycles, err = cpu.RunCycles(0)
+	assert.Equal(t, Cycles(0), cycles, "expected no instruction to be executed")
+	assert.Equal(t, uint16(0xC010), cpu.Registers.PC, "unexpected program counter")
+}
+
+func TestRunUntilPC(t *testing.T) {
+	cpu := newRunTestCPU()
+	reason, cycles, err := cpu.RunUntilPC(0xC020)
+	assert.NoError(t, err)
+	assert.Equal(t, StopPC, reason, "unexpected stop reason")
+	assert.Equal(t, Cycles(2+6+2+6), cycles, "unexpected cycles")
+
+	// At least one instruction gets executed, even if the program counter already matches
+	reason, cycles, err = cpu.RunUntilPC(0xC020)
+	assert.Equal(t, uint16(0xC020), cpu.Registers.PC, "unexpected program counter")
+	assert.Equal(t, Cycles(2+2+6+2+6+3+2+6+2+6), cycles, "unexpected cycles for a full loop iteration")
+}
+
+func TestRunUntil(t *testing.T) {
+	cpu := newRunTestCPU()
+	reason, _, err := cpu.RunUntil(func(cpu *CPU) bool { return cpu.Registers.X == 3 })
+	assert.NoError(t, err)
+	assert.Equal(t, StopCondition, reason, "unexpected stop reason")
+	assert.Equal(t, uint8(3), cpu.Registers.X, "unexpected x register")
+	assert.Equal(t, uint8(4), cpu.Registers.Y, "unexpected y register")
+
+	cpu.Memory.Poke(cpu.Registers.PC, 0x02)
+	reason, cycles, err := cpu.RunUntil(func(cpu *CPU) bool { return false })
+	assert.Equal(t, ErrCPUJammed, err, "expected jammed cpu to stop execution")
+	assert.Equal(t, StopError, reason, "unexpected stop reason")
+	assert.NotZero(t, cycles, "expected cycles of jamming instruction")
+}
+
+func TestStepOver(t *testing.T) {
+	cpu := newRunTestCPU()
+	reason, cycles, err := cpu.StepOver()
+	assert.NoError(t, err)
+	assert.Equal(t, StopReturn, reason, "unexpected stop reason")
+	assert.Equal(t, Cycles(2), cycles, "expected single instruction")
+
+	reason, cycles, err = cpu.StepOver()
+	assert.NoError(t, err)
+	assert.Equal(t, StopReturn, reason, "unexpected stop reason")
+	assert.Equal(t, uint16(0xC004), cpu.Registers.PC, "expected subroutine to be stepped over")
+	assert.Equal(t, uint8(0xFD), cpu.Registers.S, "unexpected stack pointer")
+	assert.Equal(t, uint8(2), cpu.Registers.Y, "expected subroutine to be executed")
+	assert.Equal(t, Cycles(6+2+6+2+2+6+2+6), cycles, "unexpected cycles")
+
+	// Pending interrupts are stepped over like a JSR until their handler returned
+	cpu.TriggerNMI()
+	reason, cycles, err = cpu.StepOver()
+	assert.NoError(t, err)
+	assert.Equal(t, StopReturn, reason, "unexpected stop reason")
+	assert.Equal(t, uint16(0xC004), cpu.Registers.PC, "expected interrupt handler to be stepped over")
+	assert.Equal(t, uint8(0xFD), cpu.Registers.S, "unexpected stack pointer")
+	assert.Equal(t, Cycles(7+6), cycles, "unexpected cycles")
+}
+
+func TestStepOut(t *testing.T) {
+	cpu := newRunTestCPU()
+	_, _, err := cpu.RunUntilPC(0xC020)
+	assert.NoError(t, err)
+
+	reason, cycles, err := cpu.StepOut()
+	assert.NoError(t, err)
+	assert.Equal(t, StopReturn, reason, "unexpected stop reason")
+	assert.Equal(t, uint16(0xC014), cpu.Registers.PC, "expected nested subroutine to return")
+	assert.Equal(t, Cycles(2+2+6), cycles, "unexpected cycles")
+
+	// Interrupt handlers running in between must not be mistaken for returning from the subroutine
+	cpu.TriggerNMI()
+	reason, _, err = cpu.StepOut()
+	assert.NoError(t, err)
+	assert.Equal(t, StopReturn, reason, "unexpected stop reason")
+	assert.Equal(t, uint16(0xC004), cpu.Registers.PC, "expected subroutine to return")
+	assert.Equal(t, uint8(0xFD), cpu.Registers.S, "unexpected stack pointer")
+
+	// Stepping out of an interrupt handler stops after its RTI
+	cpu.TriggerNMI()
+	assert.NoError(t, cpu.Execute())
+	reason, _, err = cpu.StepOut()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0xC004), cpu.Registers.PC, "expected interrupt handler to return")
+}
+
+func TestStackWraparound(t *testing.T) {
+	cpu := newRunTestCPU()
+	cpu.Registers.S = 0x01
+
+	// Calling subroutines wraps the stack pointer around from $00 to $FF
+	_, _, err := cpu.RunUntilPC(0xC020)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0xFD), cpu.Registers.S, "expected stack pointer to wrap around")
+	assert.Len(t, cpu.CallStack(), 2, "expected frames to be kept")
+
+	_, _, err = cpu.StepOut()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0xC014), cpu.Registers.PC, "expected nested subroutine to return")
+	_, _, err = cpu.StepOut()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0xC004), cpu.Registers.PC, "expected subroutine to return")
+	assert.Equal(t, uint8(0x01), cpu.Registers.S, "unexpected stack pointer")
+	assert.Empty(t, cpu.CallStack(), "expected all frames to be removed after returning")
+
+	for i := 0; i < 3; i++ {
+		_, _, err = cpu.StepOver()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint16(0xC004), cpu.Registers.PC, "expected subroutine to be stepped over")
+	assert.Equal(t, uint8(0x01), cpu.Registers.S, "unexpected stack pointer")
+}
+
+func TestStopReasonName(t *testing.T) {
+	assert.Equal(t, "Return", StopReasonName(StopReturn))
+	assert.Equal(t, "<unknown>", StopReasonName(StopReason(-1)))
+}
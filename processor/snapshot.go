@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Snapshot is a copy of memory as returned by MappedMemory.DumpMapped, indexed by address.
+type Snapshot []uint8
+
+// Change is a range of consecutive addresses whose values differ between two snapshots, both bounds being inclusive.
+type Change struct {
+	From uint16
+	To   uint16
+	Old  []uint8
+	New  []uint8
+}
+
+// Snapshot captures the CPU-visible memory for comparing it later on.
+func (m *MappedMemory) Snapshot() Snapshot {
+	return Snapshot(m.DumpMapped())
+}
+
+// Diff returns the ranges which changed from this snapshot to the other one in ascending order. Only addresses
+// present in both snapshots are compared.
+func (s Snapshot) Diff(other Snapshot) (changes []Change) {
+	length := len(s)
+	if len(other) < length {
+		length = len(other)
+	}
+
+	for address := 0; address < length; address++ {
+		if s[address] == other[address] {
+			continue
+		}
+
+		from := address
+		for address < length && s[address] != other[address] {
+			address++
+		}
+		changes = append(changes, Change{
+			From: uint16(from),
+			To:   uint16(address - 1),
+			Old:  append([]uint8{}, s[from:address]...),
+			New:  append([]uint8{}, other[from:address]...),
+		})
+	}
+
+	return
+}
+
+func (c Change) String() string {
+	hex := func(values []uint8) string {
+		parts := make([]string, len(values))
+		for i, value := range values {
+			parts[i] = fmt.Sprintf("%02X", value)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	if c.From == c.To {
+		return fmt.Sprintf("$%04X: %s -> %s", c.From, hex(c.Old), hex(c.New))
+	}
+	return fmt.Sprintf("$%04X-$%04X: %s -> %s", c.From, c.To, hex(c.Old), hex(c.New))
+}
@@ -0,0 +1,43 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDumpMapped(t *testing.T) {
+	memory := NewMappedMemory(NewBasicMemory())
+	rom := newTestDevice(0x100, Mapping{From: 0x8000, To: 0x80FF})
+	rom.data[0x10] = 0x42
+	memory.Memory.Poke(0x8010, 0x24)
+	memory.Poke(0x0200, 0x11)
+	assert.NoError(t, memory.AddMappings(rom, MappingCPU))
+
+	data := memory.DumpMapped()
+	assert.Len(t, data, DefaultMemorySize, "expected complete address space")
+	assert.Equal(t, uint8(0x42), data[0x8010], "expected mapped rom contents")
+	assert.Equal(t, uint8(0x11), data[0x0200], "expected base memory contents")
+	assert.Equal(t, uint8(0x24), memory.Dump()[0x8010], "expected dump to only contain base memory")
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	memory := NewMappedMemory(NewBasicMemory())
+	before := memory.Snapshot()
+	memory.Poke(0x0010, 0x01)
+	memory.Poke(0x0011, 0x02)
+	memory.Poke(0x0013, 0x03)
+	memory.Poke(0xFFFF, 0x04)
+	after := memory.Snapshot()
+
+	changes := before.Diff(after)
+	assert.Equal(t, []Change{
+		{From: 0x0010, To: 0x0011, Old: []uint8{0x00, 0x00}, New: []uint8{0x01, 0x02}},
+		{From: 0x0013, To: 0x0013, Old: []uint8{0x00}, New: []uint8{0x03}},
+		{From: 0xFFFF, To: 0xFFFF, Old: []uint8{0x00}, New: []uint8{0x04}},
+	}, changes)
+	assert.Equal(t, "$0010-$0011: 00 00 -> 01 02", changes[0].String())
+	assert.Equal(t, "$0013: 00 -> 03", changes[1].String())
+
+	assert.Empty(t, after.Diff(memory.Snapshot()), "expected no changes")
+	assert.Len(t, after.Diff(before[:0x0012]), 1, "expected only common addresses to be compared")
+}
@@ -0,0 +1,57 @@
+package processor
+
+// ticker runs instructions on a separate goroutine, which pauses ahead of every cycle until the next call of Tick.
+// Both goroutines strictly take turns, so the CPU state is never accessed concurrently.
+type ticker struct {
+	resume chan struct{}
+	paused chan tickResult
+}
+
+// tickResult is sent whenever the instruction goroutine pauses, either ahead of its next cycle or after completing
+type tickResult struct {
+	completed bool
+	err       error
+}
+
+// Tick runs the CPU for a single cycle, which performs exactly one bus access. Unlike OnCycle, which gets called from
+// within Execute, this allows stepping other components like the PPU, mappers or DMA between two bus accesses of the
+// same instruction, while they are free to change interrupt lines or memory. Completed is true once the cycle finished
+// the current instruction or interrupt sequence. Errors are returned by the tick completing the instruction, or
+// without any cycle passing if the instruction can not be started. Ticking is considerably slower than Execute, and
+// the goroutine of an instruction which never gets completed stays blocked.
+func (c *CPU) Tick() (completed bool, err error) {
+	t := c.ticker
+	if t == nil {
+		t = &ticker{resume: make(chan struct{}), paused: make(chan tickResult)}
+		c.ticker = t
+	}
+
+	c.ticked = false
+	defer func() { c.ticked = true }()
+	if !c.ticking {
+		c.ticking = true
+		go c.tickInstruction()
+		if result := <-t.paused; result.completed {
+			c.ticking = false
+			return true, result.err
+		}
+	}
+
+	t.resume <- struct{}{}
+	result := <-t.paused
+	if result.completed {
+		c.ticking = false
+	}
+	return result.completed, result.err
+}
+
+func (c *CPU) tickInstruction() {
+	err := c.execute()
+	c.ticker.paused <- tickResult{completed: true, err: err}
+}
+
+// pause hands control back to Tick ahead of a cycle and waits until the next tick
+func (t *ticker) pause() {
+	t.paused <- tickResult{}
+	<-t.resume
+}
@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTick(t *testing.T) {
+	cpu := newInterruptTestCPU()
+	loadInterruptProgram(cpu, MemoryTestLocation, 0xEE, 0x00, 0x02) // INC $0200
+	cpu.Memory.Poke(0x0200, 0x41)
+
+	// Read-modify-write instructions write the unmodified value during their fifth cycle
+	for i := 0; i < 5; i++ {
+		completed, err := cpu.Tick()
+		assert.NoError(t, err)
+		assert.False(t, completed, "expected instruction to be in progress")
+	}
+	assert.Equal(t, Cycles(5), cpu.TotalCycles, "expected single cycle per tick")
+	assert.Equal(t, uint8(0x41), cpu.Memory.Peek(0x0200), "expected unmodified value to be written back")
+	assert.Equal(t, ErrInstructionInProgress, cpu.Execute(), "expected execute to fail while ticking")
+
+	completed, err := cpu.Tick()
+	assert.NoError(t, err)
+	assert.True(t, completed, "expected instruction to be completed")
+	assert.Equal(t, uint8(0x42), cpu.Memory.Peek(0x0200), "expected modified value to be written")
+	assert.NoError(t, cpu.Execute(), "expected execute to work after completing the instruction")
+
+	cpu.Halted = true
+	completed, err = cpu.Tick()
+	assert.True(t, completed, "expected jammed cpu to complete without cycles")
+	assert.Equal(t, ErrCPUJammed, err, "expected jammed cpu to return error")
+}
+
+func TestTickInterruptTiming(t *testing.T) {
+	program := []uint8{0xAD, 0x00, 0x02, 0xEA, 0xEA, 0xEA} // LDA $0200; NOP; NOP; NOP
+	run := func(cycle Cycles, tick bool) *CPU {
+		cpu := newInterruptTestCPU()
+		loadInterruptProgram(cpu, MemoryTestLocation, program...)
+		if !tick {
+			atCycle(cpu, cycle, cpu.TriggerNMI)
+			for cpu.TotalCycles < 12 {
+				assert.NoError(t, cpu.Execute())
+			}
+			return cpu
+		}
+
+		// Changing the line between two ticks is the same as changing it from the cycle handler of the second one
+		for cpu.TotalCycles < 12 {
+			if cpu.TotalCycles+1 == cycle {
+				cpu.TriggerNMI()
+			}
+			_, err := cpu.Tick()
+			assert.NoError(t, err)
+		}
+		for cpu.ticking {
+			_, err := cpu.Tick()
+			assert.NoError(t, err)
+		}
+		return cpu
+	}
+
+	// Changes before the first tick are treated like changes between two calls of Execute
+	for cycle := Cycles(2); cycle <= 8; cycle++ {
+		expected, cpu := run(cycle, false), run(cycle, true)
+		assert.Equal(t, expected.Registers, cpu.Registers, "unexpected registers for nmi during cycle %d", cycle)
+		assert.Equal(t, expected.TotalCycles, cpu.TotalCycles, "unexpected cycles for nmi during cycle %d", cycle)
+	}
+}
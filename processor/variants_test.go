@@ -0,0 +1,270 @@
+package processor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecimalADC(t *testing.T) {
+	testDecimalADC := func(variant Variant, a uint8, b uint8, carry bool, result uint8, isCarry bool, isZero bool,
+		isOverflow bool, isNegative bool, extraCycles Cycles) {
+		testCPUVariant(t, variant, func(cpu *CPU, state *cpuTestState) {
+			// prepare
+			testImmediate(cpu, state, 0x69, b)
+			cpu.Registers.A = a
+			cpu.Registers.P |= FlagDecimal
+			if carry {
+				cpu.Registers.P |= FlagCarry
+			}
+
+			// verify
+			state.Cycles += extraCycles
+			state.Registers.A = result
+			state.expectFlag(FlagDecimal, true)
+			state.expectFlag(FlagCarry, isCarry)
+			state.expectFlag(FlagZero, isZero)
+			state.expectFlag(FlagOverflow, isOverflow)
+			state.expectFlag(FlagNegative, isNegative)
+		})
+	}
+
+	testDecimalADC(NMOS6502, 0x12, 0x34, false, 0x46, false, false, false, false, 0)
+	testDecimalADC(NMOS6502, 0x58, 0x46, true, 0x05, true, false, true, true, 0)
+	testDecimalADC(NMOS6502, 0x99, 0x01, false, 0x00, true, false, false, true, 0)
+	testDecimalADC(WDC65C02, 0x99, 0x01, false, 0x00, true, true, false, false, 1)
+	testDecimalADC(Ricoh2A03, 0x99, 0x01, false, 0x9A, false, false, false, true, 0)
+}
+
+func TestDecimalSBC(t *testing.T) {
+	testDecimalSBC := func(variant Variant, a uint8, b uint8, carry bool, result uint8, isCarry bool, isZero bool,
+		isOverflow bool, isNegative bool, extraCycles Cycles) {
+		testCPUVariant(t, variant, func(cpu *CPU, state *cpuTestState) {
+			// prepare
+			testImmediate(cpu, state, 0xE9, b)
+			cpu.Registers.A = a
+			cpu.Registers.P |= FlagDecimal
+			if carry {
+				cpu.Registers.P |= FlagCarry
+			}
+
+			// verify
+			state.Cycles += extraCycles
+			state.Registers.A = result
+			state.expectFlag(FlagDecimal, true)
+			state.expectFlag(FlagCarry, isCarry)
+			state.expectFlag(FlagZero, isZero)
+			state.expectFlag(FlagOverflow, isOverflow)
+			state.expectFlag(FlagNegative, isNegative)
+		})
+	}
+
+	testDecimalSBC(NMOS6502, 0x46, 0x12, true, 0x34, true, false, false, false, 0)
+	testDecimalSBC(NMOS6502, 0x40, 0x13, true, 0x27, true, false, false, false, 0)
+	testDecimalSBC(NMOS6502, 0x00, 0x01, true, 0x99, false, false, false, true, 0)
+	testDecimalSBC(WDC65C02, 0x00, 0x01, true, 0x99, false, false, false, true, 1)
+	testDecimalSBC(WDC65C02, 0x01, 0x01, true, 0x00, true, true, false, false, 1)
+}
+
+func TestCMOSSTZ(t *testing.T) {
+	testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testAbsolute(cpu, state, 0x9C, 0x42)
+
+		// verify
+		state.Memory[AbsoluteTestLocation] = 0x00
+	})
+}
+
+func TestCMOSBRA(t *testing.T) {
+	testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testRelative(cpu, state, 0x80, +10)
+
+		// verify
+		state.Registers.PC += 10
+	})
+}
+
+func TestCMOSTSB(t *testing.T) {
+	testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testAbsolute(cpu, state, 0x0C, 0xF0)
+		cpu.Registers.A = 0x0F
+
+		// verify
+		state.Registers.A = 0x0F
+		state.Memory[AbsoluteTestLocation] = 0xFF
+		state.expectFlag(FlagZero, true)
+	})
+}
+
+func TestCMOSTRB(t *testing.T) {
+	testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testAbsolute(cpu, state, 0x1C, 0xFF)
+		cpu.Registers.A = 0x0F
+
+		// verify
+		state.Registers.A = 0x0F
+		state.Memory[AbsoluteTestLocation] = 0xF0
+		state.expectFlag(FlagZero, false)
+	})
+}
+
+func TestCMOSPHX(t *testing.T) {
+	testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testImplicit(cpu, state, 0xDA)
+		cpu.Registers.X = 0x42
+
+		// verify
+		state.Registers.X = 0x42
+		state.Registers.S--
+		state.expectStack(0xFD, 0x42)
+	})
+}
+
+func TestCMOSIncrementAccumulator(t *testing.T) {
+	testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testImplicit(cpu, state, 0x1A)
+		cpu.Registers.A = 0x7F
+
+		// verify
+		state.Registers.A = 0x80
+		state.expectFlag(FlagNegative, true)
+	})
+}
+
+func TestCMOSSingleCycleNOP(t *testing.T) {
+	testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testImplicit(cpu, state, 0x03)
+	})
+}
+
+func TestCMOSSMB(t *testing.T) {
+	testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testImmediate(cpu, state, 0xD7, 0x10)
+		cpu.Memory.Poke(0x0010, 0x01)
+
+		// verify
+		state.Memory[0x0010] = 0x21
+	})
+}
+
+func TestCMOSBBS(t *testing.T) {
+	testBBS := func(value uint8, isSuccessful bool, extraCycles Cycles) {
+		testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+			// prepare
+			testImmediate(cpu, state, 0xAF, 0x10)
+			cpu.Memory.Poke(0x0010, value)
+			cpu.Memory.Poke(MemoryTestLocation+2, 0x10)
+
+			// verify
+			state.Cycles += extraCycles
+			state.Registers.PC++
+			state.Memory[0x0010] = value
+			state.Memory[MemoryTestLocation+2] = 0x10
+			if isSuccessful {
+				state.Registers.PC += 0x10
+			}
+		})
+	}
+
+	testBBS(0x04, true, 1)
+	testBBS(0xFB, false, 0)
+}
+
+func TestCMOSZeroPageIndirect(t *testing.T) {
+	testCPUVariant(t, WDC65C02, func(cpu *CPU, state *cpuTestState) {
+		// prepare
+		testImmediate(cpu, state, 0xB2, 0xFF)
+		cpu.Memory.Poke(0x00FF, 0x00)
+		cpu.Memory.Poke(0x0000, 0xD0)
+		cpu.Memory.Poke(AbsoluteTestLocation, 0x42)
+
+		// verify
+		state.Memory[0x00FF] = 0x00
+		state.Memory[0x0000] = 0xD0
+		state.Memory[AbsoluteTestLocation] = 0x42
+		state.Registers.A = 0x42
+	})
+}
+
+func TestJMPIndirectPageBug(t *testing.T) {
+	testJMPIndirect := func(variant Variant, target uint16) {
+		cpu := NewCPU(WithVariant(variant))
+		cpu.Registers.PC = MemoryTestLocation
+		cpu.Memory.Poke(MemoryTestLocation, 0x6C)
+		cpu.Memory.Poke16(MemoryTestLocation+1, 0x02FF)
+		cpu.Memory.Poke(0x02FF, 0x34)
+		cpu.Memory.Poke(0x0200, 0x56)
+		cpu.Memory.Poke(0x0300, 0x12)
+		cpu.Execute()
+
+		assert.Equal(t, target, cpu.Registers.PC, "unexpected jump target for %s", VariantName(variant))
+	}
+
+	testJMPIndirect(NMOS6502, 0x5634)
+	testJMPIndirect(WDC65C02, 0x1234)
+}
+
+func TestCMOSDecimalClearedByInterrupt(t *testing.T) {
+	cpu := NewCPU(WithVariant(WDC65C02))
+	cpu.Registers.PC = MemoryTestLocation
+	cpu.Registers.P = FlagUnused | FlagDecimal
+	cpu.SetIRQ(true)
+	cpu.Execute()
+
+	assert.Equal(t, Status(0), cpu.Registers.P&FlagDecimal, "expected decimal flag to be cleared")
+}
+
+func TestCMOSWAI(t *testing.T) {
+	cpu := NewCPU(WithVariant(WDC65C02))
+	cpu.Registers.PC = MemoryTestLocation
+	cpu.Memory.Poke(MemoryTestLocation, 0xCB)
+	cpu.Memory.Poke(MemoryTestLocation+1, 0xE8)
+	cpu.Execute()
+	cpu.Execute()
+	assert.Equal(t, MemoryTestLocation+1, cpu.Registers.PC, "expected cpu to wait for interrupt")
+
+	// An IRQ resumes execution even with interrupts disabled
+	cpu.SetIRQ(true)
+	cpu.Execute()
+	assert.Equal(t, MemoryTestLocation+2, cpu.Registers.PC, "expected cpu to resume execution")
+	assert.Equal(t, uint8(0x01), cpu.Registers.X, "expected instruction after WAI to be executed")
+}
+
+func TestStaticCycles(t *testing.T) {
+	for _, variant := range []Variant{Ricoh2A03, NMOS6502, WDC65C02} {
+		for opcode := 0; opcode <= 0xFF; opcode++ {
+			cpu := NewCPU(WithVariant(variant))
+			instruction, ok := cpu.Lookup(Opcode(opcode))
+			mode := instruction.Variant.AddressingMode
+			if !ok || mode == Relative || mode == ZeroPageRelative {
+				continue
+			}
+
+			// Operands and index registers are zero, so that no page boundary gets crossed
+			cpu.Registers.PC = 0x0200
+			cpu.Registers.P = FlagUnused
+			cpu.Memory.Poke(0x0200, uint8(opcode))
+			var accesses Cycles
+			cpu.OnMemoryAccess(func(access MemoryAccess) {
+				accesses++
+			})
+
+			// Every tick performs one bus access, so the static cycles have to match both
+			var ticks Cycles
+			for completed := false; !completed; ticks++ {
+				completed, _ = cpu.Tick()
+			}
+			assert.Equal(t, instruction.Variant.StaticCycles, ticks, "unexpected cycles of %v opcode 0x%02X (%v %v)",
+				VariantName(variant), opcode, instruction.Mnemonic, AddressingModeName(mode))
+			assert.Equal(t, ticks, accesses, "expected one bus access per cycle of %v opcode 0x%02X",
+				VariantName(variant), opcode)
+		}
+	}
+}
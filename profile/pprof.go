@@ -0,0 +1,222 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"nessie/processor"
+	"sort"
+)
+
+// Field numbers of the pprof protobuf messages, see github.com/google/pprof/proto/profile.proto
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileMapping           = 3
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	mappingID           = 1
+	mappingMemoryStart  = 2
+	mappingMemoryLimit  = 3
+	mappingFilename     = 5
+	mappingHasFunctions = 7
+
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	lineFunctionID = 1
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+)
+
+// protobuf is a minimal encoder for the subset of the protobuf wire format used by pprof
+type protobuf struct {
+	data []byte
+}
+
+func (b *protobuf) varint(value uint64) {
+	for value >= 0x80 {
+		b.data = append(b.data, uint8(value)|0x80)
+		value >>= 7
+	}
+	b.data = append(b.data, uint8(value))
+}
+
+func (b *protobuf) number(field int, value uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(value)
+}
+
+func (b *protobuf) boolean(field int, value bool) {
+	if value {
+		b.number(field, 1)
+	}
+}
+
+func (b *protobuf) bytes(field int, value []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(value)))
+	b.data = append(b.data, value...)
+}
+
+func (b *protobuf) packed(field int, values []uint64) {
+	var packed protobuf
+	for _, value := range values {
+		packed.varint(value)
+	}
+	b.bytes(field, packed.data)
+}
+
+func (b *protobuf) message(field int, encode func(message *protobuf)) {
+	var message protobuf
+	encode(&message)
+	b.bytes(field, message.data)
+}
+
+// stringTable deduplicates all strings of a profile, reserving index zero for the empty string as required by pprof
+type stringTable struct {
+	table   []string
+	indices map[string]uint64
+}
+
+func (s *stringTable) index(value string) uint64 {
+	if index, ok := s.indices[value]; ok {
+		return index
+	}
+	index := uint64(len(s.table))
+	s.table = append(s.table, value)
+	s.indices[value] = index
+	return index
+}
+
+// WritePprof writes all samples as a gzip-compressed pprof profile, which can be inspected using `go tool pprof`.
+// Every call stack frame becomes a location at its call site, while the innermost location is the executed
+// instruction. Samples contain both the amount of executed instructions and the cycles spent, the latter being the
+// default sample type.
+func (p *Profiler) WritePprof(writer io.Writer) error {
+	names := &stringTable{table: []string{""}, indices: map[string]uint64{"": 0}}
+	var profile protobuf
+
+	valueType := func(kind string, unit string) func(message *protobuf) {
+		return func(message *protobuf) {
+			message.number(valueTypeType, names.index(kind))
+			message.number(valueTypeUnit, names.index(unit))
+		}
+	}
+	profile.message(profileSampleType, valueType("instructions", "count"))
+	profile.message(profileSampleType, valueType("cycles", "count"))
+
+	profile.message(profileMapping, func(message *protobuf) {
+		message.number(mappingID, 1)
+		message.number(mappingMemoryStart, 0)
+		message.number(mappingMemoryLimit, 0x10000)
+		message.number(mappingFilename, names.index("cpu"))
+		message.boolean(mappingHasFunctions, true)
+	})
+
+	// Locations and functions get numbered in order of appearance, with samples being sorted for a stable output
+	type locationKey struct {
+		code    codeKey
+		routine codeKey
+	}
+	locations := make(map[locationKey]uint64)
+	functions := make(map[codeKey]uint64)
+	var locationMessages, functionMessages protobuf
+
+	function := func(routine codeKey, address uint16, vector uint16) uint64 {
+		if id, ok := functions[routine]; ok {
+			return id
+		}
+		id := uint64(len(functions) + 1)
+		functions[routine] = id
+		name := names.index(p.routineName(routine, address, vector))
+		functionMessages.message(profileFunction, func(message *protobuf) {
+			message.number(functionID, id)
+			message.number(functionName, name)
+			message.number(functionSystemName, name)
+		})
+		return id
+	}
+	location := func(code codeKey, address uint16, routine codeKey, routineAddress uint16, vector uint16) uint64 {
+		key := locationKey{code, routine}
+		if id, ok := locations[key]; ok {
+			return id
+		}
+		id := uint64(len(locations) + 1)
+		locations[key] = id
+		functionID := function(routine, routineAddress, vector)
+		locationMessages.message(profileLocation, func(message *protobuf) {
+			message.number(locationID, id)
+			message.number(locationMappingID, 1)
+			message.number(locationAddress, uint64(address))
+			message.message(locationLine, func(line *protobuf) {
+				line.number(lineFunctionID, functionID)
+			})
+		})
+		return id
+	}
+
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := p.samples[key]
+
+		// Each frame contains the call site within its caller, so every location belongs to the routine below it
+		ids := make([]uint64, 0, len(s.stack)+1)
+		code, address := s.code, s.pc
+		for i := len(s.stack) - 1; i >= 0; i-- {
+			frame := s.stack[i]
+			ids = append(ids, location(code, address, frame.routine, frame.Routine, frame.Vector))
+			code, address = frame.callSite, frame.CallSite
+		}
+		if len(s.stack) == 0 || s.stack[0].Vector != processor.ResetVector {
+			ids = append(ids, location(code, address, rootRoutine, 0, 0))
+		}
+
+		profile.message(profileSample, func(message *protobuf) {
+			message.packed(sampleLocationID, ids)
+			message.packed(sampleValue, []uint64{s.instructions, uint64(s.cycles)})
+		})
+	}
+
+	profile.data = append(profile.data, locationMessages.data...)
+	profile.data = append(profile.data, functionMessages.data...)
+	profile.message(profilePeriodType, valueType("cycles", "count"))
+	profile.number(profilePeriod, 1)
+	profile.number(profileDefaultSampleType, names.index("cycles"))
+	for _, name := range names.table {
+		profile.bytes(profileStringTable, []byte(name))
+	}
+
+	compressor := gzip.NewWriter(writer)
+	if _, err := compressor.Write(profile.data); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+// routineName returns the name of a routine without adding it to the collected routines
+func (p *Profiler) routineName(key codeKey, address uint16, vector uint16) string {
+	if routine, ok := p.routines[key]; ok {
+		return routine.Name
+	}
+	return p.name(key, address, vector)
+}
@@ -0,0 +1,233 @@
+package profile
+
+import (
+	"fmt"
+	"nessie/disasm"
+	"nessie/processor"
+	"sort"
+)
+
+// rootRoutine collects cycles spent while the shadow call stack is empty, e.g. when the CPU has never been reset
+var rootRoutine = codeKey{address: -1}
+
+// codeKey identifies code by its PRG ROM offset if PRGOffset resolves its address and by its CPU address otherwise, so
+// that code of different banks being mapped to the same address is kept apart
+type codeKey struct {
+	prg     bool
+	address int
+}
+
+// Routine contains the cycles attributed to a single subroutine or interrupt handler. Inclusive cycles contain the
+// cycles of all called routines, while exclusive cycles only cover the instructions of the routine itself.
+type Routine struct {
+	Address   uint16
+	Name      string
+	Calls     uint64
+	Inclusive processor.Cycles
+	Exclusive processor.Cycles
+}
+
+type Profiler struct {
+	CPU     *processor.CPU
+	Symbols *disasm.Symbols
+	// PRGOffset optionally translates CPU addresses into PRG ROM offsets, which allows using PRG ROM symbols
+	PRGOffset func(address uint16) (offset int, ok bool)
+
+	routines  map[codeKey]*Routine
+	samples   map[string]*sample
+	stack     []frame
+	pc        uint16
+	code      codeKey
+	calling   bool
+	lastCycle processor.Cycles
+	hookIDs   []processor.HookID
+
+	// Scratch space reused for every instruction, so that profiling does not allocate once all samples exist
+	calls []processor.Frame
+	key   []byte
+	seen  map[codeKey]bool
+}
+
+// frame is an entry of the shadow call stack, whose routine and call site got resolved using the banks mapped while
+// executing the sampled instruction
+type frame struct {
+	processor.Frame
+	routine  codeKey
+	callSite codeKey
+}
+
+// sample aggregates all instructions which got executed with an identical call stack and program counter
+type sample struct {
+	pc           uint16
+	code         codeKey
+	stack        []frame
+	instructions uint64
+	cycles       processor.Cycles
+}
+
+// New attaches a profiler to the given CPU, which keeps profiling until Close gets called. Symbols can be nil.
+func New(cpu *processor.CPU, symbols *disasm.Symbols) *Profiler {
+	p := &Profiler{
+		CPU:       cpu,
+		Symbols:   symbols,
+		routines:  make(map[codeKey]*Routine),
+		samples:   make(map[string]*sample),
+		seen:      make(map[codeKey]bool),
+		lastCycle: cpu.TotalCycles,
+	}
+
+	p.hookIDs = append(p.hookIDs,
+		cpu.OnBeforeInstruction(p.beforeInstruction),
+		cpu.OnAfterInstruction(p.afterInstruction),
+		cpu.OnInterrupt(p.interrupt),
+	)
+	return p
+}
+
+// Close detaches the profiler from its CPU while keeping all results collected so far.
+func (p *Profiler) Close() {
+	for _, id := range p.hookIDs {
+		p.CPU.RemoveHook(id)
+	}
+	p.hookIDs = nil
+}
+
+// Routines returns all routines which have been executed, ordered by their exclusive cycles in descending order.
+func (p *Profiler) Routines() []Routine {
+	routines := make([]Routine, 0, len(p.routines))
+	for _, routine := range p.routines {
+		routines = append(routines, *routine)
+	}
+
+	sort.Slice(routines, func(i, j int) bool {
+		if routines[i].Exclusive != routines[j].Exclusive {
+			return routines[i].Exclusive > routines[j].Exclusive
+		}
+		return routines[i].Address < routines[j].Address
+	})
+	return routines
+}
+
+func (p *Profiler) beforeInstruction(pc uint16, opcode processor.Opcode, instruction *processor.Instruction) {
+	p.pc, p.code = pc, p.resolve(pc)
+	p.calls = p.CPU.AppendCallStack(p.calls[:0])
+	p.stack = p.stack[:0]
+	for _, call := range p.calls {
+		p.stack = append(p.stack, frame{Frame: call, routine: p.resolve(call.Routine), callSite: p.resolve(call.CallSite)})
+	}
+	p.calling = instruction.Mnemonic == "JSR" || instruction.Mnemonic == "BRK"
+}
+
+// afterInstruction attributes all cycles since the previous instruction, which includes interrupt sequences and cycles
+// stolen by DMA, to the call stack at the time the instruction started
+func (p *Profiler) afterInstruction(registers processor.Registers, cycles processor.Cycles) {
+	elapsed := p.CPU.TotalCycles - p.lastCycle
+	p.lastCycle = p.CPU.TotalCycles
+
+	p.key = appendSampleKey(p.key[:0], p.code, p.stack)
+	s, ok := p.samples[string(p.key)]
+	if !ok {
+		s = &sample{pc: p.pc, code: p.code, stack: append([]frame(nil), p.stack...)}
+		p.samples[string(p.key)] = s
+	}
+	s.instructions++
+	s.cycles += elapsed
+
+	if len(p.stack) == 0 {
+		p.routine(rootRoutine, 0, 0).Exclusive += elapsed
+		p.routine(rootRoutine, 0, 0).Inclusive += elapsed
+	} else {
+		top := p.stack[len(p.stack)-1]
+		p.routine(top.routine, top.Routine, top.Vector).Exclusive += elapsed
+
+		// Recursive routines appear multiple times on the stack, but their inclusive cycles must only count once
+		for routine := range p.seen {
+			delete(p.seen, routine)
+		}
+		for _, frame := range p.stack {
+			if !p.seen[frame.routine] {
+				p.seen[frame.routine] = true
+				p.routine(frame.routine, frame.Routine, frame.Vector).Inclusive += elapsed
+			}
+		}
+	}
+
+	if p.calling {
+		if p.calls = p.CPU.AppendCallStack(p.calls[:0]); len(p.calls) > len(p.stack) {
+			top := p.calls[len(p.calls)-1]
+			p.routine(p.resolve(top.Routine), top.Routine, top.Vector).Calls++
+		}
+	}
+}
+
+func (p *Profiler) interrupt(vector uint16) {
+	// Cycles spent before a reset are not attributed to anything
+	if vector == processor.ResetVector {
+		p.lastCycle = p.CPU.TotalCycles
+	}
+	pc := p.CPU.Registers.PC
+	p.routine(p.resolve(pc), pc, vector).Calls++
+}
+
+// resolve returns the key of the code at the given CPU address, using the banks which are currently mapped
+func (p *Profiler) resolve(address uint16) codeKey {
+	if p.PRGOffset != nil {
+		if offset, ok := p.PRGOffset(address); ok {
+			return codeKey{prg: true, address: offset}
+		}
+	}
+	return codeKey{address: int(address)}
+}
+
+func (p *Profiler) routine(key codeKey, address uint16, vector uint16) *Routine {
+	routine, ok := p.routines[key]
+	if !ok {
+		routine = &Routine{Address: address, Name: p.name(key, address, vector)}
+		p.routines[key] = routine
+	}
+	return routine
+}
+
+// name resolves the name of a routine, falling back to the name of the vector for unnamed interrupt handlers
+func (p *Profiler) name(key codeKey, address uint16, vector uint16) string {
+	if key == rootRoutine {
+		return "<root>"
+	}
+
+	if key.prg {
+		if name, ok := p.Symbols.LookupPRG(key.address); ok {
+			return name
+		}
+	}
+	if name, ok := p.Symbols.Lookup(address); ok {
+		return name
+	}
+
+	switch vector {
+	case processor.NMIVector:
+		return fmt.Sprintf("NMI_%04X", address)
+	case processor.ResetVector:
+		return fmt.Sprintf("RESET_%04X", address)
+	case processor.IRQVector:
+		return fmt.Sprintf("IRQ_%04X", address)
+	default:
+		return fmt.Sprintf("L_%04X", address)
+	}
+}
+
+func appendSampleKey(key []byte, pc codeKey, stack []frame) []byte {
+	key = appendCodeKey(key, pc)
+	for _, frame := range stack {
+		key = appendCodeKey(appendCodeKey(key, frame.routine), frame.callSite)
+	}
+	return key
+}
+
+// appendCodeKey encodes the key into four bytes, PRG ROM offsets are distinguished from CPU addresses by the top bit
+func appendCodeKey(key []byte, code codeKey) []byte {
+	value := uint32(code.address)
+	if code.prg {
+		value |= 1 << 31
+	}
+	return append(key, uint8(value), uint8(value>>8), uint8(value>>16), uint8(value>>24))
+}
@@ -0,0 +1,209 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"nessie/asm"
+	"nessie/disasm"
+	"nessie/processor"
+	"testing"
+)
+
+const testProgram = `
+.org $C000
+reset:
+  JSR update
+  JSR draw
+  KIL
+update:
+  JSR $C020
+  RTS
+
+.org $C020
+  NOP
+  NOP
+  RTS
+draw:
+  LDX #3
+@loop:
+  DEX
+  BNE @loop
+  RTS
+
+.org $FFFC
+  .word reset
+`
+
+func newTestProfiler() *Profiler {
+	cpu := processor.NewCPU()
+	program := asm.MustAssemble(testProgram)
+	program.Load(cpu.Memory)
+
+	symbols := disasm.NewSymbols()
+	for _, name := range []string{"reset", "update", "draw"} {
+		symbols.Add(program.Symbols[name], name)
+	}
+
+	profiler := New(cpu, symbols)
+	cpu.Reset()
+	cpu.RunUntil(func(cpu *processor.CPU) bool { return false })
+	return profiler
+}
+
+func TestRoutines(t *testing.T) {
+	profiler := newTestProfiler()
+	routines := make(map[string]Routine)
+	for _, routine := range profiler.Routines() {
+		routines[routine.Name] = routine
+	}
+
+	assert.Equal(t, Routine{Address: 0xC020, Name: "L_C020", Calls: 1, Inclusive: 10, Exclusive: 10}, routines["L_C020"])
+	assert.Equal(t, Routine{Address: 0xC007, Name: "update", Calls: 1, Inclusive: 22, Exclusive: 12}, routines["update"])
+	assert.Equal(t, Routine{Address: 0xC023, Name: "draw", Calls: 1, Inclusive: 22, Exclusive: 22}, routines["draw"])
+
+	reset := routines["reset"]
+	assert.Equal(t, uint64(1), reset.Calls, "expected reset to be counted as call")
+	assert.Equal(t, profiler.CPU.TotalCycles-7, reset.Inclusive, "expected all cycles after reset to be included")
+	assert.Equal(t, reset.Inclusive-22-22, reset.Exclusive, "unexpected exclusive cycles")
+	assert.Len(t, routines, 4, "unexpected amount of routines")
+}
+
+func TestRoutinesOrder(t *testing.T) {
+	routines := newTestProfiler().Routines()
+	for i := 1; i < len(routines); i++ {
+		assert.True(t, routines[i-1].Exclusive >= routines[i].Exclusive, "expected routines to be sorted")
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	cpu := processor.NewCPU()
+	asm.MustAssemble(`
+.org $C000
+  LDX #2
+  JSR recurse
+  KIL
+recurse:
+  DEX
+  BEQ @done
+  JSR recurse
+@done:
+  RTS
+`).Load(cpu.Memory)
+	cpu.Registers.PC = 0xC000
+
+	profiler := New(cpu, nil)
+	cpu.RunUntil(func(cpu *processor.CPU) bool { return false })
+	profiler.Close()
+
+	routines := profiler.Routines()
+	var recurse Routine
+	for _, routine := range routines {
+		if routine.Address == 0xC006 {
+			recurse = routine
+		}
+	}
+	assert.Equal(t, uint64(2), recurse.Calls, "unexpected calls")
+	assert.Equal(t, recurse.Exclusive, recurse.Inclusive, "expected recursive calls to be counted only once")
+	assert.Equal(t, "<root>", routines[len(routines)-1].Name, "expected code outside of any routine at root")
+}
+
+func TestPRGOffset(t *testing.T) {
+	cpu := processor.NewCPU()
+	asm.MustAssemble(`
+.org $C000
+  JSR $8000
+  INC $00
+  JSR $8000
+  KIL
+
+.org $8000
+  NOP
+  RTS
+`).Load(cpu.Memory)
+	cpu.Registers.PC = 0xC000
+
+	// The byte at $00 selects the bank mapped to $8000-$BFFF, while $C000-$FFFF has no PRG ROM
+	symbols := disasm.NewSymbols()
+	symbols.AddPRG(0x0000, "first")
+	symbols.AddPRG(0x4000, "second")
+	profiler := New(cpu, symbols)
+	profiler.PRGOffset = func(address uint16) (int, bool) {
+		if address < 0x8000 || address >= 0xC000 {
+			return 0, false
+		}
+		return int(cpu.Memory.DebugPeek(0x00))*0x4000 + int(address-0x8000), true
+	}
+	cpu.RunUntil(func(cpu *processor.CPU) bool { return false })
+
+	routines := make(map[string]Routine)
+	for _, routine := range profiler.Routines() {
+		routines[routine.Name] = routine
+	}
+	assert.Equal(t, Routine{Address: 0x8000, Name: "first", Calls: 1, Inclusive: 8, Exclusive: 8}, routines["first"])
+	assert.Equal(t, Routine{Address: 0x8000, Name: "second", Calls: 1, Inclusive: 8, Exclusive: 8}, routines["second"])
+
+	var output bytes.Buffer
+	assert.NoError(t, profiler.WritePprof(&output))
+	reader, err := gzip.NewReader(&output)
+	assert.NoError(t, err, "expected gzip-compressed profile")
+	data, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "first", "expected function of the first bank")
+	assert.Contains(t, string(data), "second", "expected function of the second bank")
+}
+
+func TestWritePprofReadOnly(t *testing.T) {
+	cpu := processor.NewCPU()
+	asm.MustAssemble(`
+.org $C000
+  NOP
+  NOP
+  RTI
+`).Load(cpu.Memory)
+	cpu.Memory.Poke16(processor.NMIVector, 0xC000)
+	cpu.Registers.PC = 0xD000
+
+	// All instructions execute within the interrupt handler, so nothing is attributed to the root routine
+	profiler := New(cpu, nil)
+	cpu.TriggerNMI()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, cpu.Execute())
+	}
+	routines := profiler.Routines()
+
+	assert.NoError(t, profiler.WritePprof(ioutil.Discard))
+	assert.Equal(t, routines, profiler.Routines(), "expected writing the profile not to add routines")
+}
+
+func TestWritePprof(t *testing.T) {
+	var output bytes.Buffer
+	assert.NoError(t, newTestProfiler().WritePprof(&output))
+
+	reader, err := gzip.NewReader(&output)
+	assert.NoError(t, err, "expected gzip-compressed profile")
+	data, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+
+	for _, name := range []string{"cycles", "instructions", "reset", "update", "draw", "L_C020"} {
+		assert.Contains(t, string(data), name, "expected string table to contain %s", name)
+	}
+}
+
+func TestProfilerAllocations(t *testing.T) {
+	profiler := newTestProfiler()
+	cpu := profiler.CPU
+
+	// Running the program once more only hits existing samples
+	var instructions int
+	allocations := testing.AllocsPerRun(1, func() {
+		cpu.Halted = false
+		cpu.Registers.PC = 0xC000
+		for instructions = 0; !cpu.Halted; instructions++ {
+			_ = cpu.Execute()
+		}
+	})
+	assert.Equal(t, 16, instructions, "unexpected amount of executed instructions")
+	assert.Equal(t, 0.0, allocations, "expected profiling not to allocate for known samples")
+}
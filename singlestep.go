@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"nessie/processor"
+	"nessie/singlestep"
+	"strings"
+)
+
+// runSingleStep runs a directory of SingleStepTests vectors and prints the results of all failing opcodes, or trims
+// them into a directory of fixtures. The exit code is 0 if all vectors passed, 1 if any failed and 2 on errors.
+func runSingleStep(args []string) int {
+	flags := flag.NewFlagSet("singlestep", flag.ContinueOnError)
+	variantName := flags.String("variant", "Ricoh2A03", "cpu variant: Ricoh2A03, NMOS6502 or WDC65C02")
+	ignoreCycles := flags.Bool("ignore-cycles", false, "skip comparing the bus activity of each cycle")
+	trim := flags.Int("trim", 0, "copy the given amount of vectors per opcode into the output directory instead")
+	output := flags.String("o", "singlestep/testdata", "output directory for trimmed vectors")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: nessie singlestep [options] <vector directory>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if *trim > 0 {
+		if err := singlestep.TrimDir(flags.Arg(0), *output, *trim); err != nil {
+			return fail("singlestep", err)
+		}
+		return 0
+	}
+
+	variant, err := parseVariant(*variantName)
+	if err != nil {
+		return fail("singlestep", err)
+	}
+	results, err := singlestep.RunDir(flags.Arg(0), singlestep.Options{Variant: variant, IgnoreCycles: *ignoreCycles})
+	if err != nil {
+		return fail("singlestep", err)
+	}
+
+	passed, failed := 0, 0
+	for _, result := range results {
+		passed += result.Passed
+		failed += result.Failed
+		if result.Failed > 0 {
+			fmt.Print(result)
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func parseVariant(name string) (processor.Variant, error) {
+	for _, variant := range []processor.Variant{processor.Ricoh2A03, processor.NMOS6502, processor.WDC65C02} {
+		if strings.EqualFold(processor.VariantName(variant), name) {
+			return variant, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown variant: %s", name)
+}
@@ -0,0 +1,328 @@
+package singlestep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"nessie/processor"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The committed fixtures get regenerated from a checkout of the upstream 2A03 vectors, keeping ten vectors per opcode
+//go:generate go run nessie singlestep -trim 10 -o testdata $NESSIE_SINGLESTEP_DIR
+
+// maxFailures limits the amount of failed vectors which are kept per opcode, as broken opcodes usually fail thousands
+// of vectors in the same way
+const maxFailures = 5
+
+// State is the CPU state of a test vector. RAM contains pairs of addresses and values, all other memory is zero.
+type State struct {
+	PC  uint16   `json:"pc"`
+	S   uint8    `json:"s"`
+	A   uint8    `json:"a"`
+	X   uint8    `json:"x"`
+	Y   uint8    `json:"y"`
+	P   uint8    `json:"p"`
+	RAM [][2]int `json:"ram"`
+}
+
+// BusCycle is a single bus access, encoded as `[address, value, "read"]` or `[address, value, "write"]`.
+type BusCycle struct {
+	Address uint16
+	Value   uint8
+	Write   bool
+}
+
+// Vector is a single test case, which executes exactly one instruction. Cycles are optional and only get compared if
+// the vector contains any.
+type Vector struct {
+	Name    string     `json:"name"`
+	Initial State      `json:"initial"`
+	Final   State      `json:"final"`
+	Cycles  []BusCycle `json:"cycles"`
+}
+
+type Options struct {
+	Variant processor.Variant
+	// IgnoreCycles skips comparing the bus activity of each cycle
+	IgnoreCycles bool
+}
+
+// Failure contains all mismatches of a single vector.
+type Failure struct {
+	Vector     string
+	Mismatches []string
+}
+
+// Result summarizes all vectors of a single opcode, keeping only the first few failures.
+type Result struct {
+	Opcode   processor.Opcode
+	Passed   int
+	Failed   int
+	Failures []Failure
+}
+
+func (c *BusCycle) UnmarshalJSON(data []byte) error {
+	var fields []interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid bus cycle: %s", data)
+	}
+
+	address, ok1 := fields[0].(float64)
+	value, ok2 := fields[1].(float64)
+	kind, ok3 := fields[2].(string)
+	if !ok1 || !ok2 || !ok3 || (kind != "read" && kind != "write") {
+		return fmt.Errorf("invalid bus cycle: %s", data)
+	}
+
+	c.Address, c.Value, c.Write = uint16(address), uint8(value), kind == "write"
+	return nil
+}
+
+func (c BusCycle) MarshalJSON() ([]byte, error) {
+	kind := "read"
+	if c.Write {
+		kind = "write"
+	}
+	return json.Marshal([]interface{}{c.Address, c.Value, kind})
+}
+
+func (c BusCycle) String() string {
+	kind := "read"
+	if c.Write {
+		kind = "write"
+	}
+	return fmt.Sprintf("%s $%02X @ $%04X", kind, c.Value, c.Address)
+}
+
+// Read decodes a JSON array of test vectors.
+func Read(reader io.Reader) ([]Vector, error) {
+	var vectors []Vector
+	if err := json.NewDecoder(reader).Decode(&vectors); err != nil {
+		return nil, fmt.Errorf("invalid test vectors: %v", err)
+	}
+	return vectors, nil
+}
+
+// Write encodes test vectors as a JSON array with one vector per line, which matches the layout of SingleStepTests.
+func Write(writer io.Writer, vectors []Vector) error {
+	if _, err := io.WriteString(writer, "[\n"); err != nil {
+		return err
+	}
+	for i, vector := range vectors {
+		line, err := json.Marshal(vector)
+		if err != nil {
+			return err
+		}
+		if i < len(vectors)-1 {
+			line = append(line, ',')
+		}
+		if _, err := writer.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(writer, "]\n")
+	return err
+}
+
+func Load(path string) ([]Vector, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open test vectors: %v", err)
+	}
+	defer file.Close()
+
+	return Read(file)
+}
+
+// Opcode returns the opcode tested by the vector, which is the initial memory value at the program counter.
+func (v *Vector) Opcode() processor.Opcode {
+	for _, entry := range v.Initial.RAM {
+		if uint16(entry[0]) == v.Initial.PC {
+			return processor.Opcode(entry[1])
+		}
+	}
+	return 0
+}
+
+// Run executes a single vector on a CPU with a flat 64 KiB memory and returns all mismatches against the final state.
+func Run(vector Vector, options Options) []string {
+	cpu := processor.NewCPU(processor.WithVariant(options.Variant))
+	cpu.Registers = processor.Registers{
+		PC: vector.Initial.PC,
+		S:  vector.Initial.S,
+		A:  vector.Initial.A,
+		X:  vector.Initial.X,
+		Y:  vector.Initial.Y,
+		P:  processor.Status(vector.Initial.P),
+	}
+	for _, entry := range vector.Initial.RAM {
+		cpu.Memory.Poke(uint16(entry[0]), uint8(entry[1]))
+	}
+
+	var cycles []BusCycle
+	cpu.OnMemoryAccess(func(access processor.MemoryAccess) {
+		cycles = append(cycles, BusCycle{access.Address, access.Value, access.Type == processor.AccessWrite})
+	})
+
+	var mismatches []string
+	mismatch := func(format string, args ...interface{}) {
+		mismatches = append(mismatches, fmt.Sprintf(format, args...))
+	}
+
+	// Jamming opcodes still have a well-defined final state, so only other errors are reported
+	if err := cpu.Execute(); err != nil && err != processor.ErrCPUJammed {
+		mismatch("execution failed: %v", err)
+	}
+
+	final, actual := vector.Final, cpu.Registers
+	registers := []struct {
+		name             string
+		expected, actual int
+		width            int
+	}{
+		{"PC", int(final.PC), int(actual.PC), 4},
+		{"S", int(final.S), int(actual.S), 2},
+		{"A", int(final.A), int(actual.A), 2},
+		{"X", int(final.X), int(actual.X), 2},
+		{"Y", int(final.Y), int(actual.Y), 2},
+		{"P", int(final.P), int(actual.P), 2},
+	}
+	for _, register := range registers {
+		if register.expected != register.actual {
+			mismatch("%s: expected $%0*X, got $%0*X", register.name,
+				register.width, register.expected, register.width, register.actual)
+		}
+	}
+
+	for _, entry := range final.RAM {
+		address := uint16(entry[0])
+		if value := cpu.Memory.DebugPeek(address); value != uint8(entry[1]) {
+			mismatch("RAM $%04X: expected $%02X, got $%02X", address, entry[1], value)
+		}
+	}
+
+	if !options.IgnoreCycles && len(vector.Cycles) > 0 {
+		for i := 0; i < len(vector.Cycles) || i < len(cycles); i++ {
+			switch {
+			case i >= len(cycles):
+				mismatch("cycle %d: expected %s, got nothing", i+1, vector.Cycles[i])
+			case i >= len(vector.Cycles):
+				mismatch("cycle %d: expected nothing, got %s", i+1, cycles[i])
+			case vector.Cycles[i] != cycles[i]:
+				mismatch("cycle %d: expected %s, got %s", i+1, vector.Cycles[i], cycles[i])
+			}
+		}
+	}
+
+	return mismatches
+}
+
+// RunFile executes all vectors of a file, which are expected to test a single opcode like the files of
+// SingleStepTests do.
+func RunFile(path string, options Options) (Result, error) {
+	vectors, err := Load(path)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var result Result
+	for i, vector := range vectors {
+		if i == 0 {
+			result.Opcode = vector.Opcode()
+		}
+
+		mismatches := Run(vector, options)
+		if len(mismatches) == 0 {
+			result.Passed++
+			continue
+		}
+
+		result.Failed++
+		if len(result.Failures) < maxFailures {
+			result.Failures = append(result.Failures, Failure{Vector: vector.Name, Mismatches: mismatches})
+		}
+	}
+
+	return result, nil
+}
+
+// RunDir executes all JSON files within a directory and returns one result per file, ordered by opcode.
+func RunDir(dir string, options Options) ([]Result, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no test vectors found in %s", dir)
+	}
+
+	results := make([]Result, 0, len(paths))
+	for _, path := range paths {
+		result, err := RunFile(path, options)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Opcode < results[j].Opcode })
+	return results, nil
+}
+
+// TrimDir copies the first count vectors of every JSON file within the source directory into a file with the same name
+// within the destination directory. This keeps a subset of the full SingleStepTests suite small enough for committing
+// it as fixtures.
+func TrimDir(source, destination string, count int) error {
+	paths, err := filepath.Glob(filepath.Join(source, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no test vectors found in %s", source)
+	}
+
+	for _, path := range paths {
+		vectors, err := Load(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		if len(vectors) > count {
+			vectors = vectors[:count]
+		}
+		if err := writeFile(filepath.Join(destination, filepath.Base(path)), vectors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, vectors []Vector) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create test vectors: %v", err)
+	}
+	if err := Write(file, vectors); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to write test vectors: %v", err)
+	}
+	return file.Close()
+}
+
+func (r Result) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "opcode $%02X: %d passed, %d failed\n", uint8(r.Opcode), r.Passed, r.Failed)
+	for _, failure := range r.Failures {
+		fmt.Fprintf(&builder, "  %s\n", failure.Vector)
+		for _, mismatch := range failure.Mismatches {
+			fmt.Fprintf(&builder, "    %s\n", mismatch)
+		}
+	}
+	return builder.String()
+}
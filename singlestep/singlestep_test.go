@@ -0,0 +1,107 @@
+package singlestep
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"nessie/processor"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixtures(t *testing.T) {
+	results, err := RunDir("testdata", Options{Variant: processor.Ricoh2A03})
+	assert.NoError(t, err)
+	assert.Len(t, results, 8, "unexpected amount of fixtures")
+
+	for _, result := range results {
+		assert.Zero(t, result.Failed, "unexpected failures:\n%s", result)
+		assert.NotZero(t, result.Passed, "expected vectors for opcode $%02X", uint8(result.Opcode))
+	}
+	assert.Equal(t, processor.Opcode(0x00), results[0].Opcode, "expected results to be ordered by opcode")
+}
+
+// TestSingleStepTests runs the full SingleStepTests suite for the 2A03, which is too large to be committed and gets
+// picked up from the directory given by NESSIE_SINGLESTEP_DIR. Subsets of it can be committed as fixtures by running
+// `nessie singlestep -trim <count> <dir>`.
+func TestSingleStepTests(t *testing.T) {
+	dir := os.Getenv("NESSIE_SINGLESTEP_DIR")
+	if dir == "" {
+		t.Skip("NESSIE_SINGLESTEP_DIR not set")
+	}
+
+	results, err := RunDir(dir, Options{Variant: processor.Ricoh2A03})
+	assert.NoError(t, err)
+	for _, result := range results {
+		if result.Failed > 0 {
+			t.Error(result.String())
+		}
+	}
+}
+
+func TestRunMismatch(t *testing.T) {
+	vectors, err := Load("testdata/a9.json")
+	assert.NoError(t, err)
+
+	vector := vectors[0]
+	vector.Final.A = 0x01
+	vector.Final.RAM = append(vector.Final.RAM, [2]int{0x0201, 0xFF})
+	vector.Cycles = append(vector.Cycles, BusCycle{Address: 0x0202, Value: 0x00})
+
+	assert.Equal(t, []string{
+		"A: expected $01, got $00",
+		"RAM $0201: expected $FF, got $00",
+		"cycle 3: expected read $00 @ $0202, got nothing",
+	}, Run(vector, Options{}))
+	assert.Len(t, Run(vector, Options{IgnoreCycles: true}), 2, "expected cycles to be ignored")
+}
+
+func TestRead(t *testing.T) {
+	vectors, err := Read(strings.NewReader(`[{"name": "ea", "initial": {"pc": 512, "ram": [[512, 234]]},
+		"final": {"pc": 513}, "cycles": [[512, 234, "read"], [513, 0, "write"]]}]`))
+	assert.NoError(t, err)
+	if assert.Len(t, vectors, 1) {
+		assert.Equal(t, processor.Opcode(0xEA), vectors[0].Opcode(), "unexpected opcode")
+		assert.Equal(t, []BusCycle{{0x0200, 0xEA, false}, {0x0201, 0x00, true}}, vectors[0].Cycles)
+	}
+
+	_, err = Read(strings.NewReader(`[{"cycles": [[512, 234, "fetch"]]}]`))
+	assert.Error(t, err, "expected error for unknown cycle type")
+}
+
+func TestResultString(t *testing.T) {
+	result := Result{Opcode: 0xA9, Passed: 1, Failed: 1, Failures: []Failure{{"a9 00", []string{"A: expected $01, got $00"}}}}
+	assert.Equal(t, "opcode $A9: 1 passed, 1 failed\n  a9 00\n    A: expected $01, got $00\n", result.String())
+}
+
+func TestWrite(t *testing.T) {
+	vectors, err := Load("testdata/69.json")
+	assert.NoError(t, err)
+
+	var builder strings.Builder
+	assert.NoError(t, Write(&builder, vectors))
+	assert.Equal(t, len(vectors)+2, strings.Count(builder.String(), "\n"), "expected one vector per line")
+
+	written, err := Read(strings.NewReader(builder.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, vectors, written, "expected written vectors to be read back unchanged")
+}
+
+func TestTrimDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "singlestep")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, TrimDir("testdata", dir, 1))
+	results, err := RunDir(dir, Options{Variant: processor.Ricoh2A03})
+	assert.NoError(t, err)
+	assert.Len(t, results, 8, "expected one file per opcode")
+	for _, result := range results {
+		assert.Equal(t, 1, result.Passed, "expected single vector for opcode $%02X", uint8(result.Opcode))
+	}
+
+	assert.Error(t, TrimDir(filepath.Join(dir, "missing"), dir, 1), "expected error for missing vectors")
+}
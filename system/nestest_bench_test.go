@@ -0,0 +1,46 @@
+package system
+
+import (
+	"nessie/cartridge"
+	"nessie/processor"
+	"testing"
+)
+
+// nesTestInstructions is the amount of instructions logged in the golden nestest log
+const nesTestInstructions = 8991
+
+func BenchmarkNESTest(b *testing.B) {
+	benchmarkNESTest(b)
+}
+
+func BenchmarkNESTestDecodeCache(b *testing.B) {
+	benchmarkNESTest(b, processor.WithDecodeCache())
+}
+
+func benchmarkNESTest(b *testing.B, options ...processor.Option) {
+	rom, err := cartridge.LoadROM("roms/nestest.nes")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	cpu := processor.NewCPU(options...)
+	if err := cpu.Memory.AddMappings(rom, processor.MappingCPU); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	// Every iteration runs nestest up to the end of the golden log
+	instructions := 0
+	for i := 0; i < b.N; i++ {
+		cpu.Registers.PC = 0xC000
+		cpu.Registers.P = 0x24
+		cpu.Registers.S = 0xFD
+		for j := 0; j < nesTestInstructions; j++ {
+			cpu.Execute()
+			instructions++
+		}
+	}
+	b.ReportMetric(float64(instructions)/b.Elapsed().Seconds(), "instr/s")
+}
@@ -0,0 +1,186 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"nessie/processor"
+	"strings"
+)
+
+type Field int
+
+const (
+	FieldPC Field = 1 << iota
+	FieldA
+	FieldX
+	FieldY
+	FieldS
+	FieldP
+	FieldCycles
+	FieldMemory
+)
+
+const FieldAll = FieldPC | FieldA | FieldX | FieldY | FieldS | FieldP | FieldCycles | FieldMemory
+
+type DiffOptions struct {
+	Format Format
+	// Context is the amount of previously executed instructions which get included in a divergence
+	Context int
+	// Limit stops the comparison after the given amount of golden trace lines, zero compares the whole trace
+	Limit int
+	// Ignore excludes fields from the comparison, e.g. FieldMemory when comparing against a console with open bus
+	Ignore Field
+	// Synchronize initializes the registers and cycle counter of the CPU from the first golden trace record
+	Synchronize bool
+}
+
+// Divergence describes the first golden trace line which did not match the state of the CPU. Context contains the
+// trace lines of the instructions executed right before, rendered in the format of the golden trace.
+type Divergence struct {
+	Line     int
+	Fields   Field
+	Expected Record
+	Actual   Record
+	Context  []string
+}
+
+// Diff executes the CPU alongside a golden trace and returns the first divergence, or nil if every compared line
+// matched. Cycle counts and memory values are only compared when present in the golden trace.
+func Diff(cpu *processor.CPU, golden io.Reader, options DiffOptions) (*Divergence, error) {
+	traceOptions := Options{Format: options.Format, EffectiveAddress: true, Cycles: true}
+	scanner := bufio.NewScanner(golden)
+	var context []string
+
+	synchronize := options.Synchronize
+	for line := 1; scanner.Scan(); line++ {
+		if options.Limit > 0 && line > options.Limit {
+			break
+		}
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		expected, err := Parse(options.Format, scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("golden trace line %d: %v", line, err)
+		}
+		if synchronize {
+			cpu.Registers = expected.Registers
+			if expected.HasCycles {
+				cpu.TotalCycles = expected.Cycles
+			}
+			synchronize = false
+		}
+
+		// Rendering and parsing our own state ensures that both records went through the exact same conversion
+		actualLine := strings.TrimSuffix(Line(Capture(cpu, traceOptions), traceOptions), "\n")
+		actual, err := Parse(options.Format, actualLine)
+		if err != nil {
+			return nil, err
+		}
+
+		if fields := expected.Compare(actual) &^ options.Ignore; fields != 0 {
+			return &Divergence{Line: line, Fields: fields, Expected: expected, Actual: actual, Context: context}, nil
+		}
+
+		if options.Context > 0 {
+			if len(context) == options.Context {
+				context = context[1:]
+			}
+			context = append(context, actualLine)
+		}
+
+		if err := cpu.Execute(); err != nil {
+			return nil, fmt.Errorf("golden trace line %d: %v", line, err)
+		}
+	}
+
+	return nil, scanner.Err()
+}
+
+// Compare returns all fields which differ between both records. Cycles and memory values are only compared if both
+// records contain them.
+func (r Record) Compare(other Record) (fields Field) {
+	if r.Registers.PC != other.Registers.PC {
+		fields |= FieldPC
+	}
+	if r.Registers.A != other.Registers.A {
+		fields |= FieldA
+	}
+	if r.Registers.X != other.Registers.X {
+		fields |= FieldX
+	}
+	if r.Registers.Y != other.Registers.Y {
+		fields |= FieldY
+	}
+	if r.Registers.S != other.Registers.S {
+		fields |= FieldS
+	}
+	if r.Registers.P != other.Registers.P {
+		fields |= FieldP
+	}
+	if r.HasCycles && other.HasCycles && r.Cycles != other.Cycles {
+		fields |= FieldCycles
+	}
+	if r.HasValue && other.HasValue && r.Value != other.Value {
+		fields |= FieldMemory
+	}
+
+	return
+}
+
+func (d *Divergence) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "divergence at line %d (%s)\n", d.Line, FieldNames(d.Fields))
+	for _, line := range d.Context {
+		fmt.Fprintf(&builder, "  %s\n", line)
+	}
+	fmt.Fprintf(&builder, "- %s\n", d.Expected.Line)
+	fmt.Fprintf(&builder, "+ %s\n", d.Actual.Line)
+
+	return builder.String()
+}
+
+func FieldNames(fields Field) string {
+	var names []string
+	for field := FieldPC; field <= FieldMemory; field <<= 1 {
+		if fields&field != 0 {
+			names = append(names, FieldName(field))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func FieldName(field Field) string {
+	switch field {
+	case FieldPC:
+		return "PC"
+	case FieldA:
+		return "A"
+	case FieldX:
+		return "X"
+	case FieldY:
+		return "Y"
+	case FieldS:
+		return "S"
+	case FieldP:
+		return "P"
+	case FieldCycles:
+		return "cycles"
+	case FieldMemory:
+		return "memory"
+	default:
+		return "<unknown>"
+	}
+}
+
+// ParseFormat returns the trace format with the given name as returned by FormatName.
+func ParseFormat(name string) (Format, error) {
+	for format := FormatNestest; format <= FormatJSON; format++ {
+		if FormatName(format) == strings.ToLower(name) {
+			return format, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown trace format: %s", name)
+}
@@ -0,0 +1,99 @@
+package trace
+
+import (
+	"github.com/stretchr/testify/assert"
+	"nessie/processor"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testParse := func(format Format, line string, pc uint16, p processor.Status, cycles processor.Cycles, value int) {
+		record, err := Parse(format, line)
+		if !assert.NoError(t, err, "unexpected error for %q", line) {
+			return
+		}
+
+		assert.Equal(t, pc, record.Registers.PC, "unexpected program counter for %q", line)
+		assert.Equal(t, p, record.Registers.P, "unexpected status for %q", line)
+		assert.Equal(t, uint8(0xFB), record.Registers.S, "unexpected stack pointer for %q", line)
+		assert.Equal(t, uint8(0x5A), record.Registers.A, "unexpected accumulator for %q", line)
+		assert.Equal(t, cycles != 0, record.HasCycles, "unexpected cycle presence for %q", line)
+		assert.Equal(t, cycles, record.Cycles, "unexpected cycles for %q", line)
+		assert.Equal(t, value >= 0, record.HasValue, "unexpected value presence for %q", line)
+		if value >= 0 {
+			assert.Equal(t, uint8(value), record.Value, "unexpected value for %q", line)
+		}
+	}
+
+	testParse(FormatNestest, "CFDB  A1 80     LDA ($80,X) @ 80 = 0200 = 5A    A:5A X:00 Y:69 P:27 SP:FB PPU:118, 22 CYC:2547",
+		0xCFDB, 0x27, 2547, 0x5A)
+	testParse(FormatNestest, "C6BD  04 A9    *NOP $A9 = 00                    A:5A X:97 Y:4E P:EF SP:FB",
+		0xC6BD, 0xEF, 0, 0x00)
+	testParse(FormatNestest, "DB7B  6C 00 02  JMP ($0200) = DB7E              A:5A X:07 Y:00 P:E5 SP:FB CYC:9550",
+		0xDB7B, 0xE5, 9550, -1)
+	testParse(FormatNestest, "C000  A:5A X:00 Y:00 P:24 SP:FB",
+		0xC000, 0x24, 0, -1)
+	testParse(FormatMesen, "C000  STA ($10),Y [$0305] = $99        A:5A X:00 Y:05 S:FB P:nvUbdIzc V:241 H:12  Cycle:42",
+		0xC000, 0x24, 42, 0x99)
+	testParse(FormatMesen, "8000  SEI                              A:5A X:00 Y:00 S:FB P:24",
+		0x8000, 0x24, 0, -1)
+	testParse(FormatFCEUX, "c42          A:5A X:00 Y:05 S:FB P:NvUbdIzC  $C000:91 10     STA ($10),Y @ $0305 = #$99",
+		0xC000, 0xA5, 42, 0x99)
+	testParse(FormatFCEUX, "A:5A X:00 Y:05 S:FB P:nvUbdIzc  $C000",
+		0xC000, 0x24, 0, -1)
+	testParse(FormatJSON, `{"pc":49152,"a":90,"x":0,"y":5,"s":251,"p":36,"cycles":42,"address":773,"value":153}`,
+		0xC000, 0x24, 42, 0x99)
+
+	_, err := Parse(FormatNestest, "garbage")
+	assert.Error(t, err, "expected invalid line to be rejected")
+	_, err = Parse(FormatJSON, "{")
+	assert.Error(t, err, "expected invalid json to be rejected")
+}
+
+func TestDiff(t *testing.T) {
+	// LDX #$00; loop: INX; JMP loop
+	golden := strings.Join([]string{
+		"C000  A2 00     LDX #$00                        A:00 X:10 Y:00 P:24 SP:FD CYC:7",
+		"C002  E8        INX                             A:00 X:00 Y:00 P:26 SP:FD CYC:9",
+		"C003  4C 02 C0  JMP $C002                       A:00 X:01 Y:00 P:24 SP:FD CYC:11",
+		"C002  E8        INX                             A:00 X:01 Y:00 P:24 SP:FD CYC:14",
+		"C003  4C 02 C0  JMP $C002                       A:00 X:02 Y:00 P:24 SP:FD CYC:16",
+		"C002  E8        INX                             A:00 X:03 Y:00 P:24 SP:FD CYC:20",
+	}, "\n")
+
+	cpu := newTestCPU(0xA2, 0x00, 0xE8, 0x4C, 0x02, 0xC0)
+	divergence, err := Diff(cpu, strings.NewReader(golden), DiffOptions{Format: FormatNestest, Context: 2, Synchronize: true})
+	assert.NoError(t, err)
+	if assert.NotNil(t, divergence, "expected traces to diverge") {
+		assert.Equal(t, 6, divergence.Line, "unexpected divergence line")
+		assert.Equal(t, FieldX|FieldCycles, divergence.Fields, "unexpected diverging fields")
+		assert.Equal(t, []string{
+			"C002  E8        INX                             A:00 X:01 Y:00 P:24 SP:FD CYC:14",
+			"C003  4C 02 C0  JMP $C002                       A:00 X:02 Y:00 P:24 SP:FD CYC:16",
+		}, divergence.Context, "unexpected context")
+		assert.Equal(t, "C002  E8        INX                             A:00 X:02 Y:00 P:24 SP:FD CYC:19",
+			divergence.Actual.Line, "unexpected actual line")
+		assert.True(t, strings.HasPrefix(divergence.String(), "divergence at line 6 (X, cycles)\n"))
+	}
+
+	cpu = newTestCPU(0xA2, 0x00, 0xE8, 0x4C, 0x02, 0xC0)
+	divergence, err = Diff(cpu, strings.NewReader(golden), DiffOptions{Format: FormatNestest, Limit: 5, Synchronize: true})
+	assert.NoError(t, err)
+	assert.Nil(t, divergence, "expected limited traces to match")
+
+	// Leading blank lines must not prevent synchronizing on the first record
+	cpu = newTestCPU(0xA2, 0x00, 0xE8, 0x4C, 0x02, 0xC0)
+	divergence, err = Diff(cpu, strings.NewReader("\n\n"+golden), DiffOptions{Format: FormatNestest, Limit: 7, Synchronize: true})
+	assert.NoError(t, err)
+	assert.Nil(t, divergence, "expected traces with leading blank lines to match")
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("FCEUX")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatFCEUX, format)
+
+	_, err = ParseFormat("nintendulator")
+	assert.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package trace
+
+import "nessie/processor"
+
+type EffectiveKind int
+
+const (
+	EffectiveNone EffectiveKind = iota
+	EffectiveDirect
+	EffectiveIndexed
+	EffectivePreIndexed
+	EffectivePostIndexed
+	EffectiveIndirect
+	EffectiveJump
+)
+
+// Effective describes the memory location accessed by an instruction. Pointer holds the zero page pointer location
+// for (zp,X) and the unindexed base address for (zp),Y, whereas Address always holds the final effective address or
+// the target of an indirect jump.
+type Effective struct {
+	Kind     EffectiveKind
+	Pointer  uint16
+	Address  uint16
+	Value    uint8
+	ZeroPage bool
+}
+
+func resolve(cpu *processor.CPU, entry Entry) (effective Effective) {
+	memory, registers := cpu.Memory, entry.Registers
+	if len(entry.Bytes) < 2 {
+		return
+	}
+
+	arg8 := entry.Bytes[1]
+	arg16 := uint16(arg8)
+	if len(entry.Bytes) > 2 {
+		arg16 |= uint16(entry.Bytes[2]) << 8
+	}
+
+	switch entry.Instruction.Variant.AddressingMode {
+	case processor.ZeroPage, processor.Absolute:
+		if entry.Instruction.Mnemonic == "JMP" || entry.Instruction.Mnemonic == "JSR" {
+			return
+		}
+		effective = Effective{Kind: EffectiveDirect, Address: arg16}
+	case processor.ZeroPageX:
+		effective = Effective{Kind: EffectiveIndexed, Address: uint16(arg8 + registers.X), ZeroPage: true}
+	case processor.ZeroPageY:
+		effective = Effective{Kind: EffectiveIndexed, Address: uint16(arg8 + registers.Y), ZeroPage: true}
+	case processor.AbsoluteX:
+		effective = Effective{Kind: EffectiveIndexed, Address: arg16 + uint16(registers.X)}
+	case processor.AbsoluteY:
+		effective = Effective{Kind: EffectiveIndexed, Address: arg16 + uint16(registers.Y)}
+	case processor.IndirectX:
+		pointer := arg8 + registers.X
+		address := readZeroPage16(memory, pointer)
+		effective = Effective{Kind: EffectivePreIndexed, Pointer: uint16(pointer), Address: address}
+	case processor.IndirectY:
+		base := readZeroPage16(memory, arg8)
+		effective = Effective{Kind: EffectivePostIndexed, Pointer: base, Address: base + uint16(registers.Y)}
+	case processor.ZeroPageIndirect:
+		effective = Effective{Kind: EffectiveIndirect, Address: readZeroPage16(memory, arg8)}
+	case processor.Indirect:
+		// Mirror the missing page carry of the NMOS 6502 so that traces show the actual jump target
+		wrapped := (arg16 & 0xFF00) | ((arg16 + 1) & 0x00FF)
+		if cpu.Variant() == processor.WDC65C02 {
+			wrapped = arg16 + 1
+		}
+		address := uint16(memory.DebugPeek(arg16)) | uint16(memory.DebugPeek(wrapped))<<8
+		return Effective{Kind: EffectiveJump, Pointer: arg16, Address: address}
+	case processor.AbsoluteIndirectX:
+		pointer := arg16 + uint16(registers.X)
+		return Effective{Kind: EffectiveJump, Pointer: pointer, Address: memory.DebugPeek16(pointer)}
+	default:
+		return
+	}
+
+	effective.Value = memory.DebugPeek(effective.Address)
+	return
+}
+
+func readZeroPage16(memory *processor.MappedMemory, pointer uint8) uint16 {
+	return uint16(memory.DebugPeek(uint16(pointer))) | uint16(memory.DebugPeek(uint16(pointer+1)))<<8
+}
@@ -0,0 +1,216 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"nessie/disasm"
+	"nessie/processor"
+	"strings"
+)
+
+type jsonEntry struct {
+	PC          uint16  `json:"pc"`
+	Bytes       string  `json:"bytes,omitempty"`
+	Disassembly string  `json:"disassembly,omitempty"`
+	A           uint8   `json:"a"`
+	X           uint8   `json:"x"`
+	Y           uint8   `json:"y"`
+	S           uint8   `json:"s"`
+	P           uint8   `json:"p"`
+	Cycles      *uint64 `json:"cycles,omitempty"`
+	Scanline    *int    `json:"scanline,omitempty"`
+	Dot         *int    `json:"dot,omitempty"`
+	Address     *uint16 `json:"address,omitempty"`
+	Value       *uint8  `json:"value,omitempty"`
+}
+
+// nestestMnemonics maps unofficial mnemonics to the names used by Nintendulator where they differ
+var nestestMnemonics = map[string]string{
+	"ISC": "ISB",
+}
+
+// appendNestest renders a line in the format of the golden nestest log produced by Nintendulator, for example
+// `C5F7  86 00     STX $00 = 00                    A:00 X:00 Y:00 P:26 SP:FD PPU: 15,  0 CYC:12`.
+func appendNestest(buffer []byte, entry Entry, options Options) []byte {
+	registers := entry.Registers
+	buffer = append(buffer, fmt.Sprintf("%04X  ", registers.PC)...)
+
+	if !options.RegistersOnly {
+		marker := ' '
+		if entry.Instruction.Unofficial {
+			marker = '*'
+			if mnemonic, ok := nestestMnemonics[entry.Instruction.Mnemonic]; ok {
+				entry.Instruction.Mnemonic = mnemonic
+			}
+		}
+
+		disassembly := Disassemble(entry) + annotateNestest(entry.Effective)
+		buffer = append(buffer, fmt.Sprintf("%-8s %c%-31s ", formatBytes(entry.Bytes), marker, disassembly)...)
+	}
+
+	buffer = append(buffer, fmt.Sprintf("A:%02X X:%02X Y:%02X P:%02X SP:%02X",
+		registers.A, registers.X, registers.Y, registers.P, registers.S)...)
+	if entry.HasPPU {
+		buffer = append(buffer, fmt.Sprintf(" PPU:%3d,%3d", entry.Dot, entry.Scanline)...)
+	}
+	if options.Cycles {
+		buffer = append(buffer, fmt.Sprintf(" CYC:%d", entry.Cycles)...)
+	}
+
+	return buffer
+}
+
+// appendMesen renders a line similar to the default trace format of Mesen, for example
+// `C5F7  STX $00 = $00                    A:00 X:00 Y:00 S:FD P:nvUbdIZc V:0   H:15  Cycle:12`.
+func appendMesen(buffer []byte, entry Entry, options Options) []byte {
+	registers := entry.Registers
+	buffer = append(buffer, fmt.Sprintf("%04X  ", registers.PC)...)
+
+	if !options.RegistersOnly {
+		disassembly := Disassemble(entry) + annotateMesen(entry.Effective)
+		buffer = append(buffer, fmt.Sprintf("%-32s ", disassembly)...)
+	}
+
+	buffer = append(buffer, fmt.Sprintf("A:%02X X:%02X Y:%02X S:%02X P:%s",
+		registers.A, registers.X, registers.Y, registers.S, FlagString(registers.P))...)
+	if entry.HasPPU {
+		buffer = append(buffer, fmt.Sprintf(" V:%-3d H:%-3d", entry.Scanline, entry.Dot)...)
+	}
+	if options.Cycles {
+		buffer = append(buffer, fmt.Sprintf(" Cycle:%d", entry.Cycles)...)
+	}
+
+	return buffer
+}
+
+// appendFCEUX renders a line in the format of the FCEUX trace logger, for example
+// `c12         A:00 X:00 Y:00 S:FD P:nvUbdIZc  $C5F7:86 00     STX $0000 = #$00`.
+func appendFCEUX(buffer []byte, entry Entry, options Options) []byte {
+	registers := entry.Registers
+	if options.Cycles {
+		buffer = append(buffer, fmt.Sprintf("c%-11d ", entry.Cycles)...)
+	}
+
+	buffer = append(buffer, fmt.Sprintf("A:%02X X:%02X Y:%02X S:%02X P:%s  $%04X",
+		registers.A, registers.X, registers.Y, registers.S, FlagString(registers.P), registers.PC)...)
+	if !options.RegistersOnly {
+		disassembly := Disassemble(entry) + annotateFCEUX(entry.Effective)
+		buffer = append(buffer, fmt.Sprintf(":%-8s  %s", formatBytes(entry.Bytes), disassembly)...)
+	}
+
+	return buffer
+}
+
+func appendJSON(buffer []byte, entry Entry, options Options) []byte {
+	registers := entry.Registers
+	line := jsonEntry{
+		PC: registers.PC,
+		A:  registers.A,
+		X:  registers.X,
+		Y:  registers.Y,
+		S:  registers.S,
+		P:  uint8(registers.P),
+	}
+
+	if !options.RegistersOnly {
+		line.Bytes = formatBytes(entry.Bytes)
+		line.Disassembly = Disassemble(entry)
+	}
+	if options.Cycles {
+		cycles := uint64(entry.Cycles)
+		line.Cycles = &cycles
+	}
+	if entry.HasPPU {
+		line.Scanline, line.Dot = &entry.Scanline, &entry.Dot
+	}
+	if entry.Effective.Kind != EffectiveNone {
+		line.Address = &entry.Effective.Address
+		if entry.Effective.Kind != EffectiveJump {
+			line.Value = &entry.Effective.Value
+		}
+	}
+
+	// Marshalling a struct of plain values can not fail
+	data, _ := json.Marshal(line)
+	return append(buffer, data...)
+}
+
+func annotateNestest(effective Effective) string {
+	switch effective.Kind {
+	case EffectiveDirect:
+		return fmt.Sprintf(" = %02X", effective.Value)
+	case EffectiveIndexed:
+		if effective.ZeroPage {
+			return fmt.Sprintf(" @ %02X = %02X", effective.Address, effective.Value)
+		}
+		return fmt.Sprintf(" @ %04X = %02X", effective.Address, effective.Value)
+	case EffectivePreIndexed:
+		return fmt.Sprintf(" @ %02X = %04X = %02X", effective.Pointer, effective.Address, effective.Value)
+	case EffectivePostIndexed:
+		return fmt.Sprintf(" = %04X @ %04X = %02X", effective.Pointer, effective.Address, effective.Value)
+	case EffectiveIndirect:
+		return fmt.Sprintf(" = %04X = %02X", effective.Address, effective.Value)
+	case EffectiveJump:
+		return fmt.Sprintf(" = %04X", effective.Address)
+	default:
+		return ""
+	}
+}
+
+func annotateMesen(effective Effective) string {
+	switch effective.Kind {
+	case EffectiveDirect:
+		return fmt.Sprintf(" = $%02X", effective.Value)
+	case EffectiveIndexed, EffectivePreIndexed, EffectivePostIndexed, EffectiveIndirect:
+		return fmt.Sprintf(" [$%04X] = $%02X", effective.Address, effective.Value)
+	case EffectiveJump:
+		return fmt.Sprintf(" [$%04X]", effective.Address)
+	default:
+		return ""
+	}
+}
+
+func annotateFCEUX(effective Effective) string {
+	switch effective.Kind {
+	case EffectiveDirect:
+		return fmt.Sprintf(" = #$%02X", effective.Value)
+	case EffectiveIndexed, EffectivePreIndexed, EffectivePostIndexed, EffectiveIndirect:
+		return fmt.Sprintf(" @ $%04X = #$%02X", effective.Address, effective.Value)
+	case EffectiveJump:
+		return fmt.Sprintf(" = $%04X", effective.Address)
+	default:
+		return ""
+	}
+}
+
+// Disassemble returns the instruction of an entry in ca65 syntax, showing branch targets instead of offsets.
+func Disassemble(entry Entry) string {
+	if entry.Instruction.Handler == nil {
+		return fmt.Sprintf(".byte $%02X", entry.Bytes[0])
+	}
+	return disasm.Format(entry.Instruction, entry.Bytes, entry.Registers.PC, nil)
+}
+
+// FlagString renders the status register the way FCEUX and Mesen do, using upper case letters for set flags.
+func FlagString(status processor.Status) string {
+	const names = "NVUBDIZC"
+
+	var builder strings.Builder
+	for i := 0; i < 8; i++ {
+		name := names[i]
+		if status&(0x80>>uint(i)) == 0 {
+			name += 'a' - 'A'
+		}
+		builder.WriteByte(name)
+	}
+
+	return builder.String()
+}
+
+func formatBytes(bytes []byte) string {
+	parts := make([]string, len(bytes))
+	for i, value := range bytes {
+		parts[i] = fmt.Sprintf("%02X", value)
+	}
+	return strings.Join(parts, " ")
+}
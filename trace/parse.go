@@ -0,0 +1,137 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"nessie/processor"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Record contains the values of a single parsed trace line. Cycles and Value are only meaningful if the line actually
+// contained a cycle count or a memory annotation, which is indicated by HasCycles and HasValue.
+type Record struct {
+	Registers processor.Registers
+	Cycles    processor.Cycles
+	HasCycles bool
+	Value     uint8
+	HasValue  bool
+	Line      string
+}
+
+var (
+	nestestRegexp = regexp.MustCompile(
+		`^([[:xdigit:]]{4})\s+(?:(?:[[:xdigit:]]{2} ){0,2}[[:xdigit:]]{2}\s+[ *](.*?)\s+)?` +
+			`A:([[:xdigit:]]{2}) X:([[:xdigit:]]{2}) Y:([[:xdigit:]]{2}) P:([[:xdigit:]]{2}) SP:([[:xdigit:]]{2})` +
+			`(?: PPU:\s*\d+,\s*\d+)?(?: CYC:(\d+))?`,
+	)
+	mesenRegexp = regexp.MustCompile(
+		`^([[:xdigit:]]{4})\s+(?:(.*?)\s+)?` +
+			`A:([[:xdigit:]]{2}) X:([[:xdigit:]]{2}) Y:([[:xdigit:]]{2}) S:([[:xdigit:]]{2}) P:([[:alpha:]-]{8}|[[:xdigit:]]{2})` +
+			`(?: V:\s*\d+\s+H:\s*\d+)?(?:\s+Cycle:(\d+))?`,
+	)
+	fceuxRegexp = regexp.MustCompile(
+		`^(?:c(\d+)\s+)?(?:i\d+\s+)?` +
+			`A:([[:xdigit:]]{2}) X:([[:xdigit:]]{2}) Y:([[:xdigit:]]{2}) S:([[:xdigit:]]{2}) P:([[:alpha:]-]{8})\s+` +
+			`\$([[:xdigit:]]{4})(?::(?:[[:xdigit:]]{2} ?){1,3}\s+(.*))?$`,
+	)
+	valueRegexp = regexp.MustCompile(`= #?\$?([[:xdigit:]]{2})$`)
+)
+
+// Parse extracts the register values, cycle count and accessed memory value from a single trace line.
+func Parse(format Format, line string) (record Record, err error) {
+	record.Line = line
+
+	var pc, a, x, y, s, p, cycles, disassembly string
+	switch format {
+	case FormatNestest:
+		matches := nestestRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			return record, fmt.Errorf("invalid nestest trace line: %q", line)
+		}
+		pc, disassembly, a, x, y, p, s, cycles = matches[1], matches[2], matches[3], matches[4], matches[5],
+			matches[6], matches[7], matches[8]
+	case FormatMesen:
+		matches := mesenRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			return record, fmt.Errorf("invalid mesen trace line: %q", line)
+		}
+		pc, disassembly, a, x, y, s, p, cycles = matches[1], matches[2], matches[3], matches[4], matches[5],
+			matches[6], matches[7], matches[8]
+	case FormatFCEUX:
+		matches := fceuxRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			return record, fmt.Errorf("invalid fceux trace line: %q", line)
+		}
+		cycles, a, x, y, s, p, pc, disassembly = matches[1], matches[2], matches[3], matches[4], matches[5],
+			matches[6], matches[7], matches[8]
+	case FormatJSON:
+		return parseJSON(line)
+	default:
+		return record, fmt.Errorf("unsupported trace format: %s", FormatName(format))
+	}
+
+	record.Registers.PC = uint16(parseHex(pc))
+	record.Registers.A = uint8(parseHex(a))
+	record.Registers.X = uint8(parseHex(x))
+	record.Registers.Y = uint8(parseHex(y))
+	record.Registers.S = uint8(parseHex(s))
+	record.Registers.P = parseStatus(p)
+
+	if cycles != "" {
+		value, _ := strconv.ParseUint(cycles, 10, 64)
+		record.Cycles, record.HasCycles = processor.Cycles(value), true
+	}
+	if matches := valueRegexp.FindStringSubmatch(strings.TrimSpace(disassembly)); matches != nil {
+		record.Value, record.HasValue = uint8(parseHex(matches[1])), true
+	}
+
+	return
+}
+
+func parseJSON(line string) (record Record, err error) {
+	record.Line = line
+
+	var entry jsonEntry
+	if err = json.Unmarshal([]byte(line), &entry); err != nil {
+		return record, fmt.Errorf("invalid json trace line: %v", err)
+	}
+
+	record.Registers = processor.Registers{
+		PC: entry.PC,
+		P:  processor.Status(entry.P),
+		S:  entry.S,
+		A:  entry.A,
+		X:  entry.X,
+		Y:  entry.Y,
+	}
+	if entry.Cycles != nil {
+		record.Cycles, record.HasCycles = processor.Cycles(*entry.Cycles), true
+	}
+	if entry.Value != nil {
+		record.Value, record.HasValue = *entry.Value, true
+	}
+
+	return
+}
+
+// parseStatus accepts either hexadecimal values or flag strings as returned by FlagString
+func parseStatus(value string) (status processor.Status) {
+	if len(value) == 2 {
+		return processor.Status(parseHex(value))
+	}
+
+	for i := 0; i < len(value) && i < 8; i++ {
+		if value[i] >= 'A' && value[i] <= 'Z' {
+			status |= 0x80 >> uint(i)
+		}
+	}
+	return
+}
+
+// parseHex is only called with values which have already been validated by a regular expression
+func parseHex(value string) uint64 {
+	result, _ := strconv.ParseUint(value, 16, 32)
+	return result
+}
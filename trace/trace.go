@@ -0,0 +1,137 @@
+package trace
+
+import (
+	"io"
+	"nessie/processor"
+)
+
+type Format int
+
+const (
+	FormatNestest Format = iota
+	FormatMesen
+	FormatFCEUX
+	FormatJSON
+)
+
+type Options struct {
+	Format Format
+
+	// RegistersOnly omits the instruction bytes and disassembly from every line
+	RegistersOnly bool
+	// EffectiveAddress annotates memory operands with their effective address and value, e.g. `STX $00 = 00`
+	EffectiveAddress bool
+	// Cycles appends the total amount of CPU cycles which were spent before executing the instruction
+	Cycles bool
+	// PPU optionally returns the current PPU position, which gets included in formats supporting it
+	PPU func() (scanline int, dot int)
+}
+
+// Entry contains the CPU state right before an instruction gets executed, which is everything needed to render a line
+// in any of the supported trace formats.
+type Entry struct {
+	Registers   processor.Registers
+	Cycles      processor.Cycles
+	Instruction processor.Instruction
+	Bytes       []byte
+	Effective   Effective
+	Scanline    int
+	Dot         int
+	HasPPU      bool
+}
+
+// Logger writes a trace line for every instruction executed by the attached CPU. Interrupt sequences are not logged
+// as they are not instructions, just like in the traces of most other emulators.
+type Logger struct {
+	cpu     *processor.CPU
+	writer  io.Writer
+	options Options
+	hookID  processor.HookID
+	buffer  []byte
+	err     error
+}
+
+func Attach(cpu *processor.CPU, writer io.Writer, options Options) *Logger {
+	l := &Logger{cpu: cpu, writer: writer, options: options}
+	l.hookID = cpu.OnBeforeInstruction(l.onBeforeInstruction)
+	return l
+}
+
+func (l *Logger) Detach() {
+	l.cpu.RemoveHook(l.hookID)
+}
+
+// Err returns the first error returned by the underlying writer. Logging stops as soon as an error occurred.
+func (l *Logger) Err() error {
+	return l.err
+}
+
+func (l *Logger) onBeforeInstruction(pc uint16, opcode processor.Opcode, instruction *processor.Instruction) {
+	if l.err != nil {
+		return
+	}
+
+	// The hook runs after the opcode has been fetched, which always takes exactly one cycle
+	entry := capture(l.cpu, pc, l.cpu.TotalCycles-1, l.options)
+	l.buffer = AppendLine(l.buffer[:0], entry, l.options)
+	_, l.err = l.writer.Write(l.buffer)
+}
+
+// Capture returns the trace entry for the instruction at the current program counter of the CPU.
+func Capture(cpu *processor.CPU, options Options) Entry {
+	return capture(cpu, cpu.Registers.PC, cpu.TotalCycles, options)
+}
+
+func capture(cpu *processor.CPU, pc uint16, cycles processor.Cycles, options Options) (entry Entry) {
+	entry.Registers = cpu.Registers
+	entry.Registers.PC = pc
+	entry.Cycles = cycles
+	entry.Instruction, entry.Bytes, _ = cpu.Decode(pc)
+	if entry.Bytes == nil {
+		entry.Bytes = []byte{cpu.Memory.DebugPeek(pc)}
+	}
+	if options.EffectiveAddress {
+		entry.Effective = resolve(cpu, entry)
+	}
+	if options.PPU != nil {
+		entry.Scanline, entry.Dot = options.PPU()
+		entry.HasPPU = true
+	}
+
+	return
+}
+
+// Line renders a single trace entry including the trailing newline.
+func Line(entry Entry, options Options) string {
+	return string(AppendLine(nil, entry, options))
+}
+
+func AppendLine(buffer []byte, entry Entry, options Options) []byte {
+	switch options.Format {
+	case FormatMesen:
+		buffer = appendMesen(buffer, entry, options)
+	case FormatFCEUX:
+		buffer = appendFCEUX(buffer, entry, options)
+	case FormatJSON:
+		buffer = appendJSON(buffer, entry, options)
+	default:
+		buffer = appendNestest(buffer, entry, options)
+	}
+
+	return append(buffer, '\n')
+}
+
+func FormatName(format Format) string {
+	switch format {
+	case FormatNestest:
+		return "nestest"
+	case FormatMesen:
+		return "mesen"
+	case FormatFCEUX:
+		return "fceux"
+	case FormatJSON:
+		return "json"
+	default:
+		return "<unknown>"
+	}
+}
@@ -0,0 +1,166 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"nessie/cartridge"
+	"nessie/processor"
+	"os"
+	"regexp"
+	"testing"
+)
+
+const testLocation uint16 = 0xC000
+
+func newTestCPU(program ...uint8) *processor.CPU {
+	cpu := processor.NewCPU()
+	cpu.Registers.PC = testLocation
+	cpu.Registers.P = processor.FlagUnused | processor.FlagInterruptDisable
+	for i, value := range program {
+		cpu.Memory.Poke(testLocation+uint16(i), value)
+	}
+	return cpu
+}
+
+func TestNESTestTrace(t *testing.T) {
+	cpu := processor.NewCPU()
+	cpu.Registers.PC = 0xC000
+	cpu.Registers.P = 0x24
+	cpu.TotalCycles = 7
+
+	rom, err := cartridge.LoadROM("../system/roms/nestest.nes")
+	assert.NoError(t, err)
+	assert.NoError(t, cpu.Memory.AddMappings(rom, processor.MappingCPU))
+
+	var output bytes.Buffer
+	logger := Attach(cpu, &output, Options{Format: FormatNestest, EffectiveAddress: true, Cycles: true})
+	for i := 0; i < 8991 && !cpu.Halted; i++ {
+		assert.NoError(t, cpu.Execute())
+	}
+	logger.Detach()
+	assert.NoError(t, logger.Err())
+
+	// The PPU position is not available without a PPU, so it gets stripped from the golden log. Reads of the APU
+	// registers return open bus on a real console, which is not emulated yet, so these lines are skipped as well.
+	logFile, err := os.Open("../system/roms/nestest.log")
+	assert.NoError(t, err)
+	defer logFile.Close()
+	ppuRegexp := regexp.MustCompile(` PPU:\s*\d+,\s*\d+`)
+	apuRegexp := regexp.MustCompile(`\$40[01][[:xdigit:]] = `)
+
+	golden := bufio.NewScanner(logFile)
+	actual := bufio.NewScanner(&output)
+	lines := 0
+	for golden.Scan() && actual.Scan() {
+		lines++
+		if apuRegexp.MatchString(golden.Text()) {
+			continue
+		}
+		if !assert.Equal(t, ppuRegexp.ReplaceAllString(golden.Text(), ""), actual.Text(), "unexpected trace line") {
+			break
+		}
+	}
+	assert.Equal(t, 8991, lines, "unexpected amount of trace lines")
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, os.ErrClosed
+}
+
+func TestFormats(t *testing.T) {
+	// STA ($10),Y
+	cpu := newTestCPU(0x91, 0x10)
+	cpu.TotalCycles = 42
+	cpu.Registers.A = 0x5A
+	cpu.Registers.Y = 0x05
+	cpu.Memory.Poke16(0x0010, 0x0300)
+	cpu.Memory.Poke(0x0305, 0x99)
+	ppu := func() (int, int) { return 241, 12 }
+
+	testFormat := func(options Options, expected string) {
+		options.PPU = ppu
+		assert.Equal(t, expected+"\n", Line(Capture(cpu, options), options), "unexpected line for %s", FormatName(options.Format))
+	}
+
+	testFormat(Options{Format: FormatNestest, EffectiveAddress: true, Cycles: true},
+		"C000  91 10     STA ($10),Y = 0300 @ 0305 = 99  A:5A X:00 Y:05 P:24 SP:FD PPU: 12,241 CYC:42")
+	testFormat(Options{Format: FormatNestest, RegistersOnly: true},
+		"C000  A:5A X:00 Y:05 P:24 SP:FD PPU: 12,241")
+	testFormat(Options{Format: FormatMesen, EffectiveAddress: true, Cycles: true},
+		"C000  STA ($10),Y [$0305] = $99        A:5A X:00 Y:05 S:FD P:nvUbdIzc V:241 H:12  Cycle:42")
+	testFormat(Options{Format: FormatFCEUX, EffectiveAddress: true, Cycles: true},
+		"c42          A:5A X:00 Y:05 S:FD P:nvUbdIzc  $C000:91 10     STA ($10),Y @ $0305 = #$99")
+	testFormat(Options{Format: FormatFCEUX},
+		"A:5A X:00 Y:05 S:FD P:nvUbdIzc  $C000:91 10     STA ($10),Y")
+	testFormat(Options{Format: FormatJSON, EffectiveAddress: true, Cycles: true},
+		`{"pc":49152,"bytes":"91 10","disassembly":"STA ($10),Y","a":90,"x":0,"y":5,"s":253,"p":36,"cycles":42,`+
+			`"scanline":241,"dot":12,"address":773,"value":153}`)
+	testFormat(Options{Format: FormatJSON, RegistersOnly: true},
+		`{"pc":49152,"a":90,"x":0,"y":5,"s":253,"p":36,"scanline":241,"dot":12}`)
+}
+
+func TestEffectiveAddress(t *testing.T) {
+	testEffective := func(cpu *processor.CPU, expected Effective) {
+		entry := Capture(cpu, Options{EffectiveAddress: true})
+		assert.Equal(t, expected, entry.Effective, "unexpected effective address for %s", Disassemble(entry))
+	}
+
+	cpu := newTestCPU(0xB5, 0xF0)
+	cpu.Registers.X = 0x20
+	cpu.Memory.Poke(0x0010, 0x42)
+	testEffective(cpu, Effective{Kind: EffectiveIndexed, Address: 0x0010, Value: 0x42, ZeroPage: true})
+
+	cpu = newTestCPU(0xA1, 0xFF)
+	cpu.Registers.X = 0x01
+	cpu.Memory.Poke16(0x0000, 0x0300)
+	testEffective(cpu, Effective{Kind: EffectivePreIndexed, Pointer: 0x0000, Address: 0x0300})
+
+	cpu = newTestCPU(0x6C, 0xFF, 0x02)
+	cpu.Memory.Poke(0x02FF, 0x34)
+	cpu.Memory.Poke(0x0200, 0x12)
+	testEffective(cpu, Effective{Kind: EffectiveJump, Pointer: 0x02FF, Address: 0x1234})
+
+	cpu = newTestCPU(0x4C, 0x00, 0x80)
+	testEffective(cpu, Effective{})
+}
+
+func TestDisassemble(t *testing.T) {
+	testDisassemble := func(cpu *processor.CPU, expected string) {
+		assert.Equal(t, expected, Disassemble(Capture(cpu, Options{})), "unexpected disassembly")
+	}
+
+	testDisassemble(newTestCPU(0xD0, 0xFE), "BNE $C000")
+	testDisassemble(newTestCPU(0x10, 0x10), "BPL $C012")
+	testDisassemble(newTestCPU(0x0A), "ASL A")
+	testDisassemble(newTestCPU(0xEA), "NOP")
+	testDisassemble(newTestCPU(0xBE, 0x34, 0x12), "LDX $1234,Y")
+
+	cpu := processor.NewCPU(processor.WithVariant(processor.WDC65C02))
+	cpu.Registers.PC = testLocation
+	cpu.Memory.Poke(testLocation, 0x0F)
+	cpu.Memory.Poke(testLocation+1, 0x10)
+	cpu.Memory.Poke(testLocation+2, 0xFD)
+	testDisassemble(cpu, "BBR0 $10,$C000")
+}
+
+func TestFlagString(t *testing.T) {
+	assert.Equal(t, "nvUbdIzc", FlagString(0x24))
+	assert.Equal(t, "NVUBDIZC", FlagString(0xFF))
+}
+
+func TestLoggerError(t *testing.T) {
+	cpu := newTestCPU(0xEA, 0xEA)
+	writer := &failingWriter{}
+	logger := Attach(cpu, writer, Options{})
+
+	assert.NoError(t, cpu.Execute())
+	assert.NoError(t, cpu.Execute())
+	assert.Equal(t, os.ErrClosed, logger.Err(), "expected write error to be reported")
+	assert.Equal(t, 1, writer.writes, "expected logging to stop after an error")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"nessie/cartridge"
+	"nessie/processor"
+	"nessie/trace"
+	"os"
+	"strings"
+)
+
+// runTraceDiff runs a ROM alongside a golden trace and prints the first divergence. The exit code is 0 if both
+// traces matched, 1 if they diverged and 2 if the comparison could not be performed.
+func runTraceDiff(args []string) int {
+	flags := flag.NewFlagSet("tracediff", flag.ContinueOnError)
+	format := flags.String("format", "nestest", "format of the golden trace: nestest, mesen, fceux or json")
+	context := flags.Int("context", 10, "amount of instructions shown before the divergence")
+	limit := flags.Int("limit", 0, "maximum amount of golden trace lines to compare, 0 compares all lines")
+	ignore := flags.String("ignore", "", "comma-separated fields to ignore: pc, a, x, y, s, p, cycles, memory")
+	synchronize := flags.Bool("sync", true, "initialize registers and cycles from the first golden trace line")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: nessie tracediff [options] <rom> <golden trace>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	options := trace.DiffOptions{Context: *context, Limit: *limit, Synchronize: *synchronize}
+	var err error
+	if options.Format, err = trace.ParseFormat(*format); err != nil {
+		return fail("tracediff", err)
+	}
+	if options.Ignore, err = parseFields(*ignore); err != nil {
+		return fail("tracediff", err)
+	}
+
+	rom, err := cartridge.LoadROM(flags.Arg(0))
+	if err != nil {
+		return fail("tracediff", err)
+	}
+	cpu := processor.NewCPU()
+	if err := cpu.Memory.AddMappings(rom, processor.MappingCPU); err != nil {
+		return fail("tracediff", err)
+	}
+	if !options.Synchronize {
+		cpu.Reset()
+	}
+
+	golden, err := os.Open(flags.Arg(1))
+	if err != nil {
+		return fail("tracediff", err)
+	}
+	defer golden.Close()
+
+	divergence, err := trace.Diff(cpu, golden, options)
+	if err != nil {
+		return fail("tracediff", err)
+	}
+	if divergence != nil {
+		fmt.Print(divergence)
+		return 1
+	}
+
+	fmt.Println("traces match")
+	return 0
+}
+
+func parseFields(value string) (fields trace.Field, err error) {
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		found := false
+		for field := trace.FieldPC; field <= trace.FieldMemory; field <<= 1 {
+			if strings.EqualFold(trace.FieldName(field), name) {
+				fields |= field
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown field: %s", name)
+		}
+	}
+
+	return
+}
+
+func fail(command string, err error) int {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+	return 2
+}